@@ -0,0 +1,73 @@
+package lib
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// AccessLogEntry structure
+type AccessLogEntry struct {
+	Time      time.Time     `json:"time"`
+	ClientIP  string        `json:"clientIp"`
+	Method    string        `json:"method"`
+	URL       string        `json:"url"`
+	GisInfo   *GisInfo      `json:"gisInfo"`
+	Status    int           `json:"status"`
+	Bytes     int64         `json:"bytes"`
+	Duration  time.Duration `json:"duration"`
+	RequestID string        `json:"requestId,omitempty"`
+}
+
+// AccessLogger defines access log function
+type AccessLogger func(entry AccessLogEntry)
+
+// SetAccessLogger sets function called with an entry once each proxied request is written
+func (gp *GisProxy) SetAccessLogger(accessLogger AccessLogger) {
+	gp.accessLogger = accessLogger
+}
+
+// observation structure, proxied request observed from proxy entry, forward url and GisInfo are
+// known once forward url is computed
+type observation struct {
+	request    *http.Request
+	forwardUrl *url.URL
+	gisInfo    *GisInfo
+	recorder   *responseRecorder
+	start      time.Time
+}
+
+// newObservation starts observation of request, response is written to returned observation recorder
+func (gp *GisProxy) newObservation(writer http.ResponseWriter, request *http.Request) *observation {
+	return &observation{request: request, recorder: newResponseRecorder(writer, &gp.totalBytesServed), start: time.Now()}
+}
+
+// observe records metrics and access log of proxied request
+func (gp *GisProxy) observe(obs *observation) {
+	duration := time.Since(obs.start)
+	gisInfo := obs.gisInfo
+	if gisInfo == nil {
+		// Rejected before forward url is computed
+		gisInfo = &GisInfo{}
+	}
+	if gp.metrics != nil {
+		gp.metrics.observe(gisInfo, obs.recorder.Status(), obs.recorder.Written(), duration)
+	}
+	if gp.accessLogger != nil {
+		forwardUrl := ""
+		if obs.forwardUrl != nil {
+			forwardUrl = redactURL(obs.forwardUrl)
+		}
+		gp.accessLogger(AccessLogEntry{
+			Time:      obs.start,
+			ClientIP:  gp.clientIP(obs.request),
+			Method:    obs.request.Method,
+			URL:       forwardUrl,
+			GisInfo:   gisInfo,
+			Status:    obs.recorder.Status(),
+			Bytes:     obs.recorder.Written(),
+			Duration:  duration,
+			RequestID: RequestIDFromContext(obs.request.Context()),
+		})
+	}
+}
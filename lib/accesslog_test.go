@@ -0,0 +1,82 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAccessLog(t *testing.T) {
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		writer.Write([]byte("tile"))
+	})
+	segment := encodeSegment(upstream.URL + "/tile")
+	tests := []struct {
+		name      string
+		configure func(gp *GisProxy)
+		method    string
+		target    string
+		body      string
+		status    int
+		url       string
+		bytes     int64
+	}{
+		{"forwarded", func(gp *GisProxy) {}, "GET", "/gisproxy/" + segment + "?token=secret", "", http.StatusOK, upstream.URL + "/tile?token=xxx", 4},
+		{"invalid forward url", func(gp *GisProxy) {}, "GET", "/gisproxy/" + encodeSegment("file:///etc/passwd"), "", http.StatusBadRequest, "", 0},
+		{"method not allowed", func(gp *GisProxy) {
+			gp.SetAllowedMethods([]string{"GET"})
+		}, "DELETE", "/gisproxy/" + segment, "", http.StatusMethodNotAllowed, upstream.URL + "/tile", 0},
+		{"rate limited", func(gp *GisProxy) {
+			gp.SetRateLimit(0.001, 1)
+			gp.rateLimiter.allow("192.0.2.1")
+		}, "GET", "/gisproxy/" + segment, "", http.StatusTooManyRequests, upstream.URL + "/tile", 0},
+		{"request body too large", func(gp *GisProxy) {
+			gp.SetMaxRequestBodyBytes(4)
+		}, "POST", "/gisproxy/" + segment, "too large", http.StatusRequestEntityTooLarge, upstream.URL + "/tile", 0},
+		{"connection limit", func(gp *GisProxy) {
+			gp.SetMaxConnsPerClient(1)
+			gp.connLimiter.acquire("192.0.2.1")
+		}, "GET", "/gisproxy/" + segment, "", http.StatusTooManyRequests, "", 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var entries []AccessLogEntry
+			gp := NewGisProxyHandler("/gisproxy/", false)
+			gp.SetAccessLogger(func(entry AccessLogEntry) {
+				entries = append(entries, entry)
+			})
+			test.configure(gp)
+			recorder := serve(gp, httptest.NewRequest(test.method, test.target, strings.NewReader(test.body)))
+			if recorder.Code != test.status {
+				t.Errorf("status %v, want %v", recorder.Code, test.status)
+			}
+			if len(entries) != 1 {
+				t.Fatalf("%v access log entries, want 1", len(entries))
+			}
+			entry := entries[0]
+			if entry.Status != test.status || entry.URL != test.url || entry.ClientIP != "192.0.2.1" || entry.Method != test.method {
+				t.Errorf("entry %+v, want status %v and url %v", entry, test.status, test.url)
+			}
+			if test.bytes > 0 && entry.Bytes != test.bytes {
+				t.Errorf("entry bytes %v, want %v", entry.Bytes, test.bytes)
+			}
+			if entry.Bytes != int64(recorder.Body.Len()) {
+				t.Errorf("entry bytes %v, want written %v", entry.Bytes, recorder.Body.Len())
+			}
+		})
+	}
+}
+
+func TestAccessLogSkipsNextHandler(t *testing.T) {
+	var entries int
+	gp := NewGisProxyHandler("/gisproxy/", false)
+	gp.SetAccessLogger(func(entry AccessLogEntry) {
+		entries++
+	})
+	gp.SetNextHandler(http.NotFoundHandler())
+	serve(gp, httptest.NewRequest("GET", "/index.html", nil))
+	if entries != 0 {
+		t.Errorf("%v access log entries, want 0", entries)
+	}
+}
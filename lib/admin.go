@@ -0,0 +1,58 @@
+package lib
+
+import (
+	"net"
+	"net/http"
+)
+
+// AddAdminListener serves health, readiness, metrics, debug and cache invalidation endpoints on a
+// second server listening on addr, started and stopped with proxy server. These endpoints are then
+// no longer served on proxy listener. Debug and cache invalidation endpoints are only served on
+// admin listener.
+func (gp *GisProxy) AddAdminListener(addr string) {
+	gp.adminServer = &http.Server{Addr: addr, Handler: http.HandlerFunc(gp.serveAdmin)}
+}
+
+// handleAdmin serves admin path with handler, on admin listener when there is one
+func (gp *GisProxy) handleAdmin(path string, handler http.Handler) {
+	if gp.adminHandlers == nil {
+		gp.adminHandlers = make(map[string]http.Handler)
+	}
+	gp.adminHandlers[path] = handler
+}
+
+// handleAdminOnly serves admin path with handler on admin listener only, never on proxy listener
+func (gp *GisProxy) handleAdminOnly(path string, handler http.Handler) {
+	gp.handleAdmin(path, handler)
+	if gp.adminOnlyPaths == nil {
+		gp.adminOnlyPaths = make(map[string]bool)
+	}
+	gp.adminOnlyPaths[path] = true
+}
+
+// serveAdmin serves admin listener requests, other paths are not found
+func (gp *GisProxy) serveAdmin(writer http.ResponseWriter, request *http.Request) {
+	if handler, ok := gp.adminHandlers[request.URL.Path]; ok {
+		handler.ServeHTTP(writer, request)
+		return
+	}
+	http.NotFound(writer, request)
+}
+
+// startAdmin starts admin server in background, returns listen error
+func (gp *GisProxy) startAdmin() error {
+	if gp.adminServer == nil {
+		return nil
+	}
+	listener, err := net.Listen("tcp", gp.adminServer.Addr)
+	if err != nil {
+		return err
+	}
+	gp.logger.Infof("AdminListen=%v", gp.adminServer.Addr)
+	go func() {
+		if err := gp.adminServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+			gp.logger.Errorf("Admin server error %v", err)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,47 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdminEndpoints(t *testing.T) {
+	tests := []struct {
+		name   string
+		admin  bool
+		method string
+		path   string
+		proxy  int
+		server int
+	}{
+		{"health without admin listener", false, "GET", "/health", http.StatusOK, 0},
+		{"debug without admin listener", false, "GET", "/debug", http.StatusInternalServerError, 0},
+		{"purge without admin listener", false, "POST", "/purge", http.StatusInternalServerError, 0},
+		{"health with admin listener", true, "GET", "/health", http.StatusInternalServerError, http.StatusOK},
+		{"debug with admin listener", true, "GET", "/debug", http.StatusInternalServerError, http.StatusOK},
+		{"purge with admin listener", true, "POST", "/purge", http.StatusInternalServerError, http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gp := NewGisProxyHandler("/gisproxy/", false)
+			gp.UseCache(10, 0)
+			if test.admin {
+				gp.AddAdminListener("127.0.0.1:0")
+			}
+			gp.SetHealthCheckPath("/health")
+			gp.EnableDebugEndpoint("/debug")
+			gp.EnableCacheInvalidationEndpoint("/purge")
+			if recorder := serve(gp, httptest.NewRequest(test.method, test.path, nil)); recorder.Code != test.proxy {
+				t.Errorf("proxy listener status %v, want %v", recorder.Code, test.proxy)
+			}
+			if test.admin {
+				recorder := httptest.NewRecorder()
+				gp.serveAdmin(recorder, httptest.NewRequest(test.method, test.path, nil))
+				if recorder.Code != test.server {
+					t.Errorf("admin listener status %v, want %v", recorder.Code, test.server)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// arcGISErrorMaxBytes is maximum size of response body parsed as ArcGIS JSON error
+const arcGISErrorMaxBytes = 4096
+
+// arcGISError structure
+type arcGISError struct {
+	Error *struct {
+		Code int `json:"code"`
+	} `json:"error"`
+}
+
+// SetArcGISTokenRetry retries once ArcGIS requests answered with an invalid or expired token JSON
+// error (code 498 or 499 in a 200 response), with a token obtained from provider called with a
+// context for which ArcGISTokenRefresh is true. Requests with a body are not retried.
+func (gp *GisProxy) SetArcGISTokenRetry(enabled bool) {
+	gp.arcGISTokenRetry = enabled
+}
+
+// ArcGISTokenRefresh checks if ArcGIS token provider is called to replace a rejected token,
+// provider must then not return a cached token
+func ArcGISTokenRefresh(ctx context.Context) bool {
+	refresh, _ := ctx.Value(contextKey("ArcGISTokenRefresh")).(bool)
+	return refresh
+}
+
+// isArcGISTokenError checks if response is a small ArcGIS JSON token error, response body is restored
+func isArcGISTokenError(response *http.Response) bool {
+	if response.StatusCode != 200 || response.ContentLength > arcGISErrorMaxBytes {
+		return false
+	}
+	contentType := strings.ToLower(response.Header.Get("Content-Type"))
+	if contentType != "" && !strings.HasPrefix(contentType, "application/json") && !strings.HasPrefix(contentType, "text/plain") {
+		return false
+	}
+	if encoding := response.Header.Get("Content-Encoding"); encoding != "" && !strings.EqualFold(encoding, "identity") {
+		return false
+	}
+	head, err := ioutil.ReadAll(io.LimitReader(response.Body, arcGISErrorMaxBytes+1))
+	response.Body = &readCloser{Reader: io.MultiReader(bytes.NewReader(head), response.Body), Closer: response.Body}
+	if err != nil || len(head) > arcGISErrorMaxBytes {
+		return false
+	}
+	var body arcGISError
+	if json.Unmarshal(head, &body) != nil || body.Error == nil {
+		return false
+	}
+	return body.Error.Code == 498 || body.Error.Code == 499
+}
+
+// retryArcGISToken sends request again with refreshed token when response is an ArcGIS token error
+func (gp *GisProxy) retryArcGISToken(ctx context.Context, request *http.Request, response *http.Response) (*http.Response, error) {
+	if !gp.arcGISTokenRetry || gp.arcGISTokenProvider == nil || (request.Body != nil && request.Body != http.NoBody) {
+		return response, nil
+	}
+	info := GisInfoFromContext(ctx)
+	if info == nil || info.ServerType != "ArcGIS" || !isArcGISTokenError(response) {
+		return response, nil
+	}
+	token, err := gp.arcGISTokenProvider(context.WithValue(ctx, contextKey("ArcGISTokenRefresh"), true), info)
+	if err != nil {
+		gp.logger.Errorf("ArcGIS token error %v %v", err, redactURL(request.URL))
+		return response, nil
+	}
+	if token == "" {
+		return response, nil
+	}
+	response.Body.Close()
+	retryRequest := request.Clone(ctx)
+	setQueryParam(retryRequest.URL, "token", token)
+	gp.logger.Infof("ArcGIS token retry %v", redactURL(retryRequest.URL))
+	return gp.doRequest(retryRequest)
+}
@@ -0,0 +1,111 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestArcGISTokenProvider(t *testing.T) {
+	var token string
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		token = request.URL.Query().Get("token")
+		writer.Write([]byte(`{"layers":[]}`))
+	})
+	tests := []struct {
+		name       string
+		path       string
+		providerOK bool
+		status     int
+		token      string
+	}{
+		{"arcgis service", "/arcgis/rest/services/roads/MapServer?f=json", true, http.StatusOK, "secret"},
+		{"client token replaced", "/arcgis/rest/services/roads/MapServer?f=json&token=client", true, http.StatusOK, "secret"},
+		{"not arcgis", "/wms?SERVICE=WMS&REQUEST=GetCapabilities", true, http.StatusOK, ""},
+		{"provider error", "/arcgis/rest/services/roads/FeatureServer/0/query", false, http.StatusBadGateway, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			token = ""
+			var beforeSendURL string
+			gp := NewGisProxyHandler("/gisproxy/", false)
+			gp.SetBeforeSendFunc(func(writer http.ResponseWriter, request *http.Request) error {
+				beforeSendURL = request.URL.String()
+				return nil
+			})
+			gp.SetArcGISTokenProvider(func(ctx context.Context, info *GisInfo) (string, error) {
+				if info.ServerType != "ArcGIS" {
+					t.Errorf("provider called for %v", info.ServerType)
+				}
+				if !test.providerOK {
+					return "", errors.New("portal unavailable")
+				}
+				return "secret", nil
+			})
+			recorder := serve(gp, httptest.NewRequest("GET", "/gisproxy/"+encodeSegment(upstream.URL+test.path), nil))
+			if recorder.Code != test.status {
+				t.Errorf("status %v, want %v", recorder.Code, test.status)
+			}
+			if token != test.token {
+				t.Errorf("upstream token %q, want %q", token, test.token)
+			}
+			if strings.Contains(beforeSendURL, "secret") {
+				t.Errorf("token exposed to BeforeSend %v", beforeSendURL)
+			}
+		})
+	}
+}
+
+func TestArcGISTokenRetry(t *testing.T) {
+	tokenError := `{"error":{"code":498,"message":"Invalid token."}}`
+	var tokens []string
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		token := request.URL.Query().Get("token")
+		tokens = append(tokens, token)
+		writer.Header().Set("Content-Type", "application/json")
+		if token != "fresh" || request.URL.Path == "/arcgis/rest/services/expired/MapServer" {
+			writer.Write([]byte(tokenError))
+			return
+		}
+		writer.Write([]byte(`{"layers":[]}`))
+	})
+	tests := []struct {
+		name   string
+		path   string
+		retry  bool
+		tokens []string
+		body   string
+	}{
+		{"refreshed token", "/arcgis/rest/services/roads/MapServer", true, []string{"cached", "fresh"}, `{"layers":[]}`},
+		{"retried once", "/arcgis/rest/services/expired/MapServer", true, []string{"cached", "fresh"}, tokenError},
+		{"retry disabled", "/arcgis/rest/services/roads/MapServer", false, []string{"cached"}, tokenError},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tokens = nil
+			var refreshes int
+			gp := NewGisProxyHandler("/gisproxy/", false)
+			gp.SetArcGISTokenRetry(test.retry)
+			gp.SetArcGISTokenProvider(func(ctx context.Context, info *GisInfo) (string, error) {
+				if ArcGISTokenRefresh(ctx) {
+					refreshes++
+					return "fresh", nil
+				}
+				return "cached", nil
+			})
+			recorder := serve(gp, httptest.NewRequest("GET", "/gisproxy/"+encodeSegment(upstream.URL+test.path+"?f=json"), nil))
+			if recorder.Body.String() != test.body {
+				t.Errorf("body %q, want %q", recorder.Body.String(), test.body)
+			}
+			if strings.Join(tokens, ",") != strings.Join(test.tokens, ",") {
+				t.Errorf("upstream tokens %v, want %v", tokens, test.tokens)
+			}
+			if want := len(test.tokens) - 1; refreshes != want {
+				t.Errorf("%v token refreshes, want %v", refreshes, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Circuit states
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half-open"
+)
+
+// CircuitState structure
+type CircuitState struct {
+	Host     string
+	State    string
+	Failures int
+	OpenedAt time.Time
+}
+
+// circuit structure
+type circuit struct {
+	state        string
+	failures     int
+	firstFailure time.Time
+	openedAt     time.Time
+}
+
+// circuitBreaker structure
+type circuitBreaker struct {
+	mutex            sync.Mutex
+	failureThreshold int
+	window           time.Duration
+	cooldown         time.Duration
+	circuits         map[string]*circuit
+}
+
+// SetCircuitBreaker fails fast with 503 requests to upstream host after failureThreshold consecutive
+// failures (connection errors, timeouts and 5xx responses) within window. After cooldown a single
+// probe request is let through: its success closes circuit, its failure opens it again.
+// Zero failureThreshold disables circuit breaker.
+func (gp *GisProxy) SetCircuitBreaker(failureThreshold int, window time.Duration, cooldown time.Duration) {
+	if failureThreshold <= 0 {
+		gp.circuitBreaker = nil
+		return
+	}
+	gp.circuitBreaker = &circuitBreaker{
+		failureThreshold: failureThreshold,
+		window:           window,
+		cooldown:         cooldown,
+		circuits:         make(map[string]*circuit),
+	}
+}
+
+// CircuitBreakerStates returns circuit state of upstream hosts sorted by host
+func (gp *GisProxy) CircuitBreakerStates() []CircuitState {
+	if gp.circuitBreaker == nil {
+		return nil
+	}
+	return gp.circuitBreaker.states()
+}
+
+// allow checks if request to host is allowed
+func (cb *circuitBreaker) allow(host string) error {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	c, ok := cb.circuits[host]
+	if !ok || c.state == CircuitClosed {
+		return nil
+	}
+	if c.state == CircuitOpen && time.Since(c.openedAt) >= cb.cooldown {
+		// Only this request probes host, others fail fast until it completes
+		c.state = CircuitHalfOpen
+		return nil
+	}
+	return NewStatusError("Circuit open for host "+host, 503)
+}
+
+// report records result of request to host
+func (cb *circuitBreaker) report(host string, err error, statusCode int) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	c, ok := cb.circuits[host]
+	if !ok {
+		c = &circuit{state: CircuitClosed}
+		cb.circuits[host] = c
+	}
+	if errors.Is(err, context.Canceled) {
+		// Cancelled by client, not an upstream failure
+		if c.state == CircuitHalfOpen {
+			c.state = CircuitOpen
+		}
+		return
+	}
+	failed := err != nil || statusCode >= 500
+	now := time.Now()
+	switch c.state {
+	case CircuitHalfOpen:
+		if failed {
+			c.state = CircuitOpen
+			c.openedAt = now
+		} else {
+			c.state = CircuitClosed
+			c.failures = 0
+		}
+	case CircuitClosed:
+		if !failed {
+			c.failures = 0
+			return
+		}
+		if c.failures == 0 || now.Sub(c.firstFailure) > cb.window {
+			c.failures = 0
+			c.firstFailure = now
+		}
+		c.failures++
+		if c.failures >= cb.failureThreshold {
+			c.state = CircuitOpen
+			c.openedAt = now
+		}
+	}
+}
+
+// states returns copy of circuit states
+func (cb *circuitBreaker) states() []CircuitState {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	states := make([]CircuitState, 0, len(cb.circuits))
+	for host, c := range cb.circuits {
+		states = append(states, CircuitState{Host: host, State: c.state, Failures: c.failures, OpenedAt: c.openedAt})
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Host < states[j].Host
+	})
+	return states
+}
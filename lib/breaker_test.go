@@ -0,0 +1,84 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	var requests int32
+	var status int32 = http.StatusInternalServerError
+	probing := make(chan struct{})
+	release := make(chan struct{})
+	var blockProbe int32
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if atomic.CompareAndSwapInt32(&blockProbe, 1, 0) {
+			probing <- struct{}{}
+			<-release
+		}
+		writer.WriteHeader(int(atomic.LoadInt32(&status)))
+	})
+	const cooldown = 50 * time.Millisecond
+	gp := NewGisProxyHandler("/gisproxy/", false)
+	gp.SetCircuitBreaker(2, time.Minute, cooldown)
+	target := "/gisproxy/" + encodeSegment(upstream.URL+"/wms")
+	check := func(step string, wantStatus int, wantRequests int32, wantState string) {
+		t.Helper()
+		if recorder := serve(gp, httptest.NewRequest("GET", target, nil)); recorder.Code != wantStatus {
+			t.Errorf("%v: status %v, want %v", step, recorder.Code, wantStatus)
+		}
+		if n := atomic.LoadInt32(&requests); n != wantRequests {
+			t.Errorf("%v: %v upstream requests, want %v", step, n, wantRequests)
+		}
+		if states := gp.CircuitBreakerStates(); len(states) != 1 || states[0].State != wantState {
+			t.Errorf("%v: states %+v, want %v", step, states, wantState)
+		}
+	}
+	check("first failure", http.StatusInternalServerError, 1, CircuitClosed)
+	check("threshold reached", http.StatusInternalServerError, 2, CircuitOpen)
+	check("open fails fast", http.StatusServiceUnavailable, 2, CircuitOpen)
+	time.Sleep(cooldown + 10*time.Millisecond)
+	check("failed probe", http.StatusInternalServerError, 3, CircuitOpen)
+	check("open again", http.StatusServiceUnavailable, 3, CircuitOpen)
+	time.Sleep(cooldown + 10*time.Millisecond)
+	atomic.StoreInt32(&status, http.StatusOK)
+	atomic.StoreInt32(&blockProbe, 1)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if recorder := serve(gp, httptest.NewRequest("GET", target, nil)); recorder.Code != http.StatusOK {
+			t.Errorf("successful probe: status %v, want %v", recorder.Code, http.StatusOK)
+		}
+	}()
+	<-probing
+	// Only probe reaches host while half-open
+	check("half-open fails fast", http.StatusServiceUnavailable, 4, CircuitHalfOpen)
+	close(release)
+	wg.Wait()
+	if states := gp.CircuitBreakerStates(); states[0].State != CircuitClosed || states[0].Failures != 0 {
+		t.Errorf("states %+v after successful probe, want closed", states)
+	}
+	check("closed", http.StatusOK, 5, CircuitClosed)
+}
+
+func TestCircuitBreakerWindow(t *testing.T) {
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusBadGateway)
+	})
+	gp := NewGisProxyHandler("/gisproxy/", false)
+	gp.SetCircuitBreaker(2, 20*time.Millisecond, time.Minute)
+	target := "/gisproxy/" + encodeSegment(upstream.URL)
+	serve(gp, httptest.NewRequest("GET", target, nil))
+	time.Sleep(40 * time.Millisecond)
+	// Failure outside window restarts count
+	serve(gp, httptest.NewRequest("GET", target, nil))
+	if states := gp.CircuitBreakerStates(); len(states) != 1 || states[0].State != CircuitClosed || states[0].Failures != 1 {
+		t.Errorf("states %+v, want closed with 1 failure", states)
+	}
+}
@@ -0,0 +1,41 @@
+package lib
+
+import (
+	"io"
+	"sync"
+)
+
+// defaultCopyBufferSize is size of response copy buffers, a typical tile
+const defaultCopyBufferSize = 32 << 10
+
+// bufferPool structure, pool of byte slices of same size
+type bufferPool struct {
+	size int
+	pool sync.Pool
+}
+
+// newBufferPool constructs bufferPool
+func newBufferPool(size int) *bufferPool {
+	bp := &bufferPool{size: size}
+	bp.pool.New = func() interface{} {
+		buf := make([]byte, size)
+		return &buf
+	}
+	return bp
+}
+
+// SetCopyBufferSize sets size of pooled buffers used to copy response bodies (32KB by default),
+// reused between requests to reduce allocations under high throughput
+func (gp *GisProxy) SetCopyBufferSize(n int) {
+	if n <= 0 {
+		n = defaultCopyBufferSize
+	}
+	gp.copyBuffers = newBufferPool(n)
+}
+
+// copyBuffer copies body with pooled buffer, buffer is returned to pool even on copy error
+func (gp *GisProxy) copyBuffer(writer io.Writer, body io.Reader) (int64, error) {
+	buf := gp.copyBuffers.pool.Get().(*[]byte)
+	defer gp.copyBuffers.pool.Put(buf)
+	return io.CopyBuffer(writer, body, *buf)
+}
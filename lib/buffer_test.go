@@ -0,0 +1,79 @@
+package lib
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// plainWriter hides ReaderFrom of writer so that copy uses its buffer, as a ResponseWriter
+type plainWriter struct {
+	io.Writer
+}
+
+// plainReader hides WriterTo of reader so that copy uses its buffer, as an upstream body
+type plainReader struct {
+	io.Reader
+}
+
+func TestCopyBufferReturnsBufferOnError(t *testing.T) {
+	gp := NewGisProxyHandler("/gisproxy/", false)
+	gp.SetCopyBufferSize(1024)
+	allocated := 0
+	gp.copyBuffers.pool.New = func() interface{} {
+		allocated++
+		buf := make([]byte, 1024)
+		return &buf
+	}
+	body := bytes.Repeat([]byte("x"), 4096)
+	const copies = 100
+	for i := 0; i < copies; i++ {
+		writer := &failingWriter{ResponseRecorder: httptest.NewRecorder(), limit: 2048}
+		if _, err := gp.copyBuffer(writer, &plainReader{bytes.NewReader(body)}); err == nil {
+			t.Fatal("copy error not returned")
+		}
+	}
+	// Pool may drop buffers (race detector, garbage collection), but most copies must reuse one
+	if allocated > copies/2 {
+		t.Errorf("%v buffers allocated for %v failed copies", allocated, copies)
+	}
+}
+
+func BenchmarkCopyBuffer(b *testing.B) {
+	gp := NewGisProxyHandler("/gisproxy/", false)
+	body := bytes.Repeat([]byte("x"), 48<<10)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		gp.copyBuffer(&plainWriter{ioutil.Discard}, &plainReader{bytes.NewReader(body)})
+	}
+}
+
+// BenchmarkCopy measures io.Copy allocating buffer of each response, as done before pooled buffers
+func BenchmarkCopy(b *testing.B) {
+	body := bytes.Repeat([]byte("x"), 48<<10)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		io.Copy(&plainWriter{ioutil.Discard}, &plainReader{bytes.NewReader(body)})
+	}
+}
+
+func BenchmarkServeTile(b *testing.B) {
+	tile := bytes.Repeat([]byte("x"), 48<<10)
+	upstream := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Type", "image/png")
+		writer.Write(tile)
+	}))
+	defer upstream.Close()
+	gp := NewGisProxyHandler("/gisproxy/", false)
+	target := "/gisproxy/" + encodeSegment(upstream.URL+"/0/0/0.png")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if recorder := serve(gp, httptest.NewRequest("GET", target, nil)); recorder.Code != http.StatusOK {
+			b.Fatalf("status %v, want %v", recorder.Code, http.StatusOK)
+		}
+	}
+}
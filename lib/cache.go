@@ -0,0 +1,372 @@
+package lib
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CacheStats structure
+type CacheStats struct {
+	Hits   int64
+	Misses int64
+}
+
+// Cache is a response cache storing body and header of successful responses by key.
+// Implementations must be safe for concurrent use. A shared cache (Redis for instance)
+// stores body and serialized header (JSON of http.Header) under key with ttl expiration,
+// a filesystem cache stores them in files named after a hash of key.
+type Cache interface {
+	// Get returns body and header stored for key, false if absent or expired
+	Get(key string) ([]byte, http.Header, bool)
+	// Set stores body and header for key, zero ttl means no expiration
+	Set(key string, body []byte, header http.Header, ttl time.Duration)
+}
+
+// cacheEntry structure
+type cacheEntry struct {
+	key     string
+	header  http.Header
+	body    []byte
+	expires time.Time
+}
+
+// MemoryCache is an in-memory LRU Cache
+type MemoryCache struct {
+	mutex      sync.Mutex
+	maxEntries int
+	entries    map[string]*list.Element
+	lru        *list.List
+}
+
+// NewMemoryCache constructs MemoryCache keeping at most maxEntries (zero means no limit)
+func NewMemoryCache(maxEntries int) *MemoryCache {
+	return &MemoryCache{
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+	}
+}
+
+// Get returns cache entry body and header
+func (mc *MemoryCache) Get(key string) ([]byte, http.Header, bool) {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+	if elem, ok := mc.entries[key]; ok {
+		entry := elem.Value.(*cacheEntry)
+		if entry.expires.IsZero() || time.Now().Before(entry.expires) {
+			mc.lru.MoveToFront(elem)
+			return entry.body, entry.header.Clone(), true
+		}
+		mc.removeElement(elem)
+	}
+	return nil, nil, false
+}
+
+// Set adds or replaces cache entry, evicting least recently used entries
+func (mc *MemoryCache) Set(key string, body []byte, header http.Header, ttl time.Duration) {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+	entry := &cacheEntry{key: key, header: header.Clone(), body: body}
+	if ttl > 0 {
+		entry.expires = time.Now().Add(ttl)
+	}
+	if elem, ok := mc.entries[key]; ok {
+		elem.Value = entry
+		mc.lru.MoveToFront(elem)
+		return
+	}
+	mc.entries[key] = mc.lru.PushFront(entry)
+	for mc.maxEntries > 0 && mc.lru.Len() > mc.maxEntries {
+		mc.removeElement(mc.lru.Back())
+	}
+}
+
+// removeElement removes element, mutex must be held
+func (mc *MemoryCache) removeElement(elem *list.Element) {
+	mc.lru.Remove(elem)
+	delete(mc.entries, elem.Value.(*cacheEntry).key)
+}
+
+// Invalidate removes entries whose key matches
+func (mc *MemoryCache) Invalidate(match func(key string) bool) int {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+	removed := 0
+	for key, elem := range mc.entries {
+		if match(key) {
+			mc.removeElement(elem)
+			removed++
+		}
+	}
+	return removed
+}
+
+// UseCache uses in-memory cache for successful GET responses
+func (gp *GisProxy) UseCache(maxEntries int, ttl time.Duration) {
+	gp.SetCache(NewMemoryCache(maxEntries))
+	gp.SetCacheTTL(ttl)
+}
+
+// SetCache sets cache for successful GET responses, nil disables cache
+func (gp *GisProxy) SetCache(cache Cache) {
+	gp.cache = cache
+}
+
+// SetCacheTTL sets time to live of cached responses, zero means no expiration
+func (gp *GisProxy) SetCacheTTL(ttl time.Duration) {
+	gp.cacheTTL = ttl
+}
+
+// CacheStats returns cache hits and misses
+func (gp *GisProxy) CacheStats() CacheStats {
+	return CacheStats{Hits: atomic.LoadInt64(&gp.cacheHits), Misses: atomic.LoadInt64(&gp.cacheMisses)}
+}
+
+// SetCacheIgnoreParams sets query parameters (case-insensitive) ignored in cache keys, cache-busters
+// like "_" or "random" for instance
+func (gp *GisProxy) SetCacheIgnoreParams(params []string) {
+	gp.cacheIgnoreParams = make(map[string]bool)
+	for _, param := range params {
+		gp.cacheIgnoreParams[strings.ToLower(param)] = true
+	}
+}
+
+// cacheKey returns cache key of request method and normalized url: query parameters are sorted,
+// their names lower cased (OGC parameter names are case-insensitive) and ignored ones dropped.
+// Credential headers and token parameter are part of key, hashed, except ArcGIS token injected
+// by proxy (see SetArcGISTokenProvider) which is dropped.
+func (gp *GisProxy) cacheKey(request *http.Request) string {
+	u := *request.URL
+	parts := make([]string, 0)
+	for _, part := range strings.Split(u.RawQuery, "&") {
+		if part == "" {
+			continue
+		}
+		name := strings.ToLower(queryKey(part))
+		if gp.cacheIgnoreParams[name] {
+			continue
+		}
+		value := ""
+		if keyValue := strings.SplitN(part, "=", 2); len(keyValue) == 2 {
+			value = keyValue[1]
+		}
+		if name == "token" {
+			if gp.arcGISTokenProvider != nil {
+				if info := GisInfoFromContext(request.Context()); info != nil && info.ServerType == "ArcGIS" {
+					// Token injected by proxy changes on refresh, response does not
+					continue
+				}
+			}
+			value = hashKeyValue(value)
+		}
+		parts = append(parts, url.QueryEscape(name)+"="+value)
+	}
+	sort.SliceStable(parts, func(i, j int) bool {
+		return strings.SplitN(parts[i], "=", 2)[0] < strings.SplitN(parts[j], "=", 2)[0]
+	})
+	u.RawQuery = strings.Join(parts, "&")
+	key := request.Method + " " + u.String()
+	for _, name := range credentialHeaders {
+		// Responses to authenticated requests are never shared with other clients
+		if values, ok := request.Header[name]; ok {
+			key += "\n" + name + ": " + keyHeaderValue(name, values)
+		}
+	}
+	return key
+}
+
+// credentialHeaders lists request headers identifying client in cache keys
+var credentialHeaders = []string{"Authorization", "Cookie"}
+
+// keyHeaderValue returns header value in cache key, credentials are hashed so that they are
+// never stored in clear (file names or meta lines of a disk cache for instance)
+func keyHeaderValue(name string, values []string) string {
+	value := strings.Join(values, ",")
+	for _, credentialHeader := range credentialHeaders {
+		if name == credentialHeader {
+			return hashKeyValue(value)
+		}
+	}
+	return value
+}
+
+// hashKeyValue returns hex SHA-256 hash of credential value in cache key
+func hashKeyValue(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+// variantKey returns cache key of request variant selected by Vary header names
+func variantKey(key string, request *http.Request, vary string) string {
+	names := make([]string, 0)
+	for _, name := range strings.Split(vary, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, http.CanonicalHeaderKey(name))
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		key += "\n" + name + ": " + keyHeaderValue(name, request.Header[name])
+	}
+	return key
+}
+
+// cacheGet returns cached body and header of request, following Vary marker entry
+func (gp *GisProxy) cacheGet(request *http.Request) ([]byte, http.Header, bool) {
+	key := gp.cacheKey(request)
+	body, header, ok := gp.cache.Get(key)
+	if ok {
+		if vary := header.Get("Vary"); vary != "" {
+			// Marker entry of response varying by request header
+			body, header, ok = gp.cache.Get(variantKey(key, request, vary))
+		}
+	}
+	if ok {
+		atomic.AddInt64(&gp.cacheHits, 1)
+	} else {
+		atomic.AddInt64(&gp.cacheMisses, 1)
+	}
+	return body, header, ok
+}
+
+// cacheSet stores body and header of request response, with a Vary marker entry if needed
+func (gp *GisProxy) cacheSet(request *http.Request, body []byte, header http.Header) {
+	key := gp.cacheKey(request)
+	if vary := strings.Join(header["Vary"], ","); vary != "" {
+		gp.cache.Set(key, nil, http.Header{"Vary": []string{vary}}, gp.cacheTTL)
+		key = variantKey(key, request, vary)
+	}
+	gp.cache.Set(key, body, header, gp.cacheTTL)
+}
+
+// cachedResponse builds http response from cached body and header
+func cachedResponse(request *http.Request, body []byte, header http.Header) *http.Response {
+	return &http.Response{
+		Status:        http.StatusText(http.StatusOK),
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       request,
+	}
+}
+
+// doRequest sends request, serving GET responses from cache when enabled
+func (gp *GisProxy) doRequest(request *http.Request) (*http.Response, error) {
+	if gp.cache == nil || request.Method != "GET" || isWebSocketUpgrade(request.Header) {
+		return gp.fetch(request)
+	}
+	if body, header, ok := gp.cacheGet(request); ok {
+		addSpanEvent(request.Context(), "cache hit")
+		if notModified(request, header) {
+			return notModifiedResponse(request, header), nil
+		}
+		return cachedResponse(request, body, header), nil
+	}
+	// Fill cache with full response, conditional request is answered from it
+	upstreamRequest := request
+	if request.Header.Get("If-None-Match") != "" || request.Header.Get("If-Modified-Since") != "" {
+		upstreamRequest = request.Clone(request.Context())
+		upstreamRequest.Header.Del("If-None-Match")
+		upstreamRequest.Header.Del("If-Modified-Since")
+	}
+	response, err := gp.fetch(upstreamRequest)
+	// Large or unknown size responses are streamed, ArcGIS token errors are returned with status 200
+	if err != nil || response.StatusCode != 200 || !isCacheable(response.Header) || !gp.canBuffer(response) || isArcGISTokenError(response) {
+		return response, err
+	}
+	body, err := gp.readBuffered(response)
+	if err != nil {
+		return nil, err
+	}
+	gp.cacheSet(request, body, response.Header)
+	if notModified(request, response.Header) {
+		return notModifiedResponse(request, response.Header), nil
+	}
+	response.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return response, nil
+}
+
+// notModified checks if conditional request validators match response header
+func notModified(request *http.Request, header http.Header) bool {
+	if ifNoneMatch := request.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		etag := strings.TrimPrefix(header.Get("ETag"), "W/")
+		if etag == "" {
+			return false
+		}
+		for _, tag := range strings.Split(ifNoneMatch, ",") {
+			if tag = strings.TrimSpace(tag); tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+				return true
+			}
+		}
+		return false
+	}
+	if ifModifiedSince := request.Header.Get("If-Modified-Since"); ifModifiedSince != "" {
+		since, err := http.ParseTime(ifModifiedSince)
+		if err != nil {
+			return false
+		}
+		lastModified, err := http.ParseTime(header.Get("Last-Modified"))
+		return err == nil && !lastModified.After(since)
+	}
+	return false
+}
+
+// notModifiedResponse builds 304 response with validator and caching headers
+func notModifiedResponse(request *http.Request, header http.Header) *http.Response {
+	notModifiedHeader := make(http.Header)
+	for _, name := range []string{"Cache-Control", "Content-Location", "Date", "ETag", "Expires", "Last-Modified", "Vary"} {
+		if values, ok := header[http.CanonicalHeaderKey(name)]; ok {
+			notModifiedHeader[http.CanonicalHeaderKey(name)] = append([]string(nil), values...)
+		}
+	}
+	return &http.Response{
+		Status:     http.StatusText(http.StatusNotModified),
+		StatusCode: http.StatusNotModified,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     notModifiedHeader,
+		Body:       http.NoBody,
+		Request:    request,
+	}
+}
+
+// isCacheable checks that response header allows storing
+func isCacheable(header http.Header) bool {
+	if isEventStream(header) {
+		return false
+	}
+	if _, ok := header["Set-Cookie"]; ok {
+		// Session cookie of a client must not be replayed to others
+		return false
+	}
+	for _, value := range header["Vary"] {
+		if strings.TrimSpace(value) == "*" {
+			return false
+		}
+	}
+	for _, value := range header["Cache-Control"] {
+		for _, directive := range strings.Split(value, ",") {
+			// Private responses are for a single client
+			directive = strings.ToLower(strings.TrimSpace(strings.SplitN(directive, "=", 2)[0]))
+			if directive == "no-store" || directive == "private" {
+				return false
+			}
+		}
+	}
+	return true
+}
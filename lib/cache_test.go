@@ -0,0 +1,412 @@
+package lib
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCacheCredentials(t *testing.T) {
+	var requests int32
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if cacheControl := request.URL.Query().Get("cc"); cacheControl != "" {
+			writer.Header().Set("Cache-Control", cacheControl)
+		}
+		if auth := request.Header.Get("Authorization"); auth != "" {
+			writer.Write([]byte("private for " + auth))
+			return
+		}
+		writer.Write([]byte("public"))
+	})
+	tests := []struct {
+		name     string
+		path     string
+		first    string
+		second   string
+		want     string
+		requests int32
+	}{
+		{"anonymous after authenticated", "/tile", "Bearer alice", "", "public", 2},
+		{"other user", "/tile", "Bearer alice", "Bearer bob", "private for Bearer bob", 2},
+		{"same user", "/tile", "Bearer alice", "Bearer alice", "private for Bearer alice", 1},
+		{"anonymous", "/tile", "", "", "public", 1},
+		{"private response", "/tile?cc=private,+max-age=60", "", "", "public", 2},
+		{"no-store response", "/tile?cc=no-store", "", "", "public", 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			atomic.StoreInt32(&requests, 0)
+			gp := NewGisProxyHandler("/", false)
+			gp.UseCache(10, time.Minute)
+			target := "/" + encodeSegment(upstream.URL+test.path)
+			for _, auth := range []string{test.first, test.second} {
+				request := httptest.NewRequest("GET", target, nil)
+				if auth != "" {
+					request.Header.Set("Authorization", auth)
+				}
+				recorder := serve(gp, request)
+				if auth == test.second && recorder.Body.String() != test.want {
+					t.Errorf("body %q, want %q", recorder.Body.String(), test.want)
+				}
+			}
+			if got := atomic.LoadInt32(&requests); got != test.requests {
+				t.Errorf("%v upstream requests, want %v", got, test.requests)
+			}
+		})
+	}
+}
+
+func TestCacheKeyHashesCredentials(t *testing.T) {
+	gp := NewGisProxyHandler("/", false)
+	request := httptest.NewRequest("GET", "http://server/tile", nil)
+	request.Header.Set("Cookie", "session=secret")
+	if key := gp.cacheKey(request); key == "GET http://server/tile" || len(key) == 0 || strings.Contains(key, "secret") {
+		t.Errorf("cache key %q", key)
+	}
+}
+
+func TestCacheBuffering(t *testing.T) {
+	var requests int32
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		switch request.URL.Path {
+		case "/chunked":
+			writer.Write([]byte("part1"))
+			writer.(http.Flusher).Flush()
+			writer.Write([]byte("part2"))
+		case "/large":
+			writer.Header().Set("Content-Type", "image/png")
+			writer.Write([]byte(strings.Repeat("x", 64)))
+		case "/token":
+			writer.Header().Set("Content-Type", "application/json")
+			writer.Write([]byte(`{"error":{"code":498,"message":"Invalid token."}}`))
+		case "/error":
+			http.Error(writer, "failure", http.StatusInternalServerError)
+		default:
+			writer.Write([]byte("tile"))
+		}
+	})
+	tests := []struct {
+		name     string
+		path     string
+		requests int32
+	}{
+		{"known size", "/tile", 1},
+		{"unknown size", "/chunked", 2},
+		{"larger than response limit", "/large", 2},
+		{"arcgis token error", "/token", 2},
+		{"server error", "/error", 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			atomic.StoreInt32(&requests, 0)
+			gp := NewGisProxyHandler("/", false)
+			gp.UseCache(10, time.Minute)
+			gp.SetMaxResponseBodyBytes(56, "image/")
+			target := "/" + encodeSegment(upstream.URL+test.path)
+			var bodies []string
+			for i := 0; i < 2; i++ {
+				bodies = append(bodies, serve(gp, httptest.NewRequest("GET", target, nil)).Body.String())
+			}
+			if bodies[0] != bodies[1] {
+				t.Errorf("bodies %q and %q differ", bodies[0], bodies[1])
+			}
+			if got := atomic.LoadInt32(&requests); got != test.requests {
+				t.Errorf("%v upstream requests, want %v", got, test.requests)
+			}
+		})
+	}
+}
+
+func TestConditionalRequests(t *testing.T) {
+	var requests int32
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		writer.Header().Set("ETag", `"v1"`)
+		writer.Header().Set("Last-Modified", "Mon, 12 Oct 2026 10:00:00 GMT")
+		writer.Header().Set("Content-Type", "image/png")
+		if request.Header.Get("If-None-Match") == `"v1"` {
+			writer.WriteHeader(http.StatusNotModified)
+			return
+		}
+		writer.Write([]byte("tile"))
+	})
+	tests := []struct {
+		name            string
+		cache           bool
+		ifNoneMatch     string
+		ifModifiedSince string
+		status          int
+		body            string
+		requests        int32
+	}{
+		{"passthrough not modified", false, `"v1"`, "", http.StatusNotModified, "", 1},
+		{"passthrough modified", false, `"v0"`, "", http.StatusOK, "tile", 1},
+		{"cache etag match", true, `W/"v1"`, "", http.StatusNotModified, "", 0},
+		{"cache etag mismatch", true, `"v0"`, "", http.StatusOK, "tile", 0},
+		{"cache not modified since", true, "", "Tue, 13 Oct 2026 10:00:00 GMT", http.StatusNotModified, "", 0},
+		{"cache modified since", true, "", "Sun, 11 Oct 2026 10:00:00 GMT", http.StatusOK, "tile", 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gp := NewGisProxyHandler("/gisproxy/", true)
+			target := "/gisproxy/" + encodeSegment(upstream.URL+"/tile.png")
+			if test.cache {
+				gp.UseCache(10, time.Minute)
+				serve(gp, httptest.NewRequest("GET", target, nil))
+			}
+			atomic.StoreInt32(&requests, 0)
+			request := httptest.NewRequest("GET", target, nil)
+			request.Header.Set("Origin", "http://client.example.com")
+			if test.ifNoneMatch != "" {
+				request.Header.Set("If-None-Match", test.ifNoneMatch)
+			}
+			if test.ifModifiedSince != "" {
+				request.Header.Set("If-Modified-Since", test.ifModifiedSince)
+			}
+			recorder := serve(gp, request)
+			if recorder.Code != test.status || recorder.Body.String() != test.body {
+				t.Errorf("status %v body %q, want %v %q", recorder.Code, recorder.Body.String(), test.status, test.body)
+			}
+			if etag := recorder.Header().Get("ETag"); etag != `"v1"` {
+				t.Errorf("ETag %q, want %q", etag, `"v1"`)
+			}
+			if origins := recorder.Header()["Access-Control-Allow-Origin"]; len(origins) != 1 {
+				t.Errorf("Access-Control-Allow-Origin %v, want one value", origins)
+			}
+			if got := atomic.LoadInt32(&requests); got != test.requests {
+				t.Errorf("%v upstream requests, want %v", got, test.requests)
+			}
+		})
+	}
+}
+
+func TestMemoryCache(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxEntries int
+		ttl        time.Duration
+		keys       []string
+		get        string
+		found      bool
+	}{
+		{"stored", 2, 0, []string{"a"}, "a", true},
+		{"missing", 2, 0, []string{"a"}, "b", false},
+		{"least recently used evicted", 2, 0, []string{"a", "b", "c"}, "a", false},
+		{"most recent kept", 2, 0, []string{"a", "b", "c"}, "c", true},
+		{"non positive ttl never expires", 2, -time.Nanosecond, []string{"a"}, "a", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cache := NewMemoryCache(test.maxEntries)
+			for _, key := range test.keys {
+				cache.Set(key, []byte(key), http.Header{"Content-Type": {"image/png"}}, test.ttl)
+			}
+			body, header, found := cache.Get(test.get)
+			if found != test.found {
+				t.Fatalf("found %v, want %v", found, test.found)
+			}
+			if found && (string(body) != test.get || header.Get("Content-Type") != "image/png") {
+				t.Errorf("body %q header %v", body, header)
+			}
+		})
+	}
+	cache := NewMemoryCache(0)
+	cache.Set("a", []byte("a"), http.Header{}, time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	if _, _, found := cache.Get("a"); found {
+		t.Error("expired entry found")
+	}
+}
+
+func TestMemoryCacheConcurrent(t *testing.T) {
+	cache := NewMemoryCache(16)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				key := strconv.Itoa((i + j) % 32)
+				cache.Set(key, []byte(key), http.Header{"X-Key": {key}}, time.Minute)
+				if body, header, ok := cache.Get(key); ok {
+					// Returned header is a copy
+					header.Set("X-Key", "changed")
+					if string(body) != key {
+						t.Errorf("body %q, want %q", body, key)
+					}
+				}
+				cache.Invalidate(func(k string) bool { return k == strconv.Itoa(j%32) })
+			}
+		}(i)
+	}
+	wg.Wait()
+	if _, header, ok := cache.Get("1"); ok && header.Get("X-Key") != "1" {
+		t.Errorf("cached header changed %v", header)
+	}
+}
+
+// countingCache structure, Cache counting stored keys
+type countingCache struct {
+	*MemoryCache
+	mutex sync.Mutex
+	keys  []string
+}
+
+func (cc *countingCache) Set(key string, body []byte, header http.Header, ttl time.Duration) {
+	cc.mutex.Lock()
+	cc.keys = append(cc.keys, key)
+	cc.mutex.Unlock()
+	cc.MemoryCache.Set(key, body, header, ttl)
+}
+
+func TestCacheVary(t *testing.T) {
+	var requests int32
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		writer.Header().Set("Vary", "Accept-Language")
+		writer.Write([]byte("legend " + request.Header.Get("Accept-Language")))
+	})
+	cache := &countingCache{MemoryCache: NewMemoryCache(10)}
+	gp := NewGisProxyHandler("/gisproxy/", false)
+	gp.SetCache(cache)
+	tests := []struct {
+		language string
+		body     string
+		requests int32
+	}{
+		{"fr", "legend fr", 1},
+		{"en", "legend en", 2},
+		{"fr", "legend fr", 2},
+		{"en", "legend en", 2},
+	}
+	for i, test := range tests {
+		request := httptest.NewRequest("GET", "/gisproxy/"+encodeSegment(upstream.URL+"/legend?LAYER=roads"), nil)
+		request.Header.Set("Accept-Language", test.language)
+		recorder := serve(gp, request)
+		if recorder.Body.String() != test.body {
+			t.Errorf("request %v: body %q, want %q", i, recorder.Body.String(), test.body)
+		}
+		if got := atomic.LoadInt32(&requests); got != test.requests {
+			t.Errorf("request %v: %v upstream requests, want %v", i, got, test.requests)
+		}
+	}
+	for _, key := range cache.keys {
+		if !strings.HasPrefix(key, "GET ") {
+			t.Errorf("cache key %q does not start with method", key)
+		}
+	}
+}
+
+func TestCacheKeyArcGISToken(t *testing.T) {
+	var requests int32
+	var tokens []string
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		tokens = append(tokens, request.URL.Query().Get("token"))
+		writer.Header().Set("Content-Type", "application/json")
+		writer.Write([]byte(`{"layers":[]}`))
+	})
+	cache := &countingCache{MemoryCache: NewMemoryCache(10)}
+	var refresh int
+	gp := NewGisProxyHandler("/gisproxy/", false)
+	gp.SetCache(cache)
+	gp.SetArcGISTokenProvider(func(ctx context.Context, info *GisInfo) (string, error) {
+		refresh++
+		return "secret-" + strconv.Itoa(refresh), nil
+	})
+	target := "/gisproxy/" + encodeSegment(upstream.URL+"/arcgis/rest/services/roads/MapServer?f=json")
+	for i := 0; i < 2; i++ {
+		if recorder := serve(gp, httptest.NewRequest("GET", target, nil)); recorder.Code != http.StatusOK {
+			t.Fatalf("request %v: status %v, want %v", i, recorder.Code, http.StatusOK)
+		}
+	}
+	if requests != 1 || len(tokens) != 1 || tokens[0] != "secret-1" {
+		t.Errorf("%v upstream requests with tokens %v, want 1 with secret-1", requests, tokens)
+	}
+	for _, key := range cache.keys {
+		if strings.Contains(key, "secret") || strings.Contains(key, "token") {
+			t.Errorf("cache key %q holds token", key)
+		}
+	}
+	// Token sent by client is its credential, kept in key but hashed
+	request := httptest.NewRequest("GET", "http://server/arcgis/rest/services/roads/MapServer?f=json&token=client-secret", nil)
+	other := httptest.NewRequest("GET", "http://server/arcgis/rest/services/roads/MapServer?f=json&token=other-secret", nil)
+	key := NewGisProxyHandler("/", false).cacheKey(request)
+	if strings.Contains(key, "client-secret") || key == NewGisProxyHandler("/", false).cacheKey(other) {
+		t.Errorf("cache key %q with client token", key)
+	}
+}
+
+func TestCacheSetCookie(t *testing.T) {
+	var requests int32
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		n := atomic.AddInt32(&requests, 1)
+		http.SetCookie(writer, &http.Cookie{Name: "session", Value: "client-" + strconv.Itoa(int(n))})
+		writer.Write([]byte("capabilities"))
+	})
+	gp := NewGisProxyHandler("/gisproxy/", false)
+	gp.SetCache(NewMemoryCache(10))
+	target := "/gisproxy/" + encodeSegment(upstream.URL+"/wms?SERVICE=WMS&REQUEST=GetCapabilities")
+	for i, want := range []string{"session=client-1", "session=client-2"} {
+		recorder := serve(gp, httptest.NewRequest("GET", target, nil))
+		if cookie := recorder.Header().Get("Set-Cookie"); cookie != want {
+			t.Errorf("client %v: Set-Cookie %q, want %q", i, cookie, want)
+		}
+	}
+	if requests != 2 {
+		t.Errorf("%v upstream requests, want 2", requests)
+	}
+}
+
+func TestCacheKeyNormalization(t *testing.T) {
+	gp := NewGisProxyHandler("/", false)
+	gp.SetCacheIgnoreParams([]string{"_", "UTM_Source"})
+	key := func(rawURL string) string {
+		return gp.cacheKey(httptest.NewRequest("GET", rawURL, nil))
+	}
+	want := "GET http://server/wms?bbox=0,0,1,1&layers=roads&service=WMS"
+	tests := []struct {
+		name   string
+		rawURL string
+	}{
+		{"sorted", "http://server/wms?SERVICE=WMS&LAYERS=roads&BBOX=0,0,1,1"},
+		{"mixed case names", "http://server/wms?Service=WMS&layers=roads&bBox=0,0,1,1"},
+		{"ignored params", "http://server/wms?_=1712345&service=WMS&utm_source=mail&layers=roads&bbox=0,0,1,1"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := key(test.rawURL); got != want {
+				t.Errorf("cache key %q, want %q", got, want)
+			}
+		})
+	}
+	if key("http://server/wms?LAYERS=roads") == key("http://server/wms?LAYERS=Roads") {
+		t.Error("parameter values must stay case-sensitive in cache key")
+	}
+	if key("http://server/wms?LAYERS=roads") == gp.cacheKey(httptest.NewRequest("HEAD", "http://server/wms?LAYERS=roads", nil)) {
+		t.Error("cache key must differ by method")
+	}
+
+	var requests int32
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		writer.Write([]byte("map"))
+	})
+	gp.UseCache(10, time.Minute)
+	for _, query := range []string{"SERVICE=WMS&LAYERS=roads&_=1", "layers=roads&service=WMS&_=2"} {
+		if recorder := serve(gp, httptest.NewRequest("GET", "/"+encodeSegment(upstream.URL+"/wms")+"?"+query, nil)); recorder.Body.String() != "map" {
+			t.Fatalf("body %q, want %q", recorder.Body.String(), "map")
+		}
+	}
+	if got := atomic.LoadInt32(&requests); got != 1 {
+		t.Errorf("%v upstream requests, want %v", got, 1)
+	}
+}
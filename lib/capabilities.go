@@ -0,0 +1,60 @@
+package lib
+
+import (
+	"context"
+	"html"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var (
+	reOnlineResourceHref = regexp.MustCompile(`(<(?:[\w.-]+:)?OnlineResource\b[^>]*?\s(?:[\w.-]+:)?href\s*=\s*["'])([^"']*)(["'])`)
+	reOnlineResourceText = regexp.MustCompile(`(<(?:[\w.-]+:)?OnlineResource\b[^>/]*>\s*)([^<\s]+)(\s*</)`)
+)
+
+// SetCapabilitiesRewrite rewrites OnlineResource urls of WMS GetCapabilities responses pointing
+// at origin server to go through proxy. publicBaseURL is proxy url including prefix (see
+// SetPublicBaseURL), it is computed from incoming request when empty.
+func (gp *GisProxy) SetCapabilitiesRewrite(enabled bool, publicBaseURL string) {
+	gp.capabilitiesRewrite = enabled
+	if publicBaseURL != "" {
+		gp.publicBaseURL = publicBaseURL
+	}
+}
+
+// capabilitiesRewriter returns rewrite function for WMS GetCapabilities response, nil if not applicable
+func (gp *GisProxy) capabilitiesRewriter(request *http.Request, response *http.Response) BodyRewrite {
+	if !gp.capabilitiesRewrite || response.Request == nil {
+		return nil
+	}
+	info := GisInfoFromContext(request.Context())
+	if info == nil || info.ServiceType != "WMS" || !strings.Contains(strings.ToLower(response.Header.Get("Content-Type")), "xml") {
+		return nil
+	}
+	origin := response.Request.URL
+	if !strings.EqualFold(formValue(origin.Query(), "request"), "GetCapabilities") {
+		return nil
+	}
+	base := gp.proxyBaseURL(request)
+	return func(ctx context.Context, info *GisInfo, contentType string, body []byte) ([]byte, error) {
+		rewrite := func(match []byte, re *regexp.Regexp) []byte {
+			submatch := re.FindSubmatch(match)
+			href := html.UnescapeString(string(submatch[2]))
+			target, err := url.Parse(href)
+			if err != nil || !strings.EqualFold(target.Host, origin.Host) {
+				return match
+			}
+			rewritten := html.EscapeString(gp.proxyURL(request, base, target))
+			return []byte(string(submatch[1]) + rewritten + string(submatch[3]))
+		}
+		body = reOnlineResourceHref.ReplaceAllFunc(body, func(match []byte) []byte {
+			return rewrite(match, reOnlineResourceHref)
+		})
+		body = reOnlineResourceText.ReplaceAllFunc(body, func(match []byte) []byte {
+			return rewrite(match, reOnlineResourceText)
+		})
+		return body, nil
+	}
+}
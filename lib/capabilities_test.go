@@ -0,0 +1,84 @@
+package lib
+
+import (
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCapabilitiesRewrite(t *testing.T) {
+	var origin string
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Type", request.URL.Query().Get("type"))
+		writer.Write([]byte(strings.Replace(request.URL.Query().Get("body"), "ORIGIN", origin, -1)))
+	})
+	origin = upstream.URL
+	const base = "https://proxy.example.com/gisproxy/"
+	proxied := func(rawURL string) string {
+		target, err := url.Parse(strings.Replace(rawURL, "ORIGIN", origin, -1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return encodeForwardUrl(base, target)
+	}
+	tests := []struct {
+		name        string
+		request     string
+		contentType string
+		body        string
+		want        string
+	}{
+		{
+			"wms 1.1.1 href attribute", "GetCapabilities", "application/vnd.ogc.wms_xml",
+			`<OnlineResource xmlns:xlink="http://www.w3.org/1999/xlink" xlink:type="simple" xlink:href="ORIGIN/wms?SERVICE=WMS&amp;"/>`,
+			`<OnlineResource xmlns:xlink="http://www.w3.org/1999/xlink" xlink:type="simple" xlink:href="` + html.EscapeString(proxied("ORIGIN/wms?SERVICE=WMS&")) + `"/>`,
+		},
+		{
+			"wms 1.3.0 namespaced element", "GetCapabilities", "text/xml",
+			`<wms:OnlineResource xlink:href='ORIGIN/wms?'/>`,
+			`<wms:OnlineResource xlink:href='` + proxied("ORIGIN/wms?") + `'/>`,
+		},
+		{
+			"element text", "GetCapabilities", "text/xml",
+			`<OnlineResource> ORIGIN/wms </OnlineResource>`,
+			`<OnlineResource> ` + proxied("ORIGIN/wms") + ` </OnlineResource>`,
+		},
+		{
+			"other host kept", "GetCapabilities", "text/xml",
+			`<OnlineResource xlink:href="http://other.example.com/wms?"/>`,
+			`<OnlineResource xlink:href="http://other.example.com/wms?"/>`,
+		},
+		{
+			"other element kept", "GetCapabilities", "text/xml",
+			`<LegendURL xlink:href="ORIGIN/legend"/>`,
+			`<LegendURL xlink:href="ORIGIN/legend"/>`,
+		},
+		{
+			"not xml", "GetCapabilities", "application/json",
+			`<OnlineResource xlink:href="ORIGIN/wms?"/>`,
+			`<OnlineResource xlink:href="ORIGIN/wms?"/>`,
+		},
+		{
+			"not capabilities", "GetFeatureInfo", "text/xml",
+			`<OnlineResource xlink:href="ORIGIN/wms?"/>`,
+			`<OnlineResource xlink:href="ORIGIN/wms?"/>`,
+		},
+	}
+	gp := NewGisProxyHandler("/gisproxy/", false)
+	gp.SetCapabilitiesRewrite(true, base)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			query := url.Values{"SERVICE": {"WMS"}, "REQUEST": {test.request}, "type": {test.contentType}, "body": {test.body}}
+			recorder := serve(gp, httptest.NewRequest("GET", "/gisproxy/"+encodeSegment(upstream.URL+"/wms?"+query.Encode()), nil))
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("status %v, want %v", recorder.Code, http.StatusOK)
+			}
+			if want := strings.Replace(test.want, "ORIGIN", origin, -1); recorder.Body.String() != want {
+				t.Errorf("body %q, want %q", recorder.Body.String(), want)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// coalesceHeaders lists request headers distinguishing coalesced requests
+var coalesceHeaders = []string{"Authorization", "Cookie", "Accept", "Accept-Encoding", "Accept-Language", "Range", "If-None-Match", "If-Modified-Since"}
+
+// flightCall structure, result of an upstream request shared by identical concurrent requests
+type flightCall struct {
+	done       chan struct{}
+	statusCode int
+	header     http.Header
+	body       []byte
+	err        error
+	// streamed is true when response was too large or of unknown size to be shared
+	streamed bool
+}
+
+// flightGroup structure
+type flightGroup struct {
+	mutex sync.Mutex
+	calls map[string]*flightCall
+}
+
+// SetCoalesceRequests coalesces concurrent identical GET requests into a single upstream request
+// whose buffered response is shared. Requests differing by authorization, cookies or content
+// negotiation headers are not coalesced.
+func (gp *GisProxy) SetCoalesceRequests(coalesce bool) {
+	if coalesce {
+		gp.flights = &flightGroup{calls: make(map[string]*flightCall)}
+	} else {
+		gp.flights = nil
+	}
+}
+
+// coalesceKey returns key of request method, url and distinguishing headers
+func coalesceKey(request *http.Request) string {
+	key := request.Method + " " + request.URL.String()
+	for _, name := range coalesceHeaders {
+		if values, ok := request.Header[name]; ok {
+			key += "\n" + name + ": " + strings.Join(values, ",")
+		}
+	}
+	return key
+}
+
+// fetch sends request upstream, coalescing identical concurrent GET requests when enabled
+func (gp *GisProxy) fetch(request *http.Request) (*http.Response, error) {
+	flights := gp.flights
+	if flights == nil || request.Method != "GET" || acceptsEventStream(request) || isWebSocketUpgrade(request.Header) {
+		return gp.sendWithRetry(request)
+	}
+	key := coalesceKey(request)
+	flights.mutex.Lock()
+	if call, ok := flights.calls[key]; ok {
+		flights.mutex.Unlock()
+		select {
+		case <-call.done:
+		case <-request.Context().Done():
+			return nil, request.Context().Err()
+		}
+		if call.streamed || (call.err != nil && errors.Is(call.err, context.Canceled) && request.Context().Err() == nil) {
+			// Response streamed to its client, or shared request cancelled by its client, not by this one
+			return gp.sendWithRetry(request)
+		}
+		return call.response(request)
+	}
+	call := &flightCall{done: make(chan struct{})}
+	flights.calls[key] = call
+	flights.mutex.Unlock()
+	response, err := gp.sendWithRetry(request)
+	call.streamed = err == nil && !gp.canBuffer(response)
+	if err == nil && !call.streamed {
+		call.statusCode = response.StatusCode
+		call.header = response.Header
+		call.body, err = gp.readBuffered(response)
+	}
+	call.err = err
+	// Forget call so that a failed request is not shared with subsequent requests
+	flights.mutex.Lock()
+	delete(flights.calls, key)
+	flights.mutex.Unlock()
+	close(call.done)
+	if call.streamed {
+		return response, nil
+	}
+	return call.response(request)
+}
+
+// response builds http response from shared result
+func (fc *flightCall) response(request *http.Request) (*http.Response, error) {
+	if fc.err != nil {
+		return nil, fc.err
+	}
+	return &http.Response{
+		Status:        http.StatusText(fc.statusCode),
+		StatusCode:    fc.statusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        fc.header.Clone(),
+		Body:          ioutil.NopCloser(bytes.NewReader(fc.body)),
+		ContentLength: int64(len(fc.body)),
+		Request:       request,
+	}, nil
+}
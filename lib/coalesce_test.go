@@ -0,0 +1,61 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestCoalesceRequests(t *testing.T) {
+	body := strings.Repeat("x", maxBufferedBodyBytes/4)
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		switch request.URL.Path {
+		case "/chunked":
+			for i := 0; i < 8; i++ {
+				writer.Write([]byte(body))
+				writer.(http.Flusher).Flush()
+			}
+		case "/error":
+			http.Error(writer, "failure", http.StatusInternalServerError)
+		default:
+			writer.Write([]byte("tile"))
+		}
+	})
+	tests := []struct {
+		name   string
+		path   string
+		status int
+		size   int
+	}{
+		{"known size", "/tile", http.StatusOK, len("tile")},
+		{"streamed larger than buffer", "/chunked", http.StatusOK, 8 * len(body)},
+		{"server error", "/error", http.StatusInternalServerError, len("failure\n")},
+	}
+	gp := NewGisProxyHandler("/", false)
+	gp.SetCoalesceRequests(true)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			target := "/" + encodeSegment(upstream.URL+test.path)
+			var wg sync.WaitGroup
+			recorders := make([]*httptest.ResponseRecorder, 4)
+			for i := range recorders {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					recorders[i] = serve(gp, httptest.NewRequest("GET", target, nil))
+				}(i)
+			}
+			wg.Wait()
+			for _, recorder := range recorders {
+				if recorder.Code != test.status || recorder.Body.Len() != test.size {
+					t.Errorf("status %v and %v bytes, want %v and %v bytes", recorder.Code, recorder.Body.Len(), test.status, test.size)
+				}
+			}
+			if n := len(gp.flights.calls); n != 0 {
+				t.Errorf("%v calls in flight, want 0", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package lib
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// compressedContentTypes lists content types already compressed
+var compressedContentTypes = []string{
+	"image/png",
+	"image/jpeg",
+	"image/gif",
+	"image/webp",
+	"application/zip",
+	"application/gzip",
+	"application/x-gzip",
+	"video/",
+	"audio/",
+}
+
+// SetCompression enables gzip compression of responses larger than minBytes
+// when client accepts gzip and upstream response is not already compressed
+func (gp *GisProxy) SetCompression(enabled bool, minBytes int) {
+	gp.compression = enabled
+	gp.compressionMinBytes = minBytes
+}
+
+// acceptsGzip checks if request Accept-Encoding header contains gzip
+func acceptsGzip(request *http.Request) bool {
+	for _, value := range request.Header["Accept-Encoding"] {
+		for _, encoding := range strings.Split(value, ",") {
+			encoding = strings.TrimSpace(strings.Split(encoding, ";")[0])
+			if strings.EqualFold(encoding, "gzip") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isCompressedContentType checks if content type is already compressed
+func isCompressedContentType(contentType string) bool {
+	contentType = strings.ToLower(contentType)
+	for _, compressed := range compressedContentTypes {
+		if strings.HasPrefix(contentType, compressed) {
+			return true
+		}
+	}
+	return false
+}
+
+// shouldCompress checks if response may be compressed
+func (gp *GisProxy) shouldCompress(request *http.Request, response *http.Response) bool {
+	if !gp.compression || request.Method == "HEAD" || !acceptsGzip(request) {
+		return false
+	}
+	if response.StatusCode < 200 || response.StatusCode == 204 || response.StatusCode == 304 {
+		return false
+	}
+	if isPartialResponse(response) {
+		return false
+	}
+	if response.Header.Get("Content-Encoding") != "" || isCompressedContentType(response.Header.Get("Content-Type")) {
+		return false
+	}
+	return true
+}
+
+// compressBody sets compression headers and returns body reader and true when
+// response is large enough to be compressed
+func (gp *GisProxy) compressBody(response *http.Response, body io.Reader) (io.Reader, bool) {
+	if response.ContentLength >= 0 {
+		if response.ContentLength < int64(gp.compressionMinBytes) {
+			return body, false
+		}
+	} else if gp.compressionMinBytes > 0 {
+		// Unknown length, peek minBytes
+		buf := make([]byte, gp.compressionMinBytes)
+		n, err := io.ReadFull(body, buf)
+		body = io.MultiReader(bytes.NewReader(buf[:n]), body)
+		if err != nil {
+			return body, false
+		}
+	}
+	response.Header.Del("Content-Length")
+	response.Header.Set("Content-Encoding", "gzip")
+	response.Header.Add("Vary", "Accept-Encoding")
+	return body, true
+}
+
+// copyGzip copies body to writer with gzip compression
+func (gp *GisProxy) copyGzip(writer io.Writer, body io.Reader) (int64, error) {
+	gzipWriter := gzip.NewWriter(writer)
+	written, err := gp.copyBuffer(gzipWriter, body)
+	if closeErr := gzipWriter.Close(); err == nil {
+		err = closeErr
+	}
+	return written, err
+}
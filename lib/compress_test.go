@@ -0,0 +1,92 @@
+package lib
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCompression(t *testing.T) {
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		query := request.URL.Query()
+		size, _ := strconv.Atoi(query.Get("size"))
+		body := []byte(strings.Repeat("a", size))
+		writer.Header().Set("Content-Type", query.Get("type"))
+		if query.Get("encoded") != "" {
+			var buf bytes.Buffer
+			gzipWriter := gzip.NewWriter(&buf)
+			gzipWriter.Write(body)
+			gzipWriter.Close()
+			body = buf.Bytes()
+			writer.Header().Set("Content-Encoding", "gzip")
+		}
+		if query.Get("chunked") != "" {
+			writer.(http.Flusher).Flush()
+		} else {
+			writer.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		}
+		writer.Write(body)
+	})
+	tests := []struct {
+		name           string
+		query          string
+		acceptEncoding string
+		compressed     bool
+		vary           bool
+	}{
+		{"large json", "size=2000&type=application/json", "gzip, deflate", true, true},
+		{"large chunked json", "size=2000&type=application/json&chunked=1", "gzip", true, true},
+		{"small json", "size=100&type=application/json", "gzip", false, false},
+		{"small chunked json", "size=100&type=application/json&chunked=1", "gzip", false, false},
+		{"client without gzip", "size=2000&type=application/json", "", false, false},
+		{"client with other encoding", "size=2000&type=application/json", "br", false, false},
+		{"png", "size=2000&type=image/png", "gzip", false, false},
+		{"jpeg", "size=2000&type=image/jpeg", "gzip", false, false},
+		{"already compressed", "size=2000&type=application/json&encoded=1", "gzip", true, false},
+	}
+	gp := NewGisProxyHandler("/gisproxy/", false)
+	gp.SetCompression(true, 1024)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest("GET", "/gisproxy/"+encodeSegment(upstream.URL+"/wfs?"+test.query), nil)
+			if test.acceptEncoding != "" {
+				request.Header.Set("Accept-Encoding", test.acceptEncoding)
+			}
+			recorder := serve(gp, request)
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("status %v, want %v", recorder.Code, http.StatusOK)
+			}
+			if vary := strings.Join(recorder.Header()["Vary"], ","); strings.Contains(vary, "Accept-Encoding") != test.vary {
+				t.Errorf("Vary %q, want Accept-Encoding %v", vary, test.vary)
+			}
+			if !test.compressed {
+				if encoding := recorder.Header().Get("Content-Encoding"); encoding != "" {
+					t.Errorf("Content-Encoding %q, want none", encoding)
+				}
+				return
+			}
+			if encoding := recorder.Header().Get("Content-Encoding"); encoding != "gzip" {
+				t.Fatalf("Content-Encoding %q, want gzip", encoding)
+			}
+			if length := recorder.Header().Get("Content-Length"); length != "" && length != strconv.Itoa(recorder.Body.Len()) {
+				t.Errorf("Content-Length %v, compressed body is %v bytes", length, recorder.Body.Len())
+			}
+			gzipReader, err := gzip.NewReader(recorder.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, err := ioutil.ReadAll(gzipReader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != strings.Repeat("a", 2000) {
+				t.Errorf("decompressed body of %v bytes, want %v", len(body), 2000)
+			}
+		})
+	}
+}
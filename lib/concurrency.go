@@ -0,0 +1,149 @@
+package lib
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// hostSlots structure
+type hostSlots struct {
+	slots chan struct{}
+	users int
+}
+
+// upstreamLimiter structure
+type upstreamLimiter struct {
+	mutex   sync.Mutex
+	global  chan struct{}
+	perHost int
+	hosts   map[string]*hostSlots
+	queue   bool
+}
+
+// SetMaxConcurrentUpstream limits number of simultaneous upstream requests, a slot is acquired
+// before sending request and released when response body is closed. Zero means no limit.
+// Limit may be changed while serving, requests in flight release slots of previous limit.
+func (gp *GisProxy) SetMaxConcurrentUpstream(n int) {
+	ul := gp.limiter()
+	ul.mutex.Lock()
+	defer ul.mutex.Unlock()
+	if n > 0 {
+		ul.global = make(chan struct{}, n)
+	} else {
+		ul.global = nil
+	}
+}
+
+// SetMaxConcurrentUpstreamPerHost limits number of simultaneous upstream requests per upstream
+// host, in addition to global limit. Zero means no limit.
+func (gp *GisProxy) SetMaxConcurrentUpstreamPerHost(n int) {
+	ul := gp.limiter()
+	ul.mutex.Lock()
+	defer ul.mutex.Unlock()
+	ul.perHost = n
+}
+
+// SetUpstreamQueueing makes requests wait for an upstream slot until their context is done
+// (client cancellation or request timeout) when limit is reached, instead of failing with 503
+func (gp *GisProxy) SetUpstreamQueueing(queue bool) {
+	ul := gp.limiter()
+	ul.mutex.Lock()
+	defer ul.mutex.Unlock()
+	ul.queue = queue
+}
+
+// limiter returns upstream limiter, created on first use
+func (gp *GisProxy) limiter() *upstreamLimiter {
+	if gp.upstreamLimiter == nil {
+		gp.upstreamLimiter = &upstreamLimiter{hosts: make(map[string]*hostSlots)}
+	}
+	return gp.upstreamLimiter
+}
+
+// acquire acquires global and host slots, returns release function. Slots are released to
+// channels captured here, so that a limit change never blocks requests in flight.
+func (ul *upstreamLimiter) acquire(ctx context.Context, host string) (func(), error) {
+	ul.mutex.Lock()
+	global, perHost, queue := ul.global, ul.perHost, ul.queue
+	var hs *hostSlots
+	if perHost > 0 {
+		var ok bool
+		if hs, ok = ul.hosts[host]; !ok {
+			hs = &hostSlots{slots: make(chan struct{}, perHost)}
+			ul.hosts[host] = hs
+		}
+		hs.users++
+	}
+	ul.mutex.Unlock()
+	releaseGlobal := func() {
+		if global != nil {
+			<-global
+		}
+	}
+	leave := func() {
+		if hs != nil {
+			ul.leave(host, hs)
+		}
+	}
+	if global != nil {
+		if err := takeSlot(ctx, global, queue); err != nil {
+			leave()
+			return nil, err
+		}
+	}
+	if hs != nil {
+		if err := takeSlot(ctx, hs.slots, queue); err != nil {
+			leave()
+			releaseGlobal()
+			return nil, err
+		}
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			if hs != nil {
+				<-hs.slots
+			}
+			leave()
+			releaseGlobal()
+		})
+	}, nil
+}
+
+// takeSlot takes slot, waiting for it in queue mode
+func takeSlot(ctx context.Context, slots chan struct{}, queue bool) error {
+	if !queue {
+		select {
+		case slots <- struct{}{}:
+			return nil
+		default:
+			return NewStatusError("Too many concurrent upstream requests", http.StatusServiceUnavailable)
+		}
+	}
+	select {
+	case slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// leave removes host slots without users so that memory is bounded
+func (ul *upstreamLimiter) leave(host string, hs *hostSlots) {
+	ul.mutex.Lock()
+	defer ul.mutex.Unlock()
+	hs.users--
+	if hs.users == 0 && ul.hosts[host] == hs {
+		delete(ul.hosts, host)
+	}
+}
+
+// acquireUpstream acquires upstream request slots of request host, returns release function
+func (gp *GisProxy) acquireUpstream(ctx context.Context, request *http.Request) (func(), error) {
+	if gp.upstreamLimiter == nil {
+		return func() {}, nil
+	}
+	return gp.upstreamLimiter.acquire(ctx, strings.ToLower(request.URL.Host))
+}
@@ -0,0 +1,140 @@
+package lib
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestUpstreamLimitKeepsCircuitProbe(t *testing.T) {
+	var failing int32 = 1
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		if atomic.LoadInt32(&failing) == 1 {
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		writer.Write([]byte("ok"))
+	})
+	gp := NewGisProxyHandler("/", false)
+	gp.SetCircuitBreaker(1, time.Minute, 10*time.Millisecond)
+	gp.SetMaxConcurrentUpstream(1)
+	target := "/" + encodeSegment(upstream.URL+"/tile")
+	if recorder := serve(gp, httptest.NewRequest("GET", target, nil)); recorder.Code != http.StatusInternalServerError {
+		t.Fatalf("status %v, want 500", recorder.Code)
+	}
+	time.Sleep(20 * time.Millisecond)
+	atomic.StoreInt32(&failing, 0)
+	u, _ := url.Parse(upstream.URL)
+	release, err := gp.upstreamLimiter.acquire(context.Background(), u.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recorder := serve(gp, httptest.NewRequest("GET", target, nil)); recorder.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status %v, want 503", recorder.Code)
+	}
+	release()
+	if recorder := serve(gp, httptest.NewRequest("GET", target, nil)); recorder.Code != http.StatusOK {
+		t.Fatalf("status %v after slot release, want 200", recorder.Code)
+	}
+	if states := gp.CircuitBreakerStates(); len(states) != 1 || states[0].State != CircuitClosed {
+		t.Errorf("circuit states %+v", states)
+	}
+}
+
+func TestUpstreamLimiter(t *testing.T) {
+	tests := []struct {
+		name    string
+		global  int
+		perHost int
+		hosts   []string
+		fails   int
+	}{
+		{"global", 2, 0, []string{"a", "b", "c"}, 1},
+		{"per host", 0, 1, []string{"a", "a", "b"}, 1},
+		{"global and per host", 2, 1, []string{"a", "a", "b", "c"}, 2},
+		{"no limit", 0, 0, []string{"a", "a", "a"}, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gp := NewGisProxyHandler("/", false)
+			gp.SetMaxConcurrentUpstream(test.global)
+			gp.SetMaxConcurrentUpstreamPerHost(test.perHost)
+			releases := make([]func(), 0)
+			fails := 0
+			for _, host := range test.hosts {
+				release, err := gp.upstreamLimiter.acquire(context.Background(), host)
+				if err != nil {
+					if se, ok := err.(*StatusError); !ok || se.Code != http.StatusServiceUnavailable {
+						t.Fatalf("error %v, want 503", err)
+					}
+					fails++
+					continue
+				}
+				releases = append(releases, release)
+			}
+			if fails != test.fails {
+				t.Errorf("%v failures, want %v", fails, test.fails)
+			}
+			for _, release := range releases {
+				release()
+				release()
+			}
+			if len(gp.upstreamLimiter.hosts) != 0 || len(gp.upstreamLimiter.global) != 0 {
+				t.Errorf("slots not released: %v hosts, %v global", len(gp.upstreamLimiter.hosts), len(gp.upstreamLimiter.global))
+			}
+		})
+	}
+}
+
+func TestUpstreamLimiterQueueing(t *testing.T) {
+	gp := NewGisProxyHandler("/", false)
+	gp.SetMaxConcurrentUpstream(1)
+	gp.SetUpstreamQueueing(true)
+	release, err := gp.upstreamLimiter.acquire(context.Background(), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := gp.upstreamLimiter.acquire(ctx, "a"); err != context.DeadlineExceeded {
+		t.Errorf("error %v, want deadline exceeded", err)
+	}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		release()
+	}()
+	queued, err := gp.upstreamLimiter.acquire(context.Background(), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	queued()
+}
+
+func TestUpstreamLimitChangeReleasesPreviousSlots(t *testing.T) {
+	gp := NewGisProxyHandler("/", false)
+	gp.SetMaxConcurrentUpstream(1)
+	release, err := gp.upstreamLimiter.acquire(context.Background(), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gp.SetMaxConcurrentUpstream(2)
+	done := make(chan struct{})
+	go func() {
+		release()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("release blocked after limit change")
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := gp.upstreamLimiter.acquire(context.Background(), "a"); err != nil {
+			t.Fatalf("acquire %v: %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package lib
+
+import (
+	"net/http"
+	"sync"
+)
+
+// connLimiter counts in-flight requests by client
+type connLimiter struct {
+	mutex sync.Mutex
+	max   int
+	conns map[string]int
+}
+
+// SetMaxConnsPerClient limits number of simultaneous forwarded requests by client IP (see
+// SetForwardedHeaders), further requests fail with 429 until one completes. Zero means no limit.
+func (gp *GisProxy) SetMaxConnsPerClient(n int) {
+	if n <= 0 {
+		gp.connLimiter = nil
+	} else {
+		gp.connLimiter = &connLimiter{max: n, conns: make(map[string]int)}
+	}
+}
+
+// acquire increments client count, returns false when limit is reached
+func (cl *connLimiter) acquire(key string) bool {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+	if cl.conns[key] >= cl.max {
+		return false
+	}
+	cl.conns[key]++
+	return true
+}
+
+// release decrements client count, removing clients without requests so that memory is bounded
+func (cl *connLimiter) release(key string) {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+	if cl.conns[key] <= 1 {
+		delete(cl.conns, key)
+	} else {
+		cl.conns[key]--
+	}
+}
+
+// checkConnLimit returns release function, or 429 error when client has too many requests in flight
+func (gp *GisProxy) checkConnLimit(request *http.Request) (func(), error) {
+	cl := gp.connLimiter
+	if cl == nil {
+		return func() {}, nil
+	}
+	key := gp.clientIP(request)
+	if !cl.acquire(key) {
+		return nil, NewStatusError("Too many concurrent requests", http.StatusTooManyRequests)
+	}
+	return func() { cl.release(key) }, nil
+}
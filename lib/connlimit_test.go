@@ -0,0 +1,107 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestConnLimit(t *testing.T) {
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		if request.URL.Path == "/slow" {
+			started <- struct{}{}
+			<-unblock
+		}
+	})
+	gp := NewGisProxyHandler("/gisproxy/", false)
+	gp.SetMaxConnsPerClient(1)
+	slow := "/gisproxy/" + encodeSegment(upstream.URL+"/slow")
+	fast := "/gisproxy/" + encodeSegment(upstream.URL+"/fast")
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		request := httptest.NewRequest("GET", slow, nil)
+		request.RemoteAddr = "203.0.113.7:1234"
+		serve(gp, request)
+	}()
+	<-started
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor string
+		status       int
+	}{
+		{"same client", "203.0.113.7:1234", "", http.StatusTooManyRequests},
+		{"same client spoofing forwarded for", "203.0.113.7:5678", "198.51.100.1", http.StatusTooManyRequests},
+		{"other client", "203.0.113.8:1234", "", http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest("GET", fast, nil)
+			request.RemoteAddr = test.remoteAddr
+			if test.forwardedFor != "" {
+				request.Header.Set("X-Forwarded-For", test.forwardedFor)
+			}
+			if recorder := serve(gp, request); recorder.Code != test.status {
+				t.Errorf("status %v, want %v", recorder.Code, test.status)
+			}
+		})
+	}
+	close(unblock)
+	wg.Wait()
+	request := httptest.NewRequest("GET", fast, nil)
+	request.RemoteAddr = "203.0.113.7:1234"
+	if recorder := serve(gp, request); recorder.Code != http.StatusOK {
+		t.Errorf("status after release %v, want %v", recorder.Code, http.StatusOK)
+	}
+	if n := len(gp.connLimiter.conns); n != 0 {
+		t.Errorf("%v clients tracked, want 0", n)
+	}
+}
+
+func TestConnLimitIgnoresNextHandler(t *testing.T) {
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {})
+	gp := NewGisProxyHandler("/gisproxy/", false)
+	gp.SetMaxConnsPerClient(1)
+	gp.SetNextHandler(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.URL.Path == "/app/slow" {
+			started <- struct{}{}
+			<-unblock
+		}
+		writer.WriteHeader(http.StatusTeapot)
+	}))
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		request := httptest.NewRequest("GET", "/app/slow", nil)
+		request.RemoteAddr = "203.0.113.7:1234"
+		serve(gp, request)
+	}()
+	<-started
+	tests := []struct {
+		name   string
+		target string
+		status int
+	}{
+		{"proxy request", "/gisproxy/" + encodeSegment(upstream.URL), http.StatusOK},
+		{"next handler request", "/app/page", http.StatusTeapot},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest("GET", test.target, nil)
+			request.RemoteAddr = "203.0.113.7:1234"
+			if recorder := serve(gp, request); recorder.Code != test.status {
+				t.Errorf("status %v, want %v", recorder.Code, test.status)
+			}
+		})
+	}
+	close(unblock)
+	wg.Wait()
+}
@@ -0,0 +1,69 @@
+package lib
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+)
+
+// imageSignatures maps image content types to their magic bytes
+var imageSignatures = []struct {
+	contentType string
+	prefix      []byte
+	offset      int
+	signature   []byte
+}{
+	{contentType: "image/png", signature: []byte("\x89PNG\r\n\x1a\n")},
+	{contentType: "image/jpeg", signature: []byte("\xff\xd8\xff")},
+	{contentType: "image/gif", signature: []byte("GIF8")},
+	{contentType: "image/webp", prefix: []byte("RIFF"), offset: 8, signature: []byte("WEBP")},
+}
+
+// SetDefaultContentType sets Content-Type of responses whose upstream omitted it, empty disables default
+func (gp *GisProxy) SetDefaultContentType(contentType string) {
+	gp.defaultContentType = contentType
+}
+
+// SetContentTypeDetection enables detection of PNG, JPEG, GIF and WebP responses without Content-Type
+// from first body bytes, before default content type applies
+func (gp *GisProxy) SetContentTypeDetection(enabled bool) {
+	gp.contentTypeDetection = enabled
+}
+
+// detectImageType returns image content type of first body bytes, empty if unknown
+func detectImageType(head []byte) string {
+	for _, is := range imageSignatures {
+		if !bytes.HasPrefix(head, is.prefix) {
+			continue
+		}
+		if len(head) >= is.offset+len(is.signature) && bytes.Equal(head[is.offset:is.offset+len(is.signature)], is.signature) {
+			return is.contentType
+		}
+	}
+	return ""
+}
+
+// applyContentType sets missing response Content-Type, detected from body or default, returns body reader
+func (gp *GisProxy) applyContentType(request *http.Request, response *http.Response, body io.Reader) io.Reader {
+	if response.Header.Get("Content-Type") != "" || (gp.defaultContentType == "" && !gp.contentTypeDetection) {
+		return body
+	}
+	if request.Method == "HEAD" || response.StatusCode == 204 || response.StatusCode == 304 || response.ContentLength == 0 {
+		return body
+	}
+	if gp.contentTypeDetection && !isPartialResponse(response) {
+		// Peek first bytes, partial response does not start with file signature
+		head := make([]byte, 12)
+		n, _ := io.ReadFull(body, head)
+		head = head[:n]
+		body = io.MultiReader(bytes.NewReader(head), body)
+		if contentType := detectImageType(head); contentType != "" {
+			response.Header.Set("Content-Type", contentType)
+			return body
+		}
+	}
+	if gp.defaultContentType != "" {
+		response.Header.Set("Content-Type", gp.defaultContentType)
+	}
+	return body
+}
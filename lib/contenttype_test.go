@@ -0,0 +1,58 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestContentTypeDetection(t *testing.T) {
+	bodies := map[string]string{
+		"/png":   "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR",
+		"/jpeg":  "\xff\xd8\xff\xe0\x00\x10JFIF\x00",
+		"/webp":  "RIFF\x24\x00\x00\x00WEBPVP8 ",
+		"/short": "\xff\xd8",
+		"/text":  "hello world",
+		"/typed": "\x89PNG\r\n\x1a\n",
+	}
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		if request.URL.Path == "/typed" {
+			writer.Header().Set("Content-Type", "application/octet-stream")
+		} else {
+			// Prevent upstream server from sniffing content type itself
+			writer.Header()["Content-Type"] = nil
+		}
+		writer.Write([]byte(bodies[request.URL.Path]))
+	})
+	tests := []struct {
+		name        string
+		path        string
+		detection   bool
+		defaultType string
+		contentType string
+	}{
+		{"png", "/png", true, "", "image/png"},
+		{"jpeg", "/jpeg", true, "", "image/jpeg"},
+		{"webp", "/webp", true, "", "image/webp"},
+		{"detected before default", "/png", true, "application/octet-stream", "image/png"},
+		{"short body falls back to default", "/short", true, "application/octet-stream", "application/octet-stream"},
+		{"unknown without default", "/text", true, "", ""},
+		{"detection disabled", "/png", false, "application/octet-stream", "application/octet-stream"},
+		{"disabled", "/png", false, "", ""},
+		{"upstream content type kept", "/typed", true, "text/plain", "application/octet-stream"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gp := NewGisProxyHandler("/gisproxy/", false)
+			gp.SetContentTypeDetection(test.detection)
+			gp.SetDefaultContentType(test.defaultType)
+			recorder := serve(gp, httptest.NewRequest("GET", "/gisproxy/"+encodeSegment(upstream.URL)+test.path, nil))
+			if contentType := recorder.Result().Header.Get("Content-Type"); contentType != test.contentType {
+				t.Errorf("Content-Type %q, want %q", contentType, test.contentType)
+			}
+			if body := recorder.Body.String(); body != bodies[test.path] {
+				t.Errorf("body %q, want %q", body, bodies[test.path])
+			}
+		})
+	}
+}
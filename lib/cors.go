@@ -0,0 +1,112 @@
+package lib
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// defaultMethods lists methods allowed when no method allowlist is set
+var defaultMethods = []string{"GET", "PUT", "POST", "HEAD", "DELETE", "PATCH", "COPY", "LINK", "OPTIONS"}
+
+// corsPolicy structure
+type corsPolicy struct {
+	allowedOrigins   []string
+	allowCredentials bool
+	allowedHeaders   []string
+	maxAge           int
+}
+
+// allowsOrigin checks if origin is allowed
+func (cp *corsPolicy) allowsOrigin(origin string) bool {
+	for _, allowedOrigin := range cp.allowedOrigins {
+		if allowedOrigin == "*" || strings.EqualFold(allowedOrigin, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsAnyOrigin checks if any origin is allowed
+func (cp *corsPolicy) allowsAnyOrigin() bool {
+	for _, allowedOrigin := range cp.allowedOrigins {
+		if allowedOrigin == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// SetCORSPolicy sets CORS policy replacing permissive AllowCrossOrigin behavior: allowedOrigins
+// may contain "*", allowedHeaders are returned in Access-Control-Allow-Headers and maxAge (seconds)
+// in Access-Control-Max-Age of preflight responses, which are answered without forwarding
+func (gp *GisProxy) SetCORSPolicy(allowedOrigins []string, allowCredentials bool, allowedHeaders []string, maxAge int) {
+	gp.corsPolicy = &corsPolicy{
+		allowedOrigins:   allowedOrigins,
+		allowCredentials: allowCredentials,
+		allowedHeaders:   allowedHeaders,
+		maxAge:           maxAge,
+	}
+}
+
+// isPreflight checks if request is a CORS preflight request
+func isPreflight(request *http.Request) bool {
+	return request.Method == "OPTIONS" && request.Header.Get("Origin") != "" && request.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// writePreflight answers CORS preflight request
+func (gp *GisProxy) writePreflight(writer http.ResponseWriter, request *http.Request) {
+	gp.writeCORSHeader(writer, request)
+	if gp.corsPolicy == nil {
+		// Permissive policy allows requested headers
+		if requestHeaders := request.Header.Get("Access-Control-Request-Headers"); requestHeaders != "" {
+			writer.Header().Set("Access-Control-Allow-Headers", requestHeaders)
+		}
+	} else if gp.corsPolicy.maxAge > 0 && writer.Header().Get("Access-Control-Allow-Origin") != "" {
+		writer.Header().Set("Access-Control-Max-Age", strconv.Itoa(gp.corsPolicy.maxAge))
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+// writeCORSHeader writes CORS response header
+func (gp *GisProxy) writeCORSHeader(writer http.ResponseWriter, request *http.Request) {
+	if gp.corsPolicy == nil && !gp.AllowCrossOrigin {
+		return
+	}
+	defer exposeRangeHeaders(writer)
+	// Proxy CORS policy replaces upstream one
+	for h := range writer.Header() {
+		if strings.HasPrefix(h, "Access-Control-") {
+			writer.Header().Del(h)
+		}
+	}
+	origin := request.Header.Get("Origin")
+	if gp.corsPolicy == nil {
+		// Allow access origin
+		if origin != "" {
+			writer.Header().Set("Access-Control-Allow-Origin", origin)
+			writer.Header().Set("Access-Control-Allow-Credentials", "true")
+			writer.Header().Set("Access-Control-Allow-Methods", gp.methodsHeader())
+		} else {
+			writer.Header().Set("Access-Control-Allow-Origin", "*")
+			writer.Header().Set("Access-Control-Allow-Methods", gp.methodsHeader())
+		}
+		return
+	}
+	writer.Header().Add("Vary", "Origin")
+	if origin == "" || !gp.corsPolicy.allowsOrigin(origin) {
+		return
+	}
+	if gp.corsPolicy.allowsAnyOrigin() && !gp.corsPolicy.allowCredentials {
+		writer.Header().Set("Access-Control-Allow-Origin", "*")
+	} else {
+		writer.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+	if gp.corsPolicy.allowCredentials {
+		writer.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+	writer.Header().Set("Access-Control-Allow-Methods", gp.methodsHeader())
+	if len(gp.corsPolicy.allowedHeaders) > 0 {
+		writer.Header().Set("Access-Control-Allow-Headers", strings.Join(gp.corsPolicy.allowedHeaders, ", "))
+	}
+}
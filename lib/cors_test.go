@@ -0,0 +1,127 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCORSSingleAllowOrigin(t *testing.T) {
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Access-Control-Allow-Origin", "*")
+		writer.Header().Add("Access-Control-Allow-Origin", "http://upstream.example.com")
+		writer.Header().Set("Access-Control-Allow-Methods", "GET")
+		writer.Write([]byte("tile"))
+	})
+	tests := []struct {
+		name   string
+		policy bool
+		origin string
+		want   string
+	}{
+		{"permissive without origin", false, "", "*"},
+		{"permissive with origin", false, "http://client.example.com", "http://client.example.com"},
+		{"policy allowed origin", true, "http://client.example.com", "http://client.example.com"},
+		{"policy other origin", true, "http://other.example.com", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gp := NewGisProxyHandler("/gisproxy/", true)
+			if test.policy {
+				gp.SetCORSPolicy([]string{"http://client.example.com"}, true, nil, 0)
+			}
+			request := httptest.NewRequest("GET", "/gisproxy/"+encodeSegment(upstream.URL), nil)
+			if test.origin != "" {
+				request.Header.Set("Origin", test.origin)
+			}
+			recorder := serve(gp, request)
+			origins := recorder.Header()["Access-Control-Allow-Origin"]
+			if test.want == "" && len(origins) != 0 {
+				t.Errorf("Access-Control-Allow-Origin %v, want none", origins)
+			}
+			if test.want != "" && (len(origins) != 1 || origins[0] != test.want) {
+				t.Errorf("Access-Control-Allow-Origin %v, want [%v]", origins, test.want)
+			}
+			if methods := recorder.Header()["Access-Control-Allow-Methods"]; len(methods) > 1 || (len(methods) == 1 && methods[0] == "GET") {
+				t.Errorf("upstream Access-Control-Allow-Methods returned %v", methods)
+			}
+		})
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	var methods []string
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		methods = append(methods, request.Method)
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+	})
+	tests := []struct {
+		name          string
+		allowCORS     bool
+		requestMethod string
+		status        int
+		forwarded     bool
+	}{
+		{"preflight", true, "GET", http.StatusNoContent, false},
+		{"plain options", true, "", http.StatusMethodNotAllowed, true},
+		{"preflight without cross origin", false, "GET", http.StatusMethodNotAllowed, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			methods = nil
+			gp := NewGisProxyHandler("/gisproxy/", test.allowCORS)
+			request := httptest.NewRequest("OPTIONS", "/gisproxy/"+encodeSegment(upstream.URL+"/tile"), nil)
+			request.Header.Set("Origin", "http://client.example.com")
+			if test.requestMethod != "" {
+				request.Header.Set("Access-Control-Request-Method", test.requestMethod)
+				request.Header.Set("Access-Control-Request-Headers", "Authorization")
+			}
+			recorder := serve(gp, request)
+			if recorder.Code != test.status {
+				t.Errorf("status %v, want %v", recorder.Code, test.status)
+			}
+			if forwarded := len(methods) > 0; forwarded != test.forwarded {
+				t.Errorf("forwarded %v, want %v", forwarded, test.forwarded)
+			}
+			if test.status == http.StatusNoContent {
+				if origin := recorder.Header().Get("Access-Control-Allow-Origin"); origin != "http://client.example.com" {
+					t.Errorf("Access-Control-Allow-Origin %q", origin)
+				}
+				if headers := recorder.Header().Get("Access-Control-Allow-Headers"); headers != "Authorization" {
+					t.Errorf("Access-Control-Allow-Headers %q, want Authorization", headers)
+				}
+			}
+		})
+	}
+}
+
+func TestCORSPreflightNextHandler(t *testing.T) {
+	gp := NewGisProxyHandler("/gisproxy/", true)
+	gp.SetNextHandler(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Access-Control-Allow-Origin", "https://app.example.com")
+		writer.WriteHeader(http.StatusOK)
+	}))
+	tests := []struct {
+		name   string
+		target string
+		status int
+		origin string
+	}{
+		{"application path", "/api/layers", http.StatusOK, "https://app.example.com"},
+		{"proxy path", "/gisproxy/" + encodeSegment("http://example.com/wms"), http.StatusNoContent, "http://client.example.com"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest("OPTIONS", test.target, nil)
+			request.Header.Set("Origin", "http://client.example.com")
+			request.Header.Set("Access-Control-Request-Method", "GET")
+			recorder := serve(gp, request)
+			if recorder.Code != test.status {
+				t.Errorf("status %v, want %v", recorder.Code, test.status)
+			}
+			if origin := recorder.Header().Get("Access-Control-Allow-Origin"); origin != test.origin {
+				t.Errorf("Access-Control-Allow-Origin %q, want %q", origin, test.origin)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package lib
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// basicCredentials structure
+type basicCredentials struct {
+	username string
+	password string
+	hosts    []string
+}
+
+// SetUpstreamBasicAuth sets HTTP Basic credentials sent to upstream hosts without host credentials,
+// overriding client Authorization header. Hosts are required and matched as allowed hosts
+// ("*.example.com" wildcards), so that credentials never reach a host chosen by client.
+// Empty username removes them.
+func (gp *GisProxy) SetUpstreamBasicAuth(username string, password string, hosts []string) error {
+	if username == "" {
+		gp.upstreamBasicAuth = nil
+		return nil
+	}
+	if len(hosts) == 0 {
+		return errors.New("upstream basic auth requires hosts")
+	}
+	gp.upstreamBasicAuth = &basicCredentials{username: username, password: password, hosts: lowerHosts(hosts)}
+	return nil
+}
+
+// SetUpstreamCredentials sets HTTP Basic credentials sent to upstream host (with or without port),
+// overriding client Authorization header. Empty username removes them.
+func (gp *GisProxy) SetUpstreamCredentials(host string, username string, password string) {
+	host = strings.ToLower(host)
+	if username == "" {
+		delete(gp.upstreamCredentials, host)
+		return
+	}
+	if gp.upstreamCredentials == nil {
+		gp.upstreamCredentials = make(map[string]*basicCredentials)
+	}
+	gp.upstreamCredentials[host] = &basicCredentials{username: username, password: password}
+}
+
+// credentialsFor returns upstream credentials for request host
+func (gp *GisProxy) credentialsFor(request *http.Request) *basicCredentials {
+	if credentials, ok := gp.upstreamCredentials[strings.ToLower(request.URL.Host)]; ok {
+		return credentials
+	}
+	if credentials, ok := gp.upstreamCredentials[strings.ToLower(request.URL.Hostname())]; ok {
+		return credentials
+	}
+	if credentials := gp.upstreamBasicAuth; credentials != nil && matchHost(credentials.hosts, strings.ToLower(request.URL.Hostname()), strings.ToLower(request.URL.Host)) {
+		return credentials
+	}
+	return nil
+}
+
+// injectCredentials sets Authorization header of upstream request
+func (gp *GisProxy) injectCredentials(request *http.Request) {
+	if credentials := gp.credentialsFor(request); credentials != nil {
+		request.SetBasicAuth(credentials.username, credentials.password)
+	}
+}
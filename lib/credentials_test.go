@@ -0,0 +1,54 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestUpstreamBasicAuthScopedToHosts(t *testing.T) {
+	gp := NewGisProxyHandler("/", false)
+	if err := gp.SetUpstreamBasicAuth("admin", "geoserver", nil); err == nil {
+		t.Fatal("basic auth without hosts accepted")
+	}
+	if err := gp.SetUpstreamBasicAuth("admin", "geoserver", []string{"geoserver.internal", "*.maps.example"}); err != nil {
+		t.Fatal(err)
+	}
+	gp.SetUpstreamCredentials("other.example:8080", "user", "secret")
+	tests := []struct {
+		url      string
+		username string
+	}{
+		{"http://geoserver.internal/wms", "admin"},
+		{"http://GeoServer.internal:8080/wms", "admin"},
+		{"http://tiles.maps.example/1/2/3.png", "admin"},
+		{"http://attacker.example/", ""},
+		{"http://geoserver.internal.attacker.example/", ""},
+		{"http://other.example:8080/", "user"},
+		{"http://other.example/", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.url, func(t *testing.T) {
+			request := httptest.NewRequest("GET", test.url, nil)
+			request.Header.Del("Authorization")
+			gp.injectCredentials(request)
+			username, _, _ := request.BasicAuth()
+			if username != test.username {
+				t.Errorf("username %q, want %q", username, test.username)
+			}
+		})
+	}
+}
+
+func TestUpstreamBasicAuthNotSentToClientHost(t *testing.T) {
+	var authorization string
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		authorization = request.Header.Get("Authorization")
+	})
+	gp := NewGisProxyHandler("/", false)
+	gp.SetUpstreamBasicAuth("admin", "geoserver", []string{"geoserver.internal"})
+	serve(gp, httptest.NewRequest("GET", "/"+encodeSegment(upstream.URL+"/"), nil))
+	if authorization != "" {
+		t.Errorf("credentials sent to %v: %v", upstream.URL, authorization)
+	}
+}
@@ -0,0 +1,166 @@
+package lib
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+)
+
+// debugCORS structure
+type debugCORS struct {
+	AllowedOrigins   []string `json:"allowedOrigins"`
+	AllowCredentials bool     `json:"allowCredentials"`
+	AllowedHeaders   []string `json:"allowedHeaders"`
+	MaxAge           int      `json:"maxAge"`
+}
+
+// debugCache structure
+type debugCache struct {
+	Enabled      bool     `json:"enabled"`
+	TTL          string   `json:"ttl"`
+	Hits         int64    `json:"hits"`
+	Misses       int64    `json:"misses"`
+	IgnoreParams []string `json:"ignoreParams,omitempty"`
+}
+
+// debugRoute structure
+type debugRoute struct {
+	Prefix       string   `json:"prefix"`
+	AllowedHosts []string `json:"allowedHosts,omitempty"`
+	PlainURL     bool     `json:"plainURL"`
+	BeforeSend   bool     `json:"beforeSend"`
+	AfterReceive bool     `json:"afterReceive"`
+}
+
+// debugConfig structure, secrets (credentials, tokens) are never included
+type debugConfig struct {
+	Version             string                     `json:"version"`
+	Prefix              string                     `json:"prefix"`
+	AllowCrossOrigin    bool                       `json:"allowCrossOrigin"`
+	PublicBaseURL       string                     `json:"publicBaseURL,omitempty"`
+	CORS                *debugCORS                 `json:"cors,omitempty"`
+	AllowedHosts        []string                   `json:"allowedHosts"`
+	AllowedSchemes      []string                   `json:"allowedSchemes"`
+	AllowedMethods      []string                   `json:"allowedMethods"`
+	AllowedServices     []string                   `json:"allowedServices,omitempty"`
+	URLParamName        string                     `json:"urlParamName,omitempty"`
+	RequestTimeout      string                     `json:"requestTimeout"`
+	TimeoutHeader       string                     `json:"timeoutHeader,omitempty"`
+	StreamingOnly       bool                       `json:"streamingOnly"`
+	Cache               debugCache                 `json:"cache"`
+	UpstreamPools       map[string][]ReplicaHealth `json:"upstreamPools,omitempty"`
+	CircuitBreakers     []CircuitState             `json:"circuitBreakers,omitempty"`
+	UpstreamBasicAuth   bool                       `json:"upstreamBasicAuth"`
+	UpstreamCredentials []string                   `json:"upstreamCredentials,omitempty"`
+	ArcGISToken         bool                       `json:"arcGISToken"`
+	URLSigning          bool                       `json:"urlSigning"`
+	Routes              []debugRoute               `json:"routes"`
+	Handlers            []string                   `json:"handlers"`
+	AdminListener       string                     `json:"adminListener,omitempty"`
+}
+
+// EnableDebugEndpoint serves configuration as JSON on path without forwarding (disabled by default),
+// on admin listener only (see AddAdminListener). DebugHandler may be mounted elsewhere behind
+// authentication.
+func (gp *GisProxy) EnableDebugEndpoint(path string) {
+	gp.handleAdminOnly(path, gp.DebugHandler())
+}
+
+// DebugHandler returns configuration JSON handler, upstream credentials are redacted
+func (gp *GisProxy) DebugHandler() http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Type", "application/json")
+		writer.Header().Set("Cache-Control", "no-store")
+		encoder := json.NewEncoder(writer)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(gp.debugConfig())
+	})
+}
+
+// debugConfig returns current configuration
+func (gp *GisProxy) debugConfig() *debugConfig {
+	stats := gp.CacheStats()
+	config := &debugConfig{
+		Version:          Version,
+		Prefix:           gp.currentPrefix().prefix,
+		AllowCrossOrigin: gp.AllowCrossOrigin,
+		PublicBaseURL:    gp.publicBaseURL,
+		AllowedHosts:     gp.allowedHosts,
+		AllowedSchemes:   gp.allowedSchemes,
+		AllowedMethods:   gp.allowedMethods,
+		URLParamName:     gp.urlParamName,
+		RequestTimeout:   gp.requestTimeout.String(),
+		TimeoutHeader:    gp.timeoutHeader,
+		StreamingOnly:    gp.streamingOnly,
+		Cache: debugCache{
+			Enabled: gp.cache != nil,
+			TTL:     gp.cacheTTL.String(),
+			Hits:    stats.Hits,
+			Misses:  stats.Misses,
+		},
+		CircuitBreakers:   gp.CircuitBreakerStates(),
+		UpstreamBasicAuth: gp.upstreamBasicAuth != nil,
+		ArcGISToken:       gp.arcGISTokenProvider != nil,
+		URLSigning:        gp.urlSigningKey != nil,
+		Routes:            make([]debugRoute, 0, len(gp.routes)),
+		Handlers:          make([]string, 0, len(gp.handlers)+len(gp.adminHandlers)+len(gp.prefixResolvers)),
+	}
+	if config.AllowedSchemes == nil {
+		config.AllowedSchemes = defaultSchemes
+	}
+	if config.AllowedMethods == nil {
+		config.AllowedMethods = defaultMethods
+	}
+	if gp.corsPolicy != nil {
+		config.CORS = &debugCORS{
+			AllowedOrigins:   gp.corsPolicy.allowedOrigins,
+			AllowCredentials: gp.corsPolicy.allowCredentials,
+			AllowedHeaders:   gp.corsPolicy.allowedHeaders,
+			MaxAge:           gp.corsPolicy.maxAge,
+		}
+	}
+	for serviceType, allowed := range gp.allowedServices {
+		if allowed {
+			config.AllowedServices = append(config.AllowedServices, serviceType)
+		}
+	}
+	sort.Strings(config.AllowedServices)
+	for param := range gp.cacheIgnoreParams {
+		config.Cache.IgnoreParams = append(config.Cache.IgnoreParams, param)
+	}
+	sort.Strings(config.Cache.IgnoreParams)
+	if len(gp.upstreamPools) > 0 {
+		config.UpstreamPools = make(map[string][]ReplicaHealth)
+		for host, pool := range gp.upstreamPools {
+			config.UpstreamPools[host] = pool.health()
+		}
+	}
+	for host := range gp.upstreamCredentials {
+		// Only hosts are listed, never username or password
+		config.UpstreamCredentials = append(config.UpstreamCredentials, host)
+	}
+	sort.Strings(config.UpstreamCredentials)
+	for _, r := range gp.routes {
+		config.Routes = append(config.Routes, debugRoute{
+			Prefix:       r.prefix,
+			AllowedHosts: r.options.AllowedHosts,
+			PlainURL:     r.options.PlainURL,
+			BeforeSend:   r.options.BeforeSend != nil,
+			AfterReceive: r.options.AfterReceive != nil,
+		})
+	}
+	for path := range gp.handlers {
+		config.Handlers = append(config.Handlers, path)
+	}
+	for path := range gp.adminHandlers {
+		config.Handlers = append(config.Handlers, path)
+	}
+	for prefix := range gp.prefixResolvers {
+		config.Handlers = append(config.Handlers, prefix+"*")
+	}
+	sort.Strings(config.Handlers)
+	if gp.adminServer != nil {
+		config.AdminListener = gp.adminServer.Addr
+	}
+	return config
+}
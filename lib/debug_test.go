@@ -0,0 +1,59 @@
+package lib
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDebugHandler(t *testing.T) {
+	gp := NewGisProxyHandler("/gisproxy/", true)
+	gp.SetAllowedHosts([]string{"*.arcgis.com"})
+	gp.UseCache(10, time.Minute)
+	gp.SetUpstreamPool("backend.example.com", []string{"replica1:8080", "replica2:8080"})
+	gp.AddRoute("/raw/", RouteOptions{PlainURL: true, AllowedHosts: []string{"maps.example.com"}})
+	gp.SetUpstreamCredentials("maps.example.com", "admin", "s3cret-password")
+	if err := gp.SetUpstreamBasicAuth("proxy-user", "other-s3cret", []string{"*.arcgis.com"}); err != nil {
+		t.Fatal(err)
+	}
+	gp.SetHealthCheckPath("/health")
+	recorder := httptest.NewRecorder()
+	gp.DebugHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/debug", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status %v, want %v", recorder.Code, http.StatusOK)
+	}
+	if cacheControl := recorder.Header().Get("Cache-Control"); cacheControl != "no-store" {
+		t.Errorf("Cache-Control %q, want %q", cacheControl, "no-store")
+	}
+	body := recorder.Body.String()
+	for _, secret := range []string{"s3cret-password", "other-s3cret", "admin", "proxy-user"} {
+		if strings.Contains(body, secret) {
+			t.Errorf("debug output contains secret %q", secret)
+		}
+	}
+	var config debugConfig
+	if err := json.Unmarshal(recorder.Body.Bytes(), &config); err != nil {
+		t.Fatal(err)
+	}
+	if config.Prefix != "/gisproxy/" || !config.AllowCrossOrigin || len(config.AllowedHosts) != 1 || config.AllowedHosts[0] != "*.arcgis.com" {
+		t.Errorf("prefix %q cross origin %v allowed hosts %v", config.Prefix, config.AllowCrossOrigin, config.AllowedHosts)
+	}
+	if !config.Cache.Enabled || config.Cache.TTL != "1m0s" {
+		t.Errorf("cache %+v", config.Cache)
+	}
+	if pool := config.UpstreamPools["backend.example.com"]; len(pool) != 2 || !pool[0].Healthy {
+		t.Errorf("upstream pools %+v", config.UpstreamPools)
+	}
+	if len(config.Routes) != 1 || config.Routes[0].Prefix != "/raw/" || !config.Routes[0].PlainURL {
+		t.Errorf("routes %+v", config.Routes)
+	}
+	if !config.UpstreamBasicAuth || len(config.UpstreamCredentials) != 1 || config.UpstreamCredentials[0] != "maps.example.com" {
+		t.Errorf("upstream basic auth %v credentials %v", config.UpstreamBasicAuth, config.UpstreamCredentials)
+	}
+	if len(config.Handlers) != 1 || config.Handlers[0] != "/health" {
+		t.Errorf("handlers %v", config.Handlers)
+	}
+}
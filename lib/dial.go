@@ -0,0 +1,71 @@
+package lib
+
+import (
+	"context"
+	"net"
+	"net/http"
+)
+
+// DialContext defines upstream connection dial function
+type DialContext func(ctx context.Context, network string, addr string) (net.Conn, error)
+
+// SetDialContext sets function dialing upstream connections (SOCKS proxy, service mesh...), nil
+// restores default dialer. When private networks are blocked (see SetBlockPrivateNetworks), address
+// is resolved by proxy and custom dial function receives checked IP address instead of host name.
+func (gp *GisProxy) SetDialContext(dialContext DialContext) {
+	gp.dialContext = dialContext
+	gp.updateTransport(func(transport *http.Transport) {
+		transport.DialContext = gp.dial
+	})
+}
+
+// SetResolver sets resolver of default dialer, keeping its timeout and keep-alive settings
+func (gp *GisProxy) SetResolver(resolver *net.Resolver) {
+	dialer := *gp.dialer
+	dialer.Resolver = resolver
+	gp.dialer = &dialer
+	gp.updateTransport(func(transport *http.Transport) {
+		transport.DialContext = gp.dial
+	})
+}
+
+// dial dials upstream connection with custom dial function or default dialer
+func (gp *GisProxy) dial(ctx context.Context, network string, addr string) (net.Conn, error) {
+	if gp.dialContext == nil {
+		return gp.dialer.DialContext(ctx, network, addr)
+	}
+	if gp.blockPrivateNetworks {
+		return gp.dialPublic(ctx, network, addr)
+	}
+	return gp.dialContext(ctx, network, addr)
+}
+
+// dialPublic resolves address and dials it with custom dial function, rejecting private networks.
+// Resolved IP address is dialed so that custom dial function cannot resolve name to another one.
+func (gp *GisProxy) dialPublic(ctx context.Context, network string, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	resolver := gp.dialer.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	ipAddrs, err := resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	for _, ipAddr := range ipAddrs {
+		if isPrivateIP(ipAddr.IP) {
+			return nil, NewStatusError("Address "+net.JoinHostPort(ipAddr.IP.String(), port)+" not allowed", 403)
+		}
+	}
+	for _, ipAddr := range ipAddrs {
+		var conn net.Conn
+		conn, err = gp.dialContext(ctx, network, net.JoinHostPort(ipAddr.IP.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
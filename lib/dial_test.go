@@ -0,0 +1,88 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestDialContextBlockPrivateNetworks(t *testing.T) {
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		writer.Write([]byte("internal"))
+	})
+	port := upstream.URL[strings.LastIndex(upstream.URL, ":"):]
+	tests := []struct {
+		name         string
+		blockPrivate bool
+		forwardURL   string
+		status       int
+		dialed       string
+	}{
+		{"not blocked", false, "http://localhost" + port + "/wms", http.StatusOK, "localhost" + port},
+		{"ip literal blocked", true, upstream.URL + "/wms", http.StatusForbidden, ""},
+		{"name resolving to loopback blocked", true, "http://localhost" + port + "/wms", http.StatusForbidden, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dialed := ""
+			gp := NewGisProxyHandler("/gisproxy/", false)
+			gp.SetBlockPrivateNetworks(test.blockPrivate)
+			gp.SetDialContext(func(ctx context.Context, network string, addr string) (net.Conn, error) {
+				dialed = addr
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, network, addr)
+			})
+			recorder := serve(gp, httptest.NewRequest("GET", "/gisproxy/"+encodeSegment(test.forwardURL), nil))
+			if recorder.Code != test.status {
+				t.Errorf("status %v, want %v", recorder.Code, test.status)
+			}
+			if dialed != test.dialed {
+				t.Errorf("custom dial function dialed %q, want %q", dialed, test.dialed)
+			}
+		})
+	}
+}
+
+func TestDialPublicDialsResolvedAddress(t *testing.T) {
+	var dialed []string
+	gp := NewGisProxyHandler("/gisproxy/", false)
+	gp.SetDialContext(func(ctx context.Context, network string, addr string) (net.Conn, error) {
+		dialed = append(dialed, addr)
+		return nil, &net.OpError{Op: "dial", Net: network, Err: net.UnknownNetworkError("test")}
+	})
+	if _, err := gp.dialPublic(context.Background(), "tcp", "192.0.2.10:8080"); err == nil {
+		t.Error("dial error not returned")
+	}
+	if len(dialed) != 1 || dialed[0] != "192.0.2.10:8080" {
+		t.Errorf("dialed %v, want [192.0.2.10:8080]", dialed)
+	}
+	if _, err := gp.dialPublic(context.Background(), "tcp", "[::1]:8080"); err == nil || len(dialed) != 1 {
+		t.Errorf("private address dialed (%v)", err)
+	}
+}
+
+func TestSetResolver(t *testing.T) {
+	var lookups int32
+	gp := NewGisProxyHandler("/gisproxy/", false)
+	gp.SetResolver(&net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network string, address string) (net.Conn, error) {
+			atomic.AddInt32(&lookups, 1)
+			return nil, errors.New("test resolver unavailable")
+		},
+	})
+	if recorder := serve(gp, httptest.NewRequest("GET", "/gisproxy/"+encodeSegment("http://maps.gisproxy.test/wms"), nil)); recorder.Code == http.StatusOK {
+		t.Errorf("status %v with unavailable resolver", recorder.Code)
+	}
+	if atomic.LoadInt32(&lookups) == 0 {
+		t.Error("custom resolver not used")
+	}
+	if gp.dialer.Timeout == 0 || gp.dialer.KeepAlive == 0 {
+		t.Error("dialer timeout and keep-alive settings lost")
+	}
+}
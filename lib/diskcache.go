@@ -0,0 +1,225 @@
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// diskCacheMeta structure, stored as first line of cache file
+type diskCacheMeta struct {
+	Key     string      `json:"key"`
+	Expires int64       `json:"expires,omitempty"`
+	Header  http.Header `json:"header"`
+}
+
+// diskCacheFile structure
+type diskCacheFile struct {
+	path       string
+	size       int64
+	accessTime time.Time
+}
+
+// DiskCache is a filesystem Cache surviving restarts. Files are sharded by hash of key under root
+// directory and written atomically (temporary file renamed), so that concurrent writes of a key
+// never expose a half-written file. Least recently accessed files are evicted above maxBytes.
+type DiskCache struct {
+	mutex    sync.Mutex
+	root     string
+	maxBytes int64
+	size     int64
+	files    map[string]*diskCacheFile
+}
+
+// NewDiskCache constructs DiskCache in root directory, indexing existing files (zero maxBytes means no limit)
+func NewDiskCache(root string, maxBytes int64) (*DiskCache, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+	dc := &DiskCache{root: root, maxBytes: maxBytes, files: make(map[string]*diskCacheFile)}
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		if strings.HasSuffix(path, ".tmp") {
+			// Interrupted write
+			return os.Remove(path)
+		}
+		dc.files[path] = &diskCacheFile{path: path, size: info.Size(), accessTime: info.ModTime()}
+		dc.size += info.Size()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	dc.mutex.Lock()
+	dc.evict()
+	dc.mutex.Unlock()
+	return dc, nil
+}
+
+// path returns sharded file path of key
+func (dc *DiskCache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(dc.root, name[:2], name[2:4], name)
+}
+
+// Get returns cache file body and header
+func (dc *DiskCache) Get(key string) ([]byte, http.Header, bool) {
+	path := dc.path(key)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, false
+	}
+	reader := bufio.NewReader(bytes.NewReader(data))
+	line, err := reader.ReadBytes('\n')
+	var meta diskCacheMeta
+	if err != nil || json.Unmarshal(line, &meta) != nil || meta.Key != key {
+		return nil, nil, false
+	}
+	if meta.Expires != 0 && time.Now().Unix() >= meta.Expires {
+		dc.remove(path)
+		return nil, nil, false
+	}
+	dc.touch(path)
+	return data[len(line):], meta.Header, true
+}
+
+// Set writes cache file, evicting least recently accessed files
+func (dc *DiskCache) Set(key string, body []byte, header http.Header, ttl time.Duration) {
+	meta := diskCacheMeta{Key: key, Header: header}
+	if ttl > 0 {
+		meta.Expires = time.Now().Add(ttl).Unix()
+	}
+	line, err := json.Marshal(&meta)
+	if err != nil {
+		return
+	}
+	path := dc.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(append(append(line, '\n'), body...))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+	dc.mutex.Lock()
+	defer dc.mutex.Unlock()
+	if file, ok := dc.files[path]; ok {
+		dc.size -= file.size
+	}
+	size := int64(len(line) + 1 + len(body))
+	dc.files[path] = &diskCacheFile{path: path, size: size, accessTime: time.Now()}
+	dc.size += size
+	dc.evict()
+}
+
+// touch updates file access time, persisted as modification time for restarts
+func (dc *DiskCache) touch(path string) {
+	now := time.Now()
+	dc.mutex.Lock()
+	if file, ok := dc.files[path]; ok {
+		file.accessTime = now
+	}
+	dc.mutex.Unlock()
+	os.Chtimes(path, now, now)
+}
+
+// remove removes file
+func (dc *DiskCache) remove(path string) {
+	dc.mutex.Lock()
+	defer dc.mutex.Unlock()
+	if file, ok := dc.files[path]; ok {
+		dc.size -= file.size
+		delete(dc.files, path)
+	}
+	os.Remove(path)
+}
+
+// Invalidate removes files whose key matches, key is read from file meta line
+func (dc *DiskCache) Invalidate(match func(key string) bool) int {
+	dc.mutex.Lock()
+	paths := make([]string, 0, len(dc.files))
+	for path := range dc.files {
+		paths = append(paths, path)
+	}
+	dc.mutex.Unlock()
+	removed := 0
+	for _, path := range paths {
+		if key, ok := readDiskCacheKey(path); ok && match(key) {
+			dc.remove(path)
+			removed++
+		}
+	}
+	return removed
+}
+
+// readDiskCacheKey reads key of cache file
+func readDiskCacheKey(path string) (string, bool) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", false
+	}
+	defer file.Close()
+	line, err := bufio.NewReader(file).ReadBytes('\n')
+	var meta diskCacheMeta
+	if err != nil || json.Unmarshal(line, &meta) != nil {
+		return "", false
+	}
+	return meta.Key, true
+}
+
+// evict removes least recently accessed files above max bytes, mutex must be held
+func (dc *DiskCache) evict() {
+	if dc.maxBytes <= 0 || dc.size <= dc.maxBytes {
+		return
+	}
+	files := make([]*diskCacheFile, 0, len(dc.files))
+	for _, file := range dc.files {
+		files = append(files, file)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].accessTime.Before(files[j].accessTime)
+	})
+	for _, file := range files {
+		if dc.size <= dc.maxBytes {
+			break
+		}
+		os.Remove(file.path)
+		dc.size -= file.size
+		delete(dc.files, file.path)
+	}
+}
+
+// UseDiskCache uses filesystem cache in root directory for successful GET responses
+func (gp *GisProxy) UseDiskCache(root string, maxBytes int64, ttl time.Duration) error {
+	diskCache, err := NewDiskCache(root, maxBytes)
+	if err != nil {
+		return err
+	}
+	gp.SetCache(diskCache)
+	gp.SetCacheTTL(ttl)
+	return nil
+}
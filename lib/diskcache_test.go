@@ -0,0 +1,151 @@
+package lib
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// diskCacheEntrySize returns size of cache file of key, meta line included
+func diskCacheEntrySize(t *testing.T, dc *DiskCache, key string) int64 {
+	t.Helper()
+	info, err := os.Stat(dc.path(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Size()
+}
+
+func TestDiskCache(t *testing.T) {
+	dc, err := NewDiskCache(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dc.Set("GET http://server/tile", []byte("tile"), http.Header{"Content-Type": {"image/png"}}, 0)
+	body, header, ok := dc.Get("GET http://server/tile")
+	if !ok || string(body) != "tile" || header.Get("Content-Type") != "image/png" {
+		t.Errorf("get %v %q %v, want tile with image/png", ok, body, header)
+	}
+	if _, _, ok := dc.Get("GET http://server/other"); ok {
+		t.Error("missing key found")
+	}
+	dc.Set("GET http://server/expired", []byte("tile"), nil, time.Nanosecond)
+	if _, _, ok := dc.Get("GET http://server/expired"); ok {
+		t.Error("expired key found")
+	}
+	if _, err := os.Stat(dc.path("GET http://server/expired")); !os.IsNotExist(err) {
+		t.Error("expired file not removed")
+	}
+	// Hash collision or foreign file: meta line key must match
+	data, err := ioutil.ReadFile(dc.path("GET http://server/tile"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.MkdirAll(filepath.Dir(dc.path("GET http://server/collision")), 0755)
+	if err := ioutil.WriteFile(dc.path("GET http://server/collision"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok := dc.Get("GET http://server/collision"); ok {
+		t.Error("file of other key returned")
+	}
+	filepath.Walk(dc.root, func(path string, info os.FileInfo, err error) error {
+		if err == nil && strings.HasSuffix(path, ".tmp") {
+			t.Errorf("temporary file %v left", path)
+		}
+		return nil
+	})
+}
+
+func TestDiskCacheEviction(t *testing.T) {
+	root := t.TempDir()
+	probe, err := NewDiskCache(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	probe.Set("a", []byte("0123456789"), nil, 0)
+	size := diskCacheEntrySize(t, probe, "a")
+	dc, err := NewDiskCache(root, 2*size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "b"} {
+		dc.Set(key, []byte("0123456789"), nil, 0)
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Access makes a most recently used
+	if _, _, ok := dc.Get("a"); !ok {
+		t.Fatal("a not found")
+	}
+	time.Sleep(10 * time.Millisecond)
+	dc.Set("c", []byte("0123456789"), nil, 0)
+	for key, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		if _, _, ok := dc.Get(key); ok != want {
+			t.Errorf("%v cached %v, want %v", key, ok, want)
+		}
+	}
+	if dc.size != 2*size {
+		t.Errorf("size %v, want %v", dc.size, 2*size)
+	}
+}
+
+func TestDiskCacheReload(t *testing.T) {
+	root := t.TempDir()
+	dc, err := NewDiskCache(root, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		dc.Set(key, []byte("0123456789"), nil, 0)
+		time.Sleep(10 * time.Millisecond)
+	}
+	dc.Get("a")
+	size := diskCacheEntrySize(t, dc, "a")
+	// Interrupted write of previous run
+	if err := ioutil.WriteFile(filepath.Join(root, "interrupted.123.tmp"), []byte("partial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Restart with smaller limit evicts least recently accessed files
+	reloaded, err := NewDiskCache(root, 2*size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		if _, _, ok := reloaded.Get(key); ok != want {
+			t.Errorf("%v cached %v after restart, want %v", key, ok, want)
+		}
+	}
+	if reloaded.size != 2*size || len(reloaded.files) != 2 {
+		t.Errorf("%v files of %v bytes indexed, want 2 of %v", len(reloaded.files), reloaded.size, 2*size)
+	}
+	if _, err := os.Stat(filepath.Join(root, "interrupted.123.tmp")); !os.IsNotExist(err) {
+		t.Error("temporary file not removed")
+	}
+}
+
+func TestDiskCacheInvalidate(t *testing.T) {
+	dc, err := NewDiskCache(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"GET http://server/wms?layers=roads", "GET http://server/wms?layers=rivers", "GET http://other/tile"} {
+		dc.Set(key, []byte("body"), nil, 0)
+	}
+	if key, ok := readDiskCacheKey(dc.path("GET http://other/tile")); !ok || key != "GET http://other/tile" {
+		t.Errorf("read key %q %v, want %q", key, ok, "GET http://other/tile")
+	}
+	if removed := dc.Invalidate(func(key string) bool { return strings.HasPrefix(key, "GET http://server/") }); removed != 2 {
+		t.Errorf("%v files removed, want 2", removed)
+	}
+	for key, want := range map[string]bool{"GET http://server/wms?layers=roads": false, "GET http://server/wms?layers=rivers": false, "GET http://other/tile": true} {
+		if _, _, ok := dc.Get(key); ok != want {
+			t.Errorf("%v cached %v, want %v", key, ok, want)
+		}
+	}
+	if len(dc.files) != 1 {
+		t.Errorf("%v files indexed, want 1", len(dc.files))
+	}
+}
@@ -0,0 +1,74 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestErrorFormat(t *testing.T) {
+	tests := []struct {
+		name        string
+		format      ErrorFormat
+		err         error
+		status      int
+		contentType string
+		body        string
+		location    string
+	}{
+		{"text status error", ErrorFormatText, NewStatusError("Forbidden layer", 403), 403, "text/plain; charset=utf-8", "Forbidden layer (403)\n", ""},
+		{"json status error", ErrorFormatJSON, NewStatusError("Forbidden layer", 403), 403, "application/json", `{"error":"Forbidden layer","code":403}` + "\n", ""},
+		{"json other error", ErrorFormatJSON, errors.New("boom"), 500, "application/json", `{"error":"boom","code":500}` + "\n", ""},
+		{"json status redirect", ErrorFormatJSON, NewStatusError("http://example.com/login", 302), 302, "", "", "http://example.com/login"},
+		{"json redirect error", ErrorFormatJSON, &RedirectError{Location: "http://example.com/login"}, 302, "", "", "http://example.com/login"},
+		{"text status message", ErrorFormatText, NewStatusError("cached tile", 200), 200, "text/plain; charset=utf-8", "cached tile", ""},
+		{"json direct response", ErrorFormatJSON, &DirectResponse{Status: http.StatusCreated, Body: []byte(`{"ok":true}`), Header: http.Header{"Content-Type": {"application/json"}}}, 201, "application/json", `{"ok":true}`, ""},
+		{"direct response default status", ErrorFormatText, &DirectResponse{Body: []byte("local")}, 200, "", "local", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gp := NewGisProxyHandler("/gisproxy/", false)
+			gp.SetErrorFormat(test.format)
+			gp.SetBeforeSendFunc(func(writer http.ResponseWriter, request *http.Request) error {
+				return test.err
+			})
+			recorder := serve(gp, httptest.NewRequest("GET", "/gisproxy/"+encodeSegment("http://example.com/wms"), nil))
+			if recorder.Code != test.status {
+				t.Errorf("status %v, want %v", recorder.Code, test.status)
+			}
+			if contentType := recorder.Header().Get("Content-Type"); contentType != test.contentType {
+				t.Errorf("content type %q, want %q", contentType, test.contentType)
+			}
+			if body := recorder.Body.String(); body != test.body {
+				t.Errorf("body %q, want %q", body, test.body)
+			}
+			if location := recorder.Header().Get("Location"); location != test.location {
+				t.Errorf("location %q, want %q", location, test.location)
+			}
+		})
+	}
+}
+
+func TestIsCallbackResponse(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"status redirect", NewStatusError("http://example.com/login", 302), true},
+		{"status message", NewStatusError("cached tile", 200), true},
+		{"status error", NewStatusError("Forbidden layer", 403), false},
+		{"redirect error", &RedirectError{Location: "http://example.com/login"}, true},
+		{"wrapped direct response", fmt.Errorf("hook: %w", &DirectResponse{}), true},
+		{"other error", errors.New("boom"), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := isCallbackResponse(test.err); got != test.want {
+				t.Errorf("isCallbackResponse %v, want %v", got, test.want)
+			}
+		})
+	}
+}
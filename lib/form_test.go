@@ -0,0 +1,136 @@
+package lib
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stallingReader reads head, then blocks until released before reading tail
+type stallingReader struct {
+	head    io.Reader
+	tail    io.Reader
+	release chan struct{}
+}
+
+// Read reads head, then tail once released
+func (sr *stallingReader) Read(p []byte) (int, error) {
+	if n, err := sr.head.Read(p); err != io.EOF {
+		return n, err
+	}
+	<-sr.release
+	return sr.tail.Read(p)
+}
+
+func TestParseFormStallingBody(t *testing.T) {
+	const body = "SERVICE=WMS&REQUEST=GetMap&LAYERS=roads"
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		cancel  bool
+	}{
+		{"timeout", 50 * time.Millisecond, false},
+		{"client cancellation", 0, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gp := NewGisProxyHandler("/gisproxy/", false)
+			gp.SetFormParseTimeout(test.timeout)
+			reader := &stallingReader{head: strings.NewReader(body[:12]), tail: strings.NewReader(body[12:]), release: make(chan struct{})}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			request := httptest.NewRequest("POST", "/gisproxy/", reader).WithContext(ctx)
+			request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if test.cancel {
+				time.AfterFunc(50*time.Millisecond, cancel)
+			}
+			parsed := make(chan struct{})
+			go func() {
+				gp.parseForm(request)
+				close(parsed)
+			}()
+			select {
+			case <-parsed:
+			case <-time.After(5 * time.Second):
+				close(reader.release)
+				t.Fatal("form parse blocked on stalled body")
+			}
+			if len(request.PostForm) != 0 {
+				t.Errorf("form %v parsed from stalled body, want none", request.PostForm)
+			}
+			close(reader.release)
+			forwarded, err := ioutil.ReadAll(request.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(forwarded) != body {
+				t.Errorf("forwarded body %q, want %q", forwarded, body)
+			}
+		})
+	}
+}
+
+func TestParseForm(t *testing.T) {
+	const body = "SERVICE=WMS&REQUEST=GetMap&LAYERS=roads"
+	gp := NewGisProxyHandler("/gisproxy/", false)
+	request := httptest.NewRequest("POST", "/gisproxy/", strings.NewReader(body))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	gp.parseForm(request)
+	if service := request.PostForm.Get("SERVICE"); service != "WMS" {
+		t.Errorf("SERVICE %q, want %q", service, "WMS")
+	}
+	forwarded, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(forwarded) != body {
+		t.Errorf("forwarded body %q, want %q", forwarded, body)
+	}
+}
+
+func TestMaxFormParseBytes(t *testing.T) {
+	body := "SERVICE=WMS&REQUEST=GetMap&LAYERS=" + strings.Repeat("roads,", 100)
+	var forwarded string
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		received, _ := ioutil.ReadAll(request.Body)
+		forwarded = string(received)
+	})
+	tests := []struct {
+		name              string
+		maxFormParseBytes int64
+		serviceType       string
+	}{
+		{"parsed", 1 << 20, "WMS"},
+		{"exact limit parsed", int64(len(body)), "WMS"},
+		{"over limit", int64(len(body)) - 1, "unknown"},
+		{"disabled", 0, "unknown"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			forwarded = ""
+			var serviceType string
+			gp := NewGisProxyHandler("/gisproxy/", false)
+			gp.SetMaxFormParseBytes(test.maxFormParseBytes)
+			gp.SetBeforeSendFunc(func(writer http.ResponseWriter, request *http.Request) error {
+				serviceType = GisInfoFromContext(request.Context()).ServiceType
+				return nil
+			})
+			request := httptest.NewRequest("POST", "/gisproxy/"+encodeSegment(upstream.URL+"/ows"), strings.NewReader(body))
+			request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if recorder := serve(gp, request); recorder.Code != http.StatusOK {
+				t.Fatalf("status %v, want %v", recorder.Code, http.StatusOK)
+			}
+			if serviceType != test.serviceType {
+				t.Errorf("service type %q, want %q", serviceType, test.serviceType)
+			}
+			if forwarded != body {
+				t.Errorf("forwarded %v bytes, want %v", len(forwarded), len(body))
+			}
+		})
+	}
+}
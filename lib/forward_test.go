@@ -0,0 +1,220 @@
+package lib
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestForwardURLValidation(t *testing.T) {
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		writer.Write([]byte("upstream"))
+	})
+	next := http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusTeapot)
+	})
+	tests := []struct {
+		name   string
+		target string
+		status int
+	}{
+		{"absolute url", "/gisproxy/" + encodeSegment(upstream.URL+"/wms"), http.StatusOK},
+		{"relative url", "/gisproxy/" + encodeSegment("/etc/passwd"), http.StatusBadRequest},
+		{"missing scheme", "/gisproxy/" + encodeSegment("//example.com/wms"), http.StatusBadRequest},
+		{"missing host", "/gisproxy/" + encodeSegment("http:///wms"), http.StatusBadRequest},
+		{"file scheme", "/gisproxy/" + encodeSegment("file:///etc/passwd"), http.StatusBadRequest},
+		{"gopher scheme", "/gisproxy/" + encodeSegment("gopher://example.com/"), http.StatusBadRequest},
+		{"not a proxy path", "/index.html", http.StatusTeapot},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gp := NewGisProxyHandler("/gisproxy/", false)
+			gp.SetNextHandler(next)
+			if recorder := serve(gp, httptest.NewRequest("GET", test.target, nil)); recorder.Code != test.status {
+				t.Errorf("status %v, want %v", recorder.Code, test.status)
+			}
+		})
+	}
+}
+
+func TestAllowedSchemes(t *testing.T) {
+	tests := []struct {
+		name    string
+		schemes []string
+		target  string
+		status  int
+	}{
+		{"file scheme", nil, "file:///etc/passwd", http.StatusBadRequest},
+		{"ftp scheme", nil, "ftp://example.com/data", http.StatusBadRequest},
+		{"empty scheme", nil, "example.com/data", http.StatusBadRequest},
+		{"scheme not in allowlist", []string{"https"}, "http://example.com/data", http.StatusBadRequest},
+		{"upper case scheme", []string{"HTTPS"}, "ftp://example.com/data", http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gp := NewGisProxyHandler("/gisproxy/", false)
+			gp.SetAllowedSchemes(test.schemes)
+			if recorder := serve(gp, httptest.NewRequest("GET", "/gisproxy/"+encodeSegment(test.target), nil)); recorder.Code != test.status {
+				t.Errorf("status %v, want %v", recorder.Code, test.status)
+			}
+		})
+	}
+}
+
+func TestAllowedSchemesForward(t *testing.T) {
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {})
+	tests := []struct {
+		name    string
+		schemes []string
+		status  int
+	}{
+		{"default schemes", nil, http.StatusOK},
+		{"allowed scheme", []string{"HTTP"}, http.StatusOK},
+		{"https only", []string{"https"}, http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gp := NewGisProxyHandler("/gisproxy/", false)
+			gp.SetAllowedSchemes(test.schemes)
+			if recorder := serve(gp, httptest.NewRequest("GET", "/gisproxy/"+encodeSegment(upstream.URL), nil)); recorder.Code != test.status {
+				t.Errorf("status %v, want %v", recorder.Code, test.status)
+			}
+		})
+	}
+}
+
+func TestForwardURLEncodings(t *testing.T) {
+	var path string
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		path = request.URL.RequestURI()
+	})
+	// Url whose standard base64 encoding holds '+', '/' and '=' characters, escaped in path
+	target := upstream.URL + "/wms?a=>>>??&b=~~"
+	tests := []struct {
+		name    string
+		segment string
+	}{
+		{"standard escaped", url.PathEscape(base64.StdEncoding.EncodeToString([]byte(target)))},
+		{"url-safe padded", base64.URLEncoding.EncodeToString([]byte(target))},
+		{"url-safe unpadded", base64.RawURLEncoding.EncodeToString([]byte(target))},
+	}
+	gp := NewGisProxyHandler("/gisproxy/", false)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path = ""
+			recorder := serve(gp, httptest.NewRequest("GET", "/gisproxy/"+test.segment, nil))
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("status %v, want %v", recorder.Code, http.StatusOK)
+			}
+			if path != "/wms?a=>>>??&b=~~" {
+				t.Errorf("forwarded %v, want %v", path, "/wms?a=>>>??&b=~~")
+			}
+		})
+	}
+	if recorder := serve(gp, httptest.NewRequest("GET", "/gisproxy/not*base64", nil)); recorder.Code == http.StatusOK {
+		t.Errorf("invalid base64 status %v", recorder.Code)
+	}
+}
+
+func TestForwardHead(t *testing.T) {
+	var method string
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		method = request.Method
+		writer.Header().Set("Content-Type", "application/json")
+		writer.Header().Set("Content-Length", "4096")
+	})
+	var rewritten bool
+	gp := NewGisProxyHandler("/gisproxy/", false)
+	gp.SetMaxResponseBodyBytes(1024)
+	gp.SetBodyRewriteFunc(func(ctx context.Context, info *GisInfo, contentType string, body []byte) ([]byte, error) {
+		rewritten = true
+		return body, nil
+	}, nil)
+	recorder := serve(gp, httptest.NewRequest("HEAD", "/gisproxy/"+encodeSegment(upstream.URL), nil))
+	if method != "HEAD" {
+		t.Errorf("upstream method %v, want HEAD", method)
+	}
+	if recorder.Code != http.StatusOK {
+		t.Errorf("status %v, want %v", recorder.Code, http.StatusOK)
+	}
+	if recorder.Body.Len() != 0 {
+		t.Errorf("%v body bytes written, want 0", recorder.Body.Len())
+	}
+	if length := recorder.Header().Get("Content-Length"); length != "4096" {
+		t.Errorf("Content-Length %q, want %q", length, "4096")
+	}
+	if rewritten {
+		t.Error("body rewrite called for HEAD")
+	}
+}
+
+func TestPathNormalization(t *testing.T) {
+	const base = "https://services.example.com/arcgis/rest/services/roads/FeatureServer/"
+	tests := []struct {
+		name      string
+		target    string
+		remaining string
+		normalize bool
+		forward   string
+	}{
+		{"trailing and leading slashes", base, "/query?f=json", true, "https://services.example.com/arcgis/rest/services/roads/FeatureServer/query?f=json"},
+		{"repeated slashes in remaining path", base, "/0///query", true, "https://services.example.com/arcgis/rest/services/roads/FeatureServer/0/query"},
+		{"repeated slashes in decoded url", "https://services.example.com//arcgis//rest/", "/info", true, "https://services.example.com/arcgis/rest/info"},
+		{"query unchanged", base, "/query?url=http://other//path", true, "https://services.example.com/arcgis/rest/services/roads/FeatureServer/query?url=http://other//path"},
+		{"disabled", base, "/query", false, "https://services.example.com/arcgis/rest/services/roads/FeatureServer//query"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gp := NewGisProxyHandler("/gisproxy/", false)
+			gp.SetPathNormalization(test.normalize)
+			forwardUrl, err := gp.ComputeForwardUrl(httptest.NewRequest("GET", "/gisproxy/"+encodeSegment(test.target)+test.remaining, nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if forward := forwardUrl.String(); forward != test.forward {
+				t.Errorf("forward url %v, want %v", forward, test.forward)
+			}
+		})
+	}
+}
+
+func TestURLParamMode(t *testing.T) {
+	var received string
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		received = request.URL.RequestURI()
+	})
+	pathSegment := encodeSegment(upstream.URL + "/path")
+	paramValue := url.QueryEscape(base64.StdEncoding.EncodeToString([]byte(upstream.URL + "/param?SERVICE=WMS")))
+	tests := []struct {
+		name     string
+		enabled  bool
+		target   string
+		status   int
+		received string
+	}{
+		{"param", true, "/gisproxy/?url=" + paramValue + "&REQUEST=GetMap", http.StatusOK, "/param?SERVICE=WMS&REQUEST=GetMap"},
+		{"param first", true, "/gisproxy/?REQUEST=GetMap&url=" + paramValue, http.StatusOK, "/param?SERVICE=WMS&REQUEST=GetMap"},
+		{"path precedence", true, "/gisproxy/" + pathSegment + "?url=" + paramValue, http.StatusOK, "/path?url=" + paramValue},
+		{"missing param", true, "/gisproxy/?REQUEST=GetMap", http.StatusInternalServerError, ""},
+		{"invalid base64", true, "/gisproxy/?url=%25%25", http.StatusInternalServerError, ""},
+		{"disabled", false, "/gisproxy/?url=" + paramValue, http.StatusInternalServerError, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			received = ""
+			gp := NewGisProxyHandler("/gisproxy/", false)
+			if test.enabled {
+				gp.SetURLParamMode("url")
+			}
+			if recorder := serve(gp, httptest.NewRequest("GET", test.target, nil)); recorder.Code != test.status {
+				t.Errorf("status %v, want %v", recorder.Code, test.status)
+			}
+			if received != test.received {
+				t.Errorf("upstream received %q, want %q", received, test.received)
+			}
+		})
+	}
+}
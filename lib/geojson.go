@@ -0,0 +1,235 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// geoJSONMaxFeatures is maximum number of features filtered, larger collections are passed through
+const geoJSONMaxFeatures = 100000
+
+// geoJSONGeometry structure
+type geoJSONGeometry struct {
+	Coordinates interface{}       `json:"coordinates"`
+	Geometries  []geoJSONGeometry `json:"geometries"`
+}
+
+// geoJSONFeature structure
+type geoJSONFeature struct {
+	Geometry *geoJSONGeometry `json:"geometry"`
+}
+
+// envelope structure
+type envelope struct {
+	minX, minY, maxX, maxY float64
+	empty                  bool
+}
+
+// EnableGeoJSONBBoxFilter drops features of application/geo+json FeatureCollection responses whose
+// geometry envelope is outside bbox query parameter (minx,miny,maxx,maxy) of client request.
+// Responses that can not be parsed or with too many features are passed through unchanged.
+func (gp *GisProxy) EnableGeoJSONBBoxFilter(enabled bool) {
+	gp.geoJSONBBoxFilter = enabled
+}
+
+// geoJSONBBoxRewriter returns rewrite function filtering GeoJSON response, nil if not applicable
+func (gp *GisProxy) geoJSONBBoxRewriter(request *http.Request, response *http.Response) BodyRewrite {
+	if !gp.geoJSONBBoxFilter || !strings.HasPrefix(strings.ToLower(response.Header.Get("Content-Type")), "application/geo+json") {
+		return nil
+	}
+	bbox, ok := parseGeoJSONBBox(formValue(request.URL.Query(), "bbox"))
+	if !ok {
+		return nil
+	}
+	return func(ctx context.Context, info *GisInfo, contentType string, body []byte) ([]byte, error) {
+		return filterGeoJSON(body, bbox), nil
+	}
+}
+
+// parseGeoJSONBBox parses minx,miny,maxx,maxy bbox, extra values (crs) are ignored
+func parseGeoJSONBBox(value string) (envelope, bool) {
+	parts := strings.Split(value, ",")
+	if len(parts) < 4 {
+		return envelope{}, false
+	}
+	coords := make([]float64, 4)
+	for i := range coords {
+		coord, err := strconv.ParseFloat(strings.TrimSpace(parts[i]), 64)
+		if err != nil {
+			return envelope{}, false
+		}
+		coords[i] = coord
+	}
+	if coords[0] > coords[2] || coords[1] > coords[3] {
+		return envelope{}, false
+	}
+	return envelope{minX: coords[0], minY: coords[1], maxX: coords[2], maxY: coords[3]}, true
+}
+
+// geoJSONMember structure, collection member kept in original order
+type geoJSONMember struct {
+	key   string
+	value json.RawMessage
+}
+
+// filterGeoJSON returns FeatureCollection without features outside bbox, body unchanged on failure.
+// Features are decoded one at a time so that decoding stops once feature count exceeds maximum.
+func filterGeoJSON(body []byte, bbox envelope) []byte {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return body
+	}
+	members := make([]geoJSONMember, 0)
+	var collectionType string
+	var kept []json.RawMessage
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return body
+		}
+		key, ok := token.(string)
+		if !ok {
+			return body
+		}
+		if key == "features" {
+			if kept != nil {
+				return body
+			}
+			if kept, ok = decodeGeoJSONFeatures(decoder, bbox); !ok {
+				return body
+			}
+			members = append(members, geoJSONMember{key: key})
+			continue
+		}
+		var value json.RawMessage
+		if decoder.Decode(&value) != nil {
+			return body
+		}
+		if key == "type" && json.Unmarshal(value, &collectionType) != nil {
+			return body
+		}
+		// Collection bbox is no longer accurate
+		if key != "bbox" {
+			members = append(members, geoJSONMember{key: key, value: value})
+		}
+	}
+	if token, err := decoder.Token(); err != nil || token != json.Delim('}') || collectionType != "FeatureCollection" || kept == nil {
+		return body
+	}
+	var result bytes.Buffer
+	result.WriteByte('{')
+	for i, member := range members {
+		if i > 0 {
+			result.WriteByte(',')
+		}
+		key, _ := json.Marshal(member.key)
+		result.Write(key)
+		result.WriteByte(':')
+		if member.key != "features" {
+			result.Write(member.value)
+			continue
+		}
+		result.WriteByte('[')
+		for j, raw := range kept {
+			if j > 0 {
+				result.WriteByte(',')
+			}
+			result.Write(raw)
+		}
+		result.WriteByte(']')
+	}
+	result.WriteByte('}')
+	return result.Bytes()
+}
+
+// decodeGeoJSONFeatures decodes features array and returns features not outside bbox, false when
+// array is invalid or has more than maximum features
+func decodeGeoJSONFeatures(decoder *json.Decoder, bbox envelope) ([]json.RawMessage, bool) {
+	if token, err := decoder.Token(); err != nil || token != json.Delim('[') {
+		return nil, false
+	}
+	kept := make([]json.RawMessage, 0)
+	for count := 0; decoder.More(); count++ {
+		if count >= geoJSONMaxFeatures {
+			return nil, false
+		}
+		var raw json.RawMessage
+		if decoder.Decode(&raw) != nil {
+			return nil, false
+		}
+		var feature geoJSONFeature
+		if json.Unmarshal(raw, &feature) != nil {
+			return nil, false
+		}
+		if feature.Geometry == nil {
+			// Features without geometry are not outside bbox
+			kept = append(kept, raw)
+			continue
+		}
+		env := envelope{empty: true}
+		env.addGeometry(feature.Geometry)
+		if env.empty || env.intersects(bbox) {
+			kept = append(kept, raw)
+		}
+	}
+	if token, err := decoder.Token(); err != nil || token != json.Delim(']') {
+		return nil, false
+	}
+	return kept, true
+}
+
+// addGeometry extends envelope with geometry coordinates
+func (env *envelope) addGeometry(geometry *geoJSONGeometry) {
+	env.addCoordinates(geometry.Coordinates)
+	for i := range geometry.Geometries {
+		env.addGeometry(&geometry.Geometries[i])
+	}
+}
+
+// addCoordinates extends envelope with nested coordinate arrays
+func (env *envelope) addCoordinates(coordinates interface{}) {
+	values, ok := coordinates.([]interface{})
+	if !ok {
+		return
+	}
+	if len(values) >= 2 {
+		x, okX := values[0].(float64)
+		y, okY := values[1].(float64)
+		if okX && okY {
+			env.add(x, y)
+			return
+		}
+	}
+	for _, value := range values {
+		env.addCoordinates(value)
+	}
+}
+
+// add extends envelope with point
+func (env *envelope) add(x float64, y float64) {
+	if env.empty {
+		env.minX, env.minY, env.maxX, env.maxY, env.empty = x, y, x, y, false
+		return
+	}
+	if x < env.minX {
+		env.minX = x
+	}
+	if y < env.minY {
+		env.minY = y
+	}
+	if x > env.maxX {
+		env.maxX = x
+	}
+	if y > env.maxY {
+		env.maxY = y
+	}
+}
+
+// intersects checks if envelopes intersect
+func (env *envelope) intersects(other envelope) bool {
+	return env.minX <= other.maxX && env.maxX >= other.minX && env.minY <= other.maxY && env.maxY >= other.minY
+}
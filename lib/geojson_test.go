@@ -0,0 +1,115 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const (
+	insideFeature  = `{"type":"Feature","id":1,"geometry":{"type":"Point","coordinates":[1,1]}}`
+	outsideFeature = `{"type":"Feature","id":2,"geometry":{"type":"Point","coordinates":[20,20]}}`
+	crossFeature   = `{"type":"Feature","id":3,"geometry":{"type":"LineString","coordinates":[[-5,1],[5,1]]}}`
+	nullFeature    = `{"type":"Feature","id":4,"geometry":null}`
+)
+
+func TestFilterGeoJSON(t *testing.T) {
+	bbox := envelope{minX: 0, minY: 0, maxX: 10, maxY: 10}
+	tooMany := `{"type":"FeatureCollection","features":[` + strings.Repeat(nullFeature+",", geoJSONMaxFeatures) + nullFeature + `]}`
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"filtered", `{"type":"FeatureCollection","features":[` + insideFeature + `,` + outsideFeature + `]}`, `{"type":"FeatureCollection","features":[` + insideFeature + `]}`},
+		{"crossing and null geometries kept", `{"type":"FeatureCollection","features":[` + crossFeature + `,` + nullFeature + `]}`, `{"type":"FeatureCollection","features":[` + crossFeature + `,` + nullFeature + `]}`},
+		{"bbox removed and member order kept", `{"features":[` + outsideFeature + `],"bbox":[0,0,20,20],"type":"FeatureCollection","name":"roads"}`, `{"features":[],"type":"FeatureCollection","name":"roads"}`},
+		{"not a collection", `{"type":"Feature","geometry":null}`, `{"type":"Feature","geometry":null}`},
+		{"invalid json", `{"type":"FeatureCollection","features":[`, `{"type":"FeatureCollection","features":[`},
+		{"invalid features", `{"type":"FeatureCollection","features":{}}`, `{"type":"FeatureCollection","features":{}}`},
+		{"too many features", tooMany, tooMany},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := string(filterGeoJSON([]byte(test.body), bbox)); got != test.want {
+				t.Errorf("body %.200s, want %.200s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestGeoJSONBBoxFilter(t *testing.T) {
+	collection := `{"type":"FeatureCollection","features":[` + insideFeature + `,` + outsideFeature + `]}`
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Type", request.URL.Query().Get("ct"))
+		writer.Write([]byte(collection))
+	})
+	tests := []struct {
+		name        string
+		contentType string
+		bbox        string
+		want        string
+	}{
+		{"filtered", "application/geo+json", "0,0,10,10", `{"type":"FeatureCollection","features":[` + insideFeature + `]}`},
+		{"no bbox", "application/geo+json", "", collection},
+		{"invalid bbox", "application/geo+json", "10,10,0,0", collection},
+		{"other content type", "application/json", "0,0,10,10", collection},
+	}
+	gp := NewGisProxyHandler("/gisproxy/", false)
+	gp.EnableGeoJSONBBoxFilter(true)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			target := "/gisproxy/" + encodeSegment(upstream.URL+"/features") + "?ct=" + url.QueryEscape(test.contentType)
+			if test.bbox != "" {
+				target += "&bbox=" + test.bbox
+			}
+			recorder := serve(gp, httptest.NewRequest("GET", target, nil))
+			if recorder.Body.String() != test.want {
+				t.Errorf("body %v, want %v", recorder.Body.String(), test.want)
+			}
+		})
+	}
+}
+
+func TestGeoJSONBBoxFilterLargeBody(t *testing.T) {
+	collection := `{"type":"FeatureCollection","name":"` + strings.Repeat("x", maxBufferedBodyBytes) + `","features":[` + insideFeature + `,` + outsideFeature + `]}`
+	encoded := encodeTestBody(t, "gzip", collection)
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Type", "application/geo+json")
+		if request.URL.Path == "/gzip" {
+			writer.Header().Set("Content-Encoding", "gzip")
+			writer.Write(encoded)
+			return
+		}
+		writer.Write([]byte(collection))
+	})
+	tests := []struct {
+		name     string
+		path     string
+		encoding string
+		want     string
+	}{
+		{"too large", "/features", "", collection},
+		{"decoded too large", "/gzip", "gzip", string(encoded)},
+	}
+	gp := NewGisProxyHandler("/gisproxy/", false)
+	gp.EnableGeoJSONBBoxFilter(true)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest("GET", "/gisproxy/"+encodeSegment(upstream.URL+test.path)+"?bbox=0,0,10,10", nil)
+			request.Header.Set("Accept-Encoding", "gzip")
+			recorder := serve(gp, request)
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("status %v, want %v", recorder.Code, http.StatusOK)
+			}
+			if encoding := recorder.Header().Get("Content-Encoding"); encoding != test.encoding {
+				t.Errorf("Content-Encoding %q, want %q", encoding, test.encoding)
+			}
+			if recorder.Body.String() != test.want {
+				t.Errorf("%v bytes, want %v unfiltered bytes", recorder.Body.Len(), len(test.want))
+			}
+		})
+	}
+}
@@ -4,18 +4,23 @@ import (
 	"bytes"
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
 	"net"
 	"net/http"
 	"net/url"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
+
+	"golang.org/x/net/http2"
 )
 
 // The contextKey type is unexported to prevent collisions with context keys defined in
@@ -40,7 +45,17 @@ func GisInfoFromContext(ctx context.Context) *GisInfo {
 	return v.(*GisInfo)
 }
 
-// StatusError struct
+// ForwardInfoFromContext retrives ForwardInfo from context
+func ForwardInfoFromContext(ctx context.Context) *ForwardInfo {
+	v := ctx.Value(contextKey("ForwardInfo"))
+	if v == nil {
+		return nil
+	}
+	return v.(*ForwardInfo)
+}
+
+// StatusError struct, for compatibility code 302 redirects to Message and code 200 writes
+// Message as body: use RedirectError and DirectResponse instead
 type StatusError struct {
 	Message string
 	Code    int
@@ -56,31 +71,199 @@ func (e *StatusError) Error() string {
 	return fmt.Sprintf("%v (%v)", e.Message, e.Code)
 }
 
-// BeforeSend defines before send callback function
+// RedirectError may be returned by callbacks to redirect client to Location with 302
+type RedirectError struct {
+	Location string
+}
+
+// Error implements the error interface
+func (e *RedirectError) Error() string {
+	return fmt.Sprintf("Redirect to %v", e.Location)
+}
+
+// DirectResponse may be returned by callbacks to write response instead of forwarded one,
+// zero Status means 200
+type DirectResponse struct {
+	Status int
+	Body   []byte
+	Header http.Header
+}
+
+// Error implements the error interface
+func (e *DirectResponse) Error() string {
+	return fmt.Sprintf("Direct response (%v)", e.status())
+}
+
+// status returns response status code
+func (e *DirectResponse) status() int {
+	if e.Status == 0 {
+		return http.StatusOK
+	}
+	return e.Status
+}
+
+// isCallbackResponse checks if callback error is a redirect or direct response rather than a failure
+func isCallbackResponse(err error) bool {
+	var statusError *StatusError
+	if errors.As(err, &statusError) {
+		return statusError.Code == 302 || statusError.Code == 200
+	}
+	var redirectError *RedirectError
+	var directResponse *DirectResponse
+	return errors.As(err, &redirectError) || errors.As(err, &directResponse)
+}
+
+// ArcGISTokenProvider defines ArcGIS token provider function
+type ArcGISTokenProvider func(ctx context.Context, info *GisInfo) (string, error)
+
+// ErrorFormat defines error response body format
+type ErrorFormat int
+
+const (
+	// ErrorFormatText writes plain text error body
+	ErrorFormatText ErrorFormat = iota
+	// ErrorFormatJSON writes {"error":"...","code":NNN} error body
+	ErrorFormatJSON
+)
+
+// errorBody structure
+type errorBody struct {
+	Error string `json:"error"`
+	Code  int    `json:"code"`
+}
+
+// BeforeSend defines before send callback function, returning a *RedirectError or a *DirectResponse
+// answers client without forwarding request
 type BeforeSend func(http.ResponseWriter, *http.Request) error
 
-// AfterReceive defines after receive callback function
+// AfterReceive defines after receive callback function, returning a *RedirectError or a *DirectResponse
+// replaces upstream response
 type AfterReceive func(http.ResponseWriter, *http.Response) error
 
+// URLRewrite defines forward url rewrite callback function
+type URLRewrite func(ctx context.Context, info *GisInfo, u *url.URL) (*url.URL, error)
+
+// RequestInterceptor defines request interceptor callback function
+type RequestInterceptor func(ctx context.Context, writer http.ResponseWriter, request *http.Request) (bool, error)
+
 var (
 	reMapServer     = regexp.MustCompile("(?i)/services/(.+)/mapserver/?")
 	reFeatureServer = regexp.MustCompile("(?i)/services/(.+)/featureserver/?")
 	reImageServer   = regexp.MustCompile("(?i)/services/(.+)/imageserver/?")
+	reTMS           = regexp.MustCompile("(?i)/tms/(?:[0-9.]+/)?(.+)/([0-9]+)/([0-9]+)/([0-9]+)\\.(?:png|jpe?g|gif|webp)$")
+	reVectorTile    = regexp.MustCompile("(?i)(?:/([^/]+))?/tiles/([0-9]+)/([0-9]+)/([0-9]+)\\.(?:pbf|mvt)$")
+	reXYZ           = regexp.MustCompile("(?i)(?:/([^/]+))?/([0-9]+)/([0-9]+)/([0-9]+)\\.(?:png|jpe?g|gif|webp)$")
 )
 
+// defaultSchemes lists forward url schemes allowed by default
+var defaultSchemes = []string{"http", "https"}
+
+// GisProxy is an http.Handler
+var _ http.Handler = (*GisProxy)(nil)
+
 // GisProxy structure
 type GisProxy struct {
-	server           *http.Server
-	serverMux        *http.ServeMux
-	client           *http.Client
-	Prefix           string
-	AllowCrossOrigin bool
-	https            bool
-	crtfile          string
-	keyfile          string
-	next             http.Handler
-	beforeSendFunc   BeforeSend
-	afterReceiveFunc AfterReceive
+	// first fields to be 64-bit aligned for atomic operations
+	totalBytesServed         int64
+	cacheHits                int64
+	cacheMisses              int64
+	server                   *http.Server
+	serverMux                *http.ServeMux
+	client                   *http.Client
+	Prefix                   string
+	AllowCrossOrigin         bool
+	https                    bool
+	crtfile                  string
+	keyfile                  string
+	next                     http.Handler
+	beforeSendFunc           BeforeSend
+	afterReceiveFunc         AfterReceive
+	cache                    Cache
+	cacheTTL                 time.Duration
+	requestTimeout           time.Duration
+	allowedHosts             []string
+	blockPrivateNetworks     bool
+	errorFormat              ErrorFormat
+	compression              bool
+	compressionMinBytes      int
+	maxRetries               int
+	retryBackoff             time.Duration
+	metrics                  *metrics
+	arcGISTokenProvider      ArcGISTokenProvider
+	rateLimiter              *rateLimiter
+	rateLimitKeyFunc         RateLimitKeyFunc
+	bodyRewriteFunc          BodyRewrite
+	rewriteContentTypes      []string
+	handlers                 map[string]http.Handler
+	startTime                time.Time
+	routes                   []*route
+	maxFormParseBytes        int64
+	capabilitiesRewrite      bool
+	publicBaseURL            string
+	activeRequests           int32
+	drainCallback            func(remaining int)
+	dialer                   *net.Dialer
+	h2cTransport             *http2.Transport
+	forwardHeaderPolicy      *headerPolicy
+	responseHeaderPolicy     *headerPolicy
+	corsPolicy               *corsPolicy
+	accessLogger             AccessLogger
+	wmtsRestPattern          *regexp.Regexp
+	upstreamBasicAuth        *basicCredentials
+	upstreamCredentials      map[string]*basicCredentials
+	urlRewriteFunc           URLRewrite
+	upstreamPools            map[string]*upstreamPool
+	circuitBreaker           *circuitBreaker
+	followRedirects          int
+	rewriteRedirectLocation  bool
+	maxRequestBodyBytes      int64
+	maxResponseBodyBytes     int64
+	responseLimitExemptTypes []string
+	defaultParams            map[string]url.Values
+	wmsLimits                *wmsLimits
+	allowedServices          map[string]bool
+	allowUnknownServices     bool
+	allowedMethods           []string
+	dialContext              DialContext
+	streamingOnly            bool
+	forwardedHeaders         bool
+	trustedProxies           []*net.IPNet
+	urlParamName             string
+	allowedSchemes           []string
+	wmsFeatureInfoLimit      int
+	prefixResolvers          map[string]forwardResolver
+	flights                  *flightGroup
+	upstreamUserAgent        string
+	appendUserAgent          bool
+	cacheIgnoreParams        map[string]bool
+	logger                   Logger
+	wmsVersion               string
+	timeoutHeader            string
+	maxTimeout               time.Duration
+	adminServer              *http.Server
+	adminHandlers            map[string]http.Handler
+	webSocketProxy           bool
+	defaultContentType       string
+	contentTypeDetection     bool
+	arcGISTokenRetry         bool
+	requestInterceptor       RequestInterceptor
+	trustForwardedHeaders    bool
+	upstreamHost             string
+	preserveClientHost       bool
+	requestIDHeader          string
+	tracer                   Tracer
+	formParseTimeout         time.Duration
+	geoJSONBBoxFilter        bool
+	urlSigningKey            []byte
+	upstreamLimiter          *upstreamLimiter
+	connLimiter              *connLimiter
+	pathNormalization        bool
+	prefixMatcher            atomic.Value
+	copyBuffers              *bufferPool
+	responseValidator        ResponseValidator
+	stripParams              map[string]bool
+	adminOnlyPaths           map[string]bool
+	retryMethods             []string
 }
 
 // GisInfo structure
@@ -89,9 +272,36 @@ type GisInfo struct {
 	ServerType  string
 	ServiceType string
 	ServiceName string
+	// Operation is the OGC request parameter (GetMap, GetFeature, GetCapabilities...)
+	Operation string
+	// OutputFormat is the WFS outputFormat parameter
+	OutputFormat string
+	// Tile coordinates for XYZ, TMS and WMTS RESTful tiles, TileY uses XYZ (top-left origin) scheme
+	TileZ int
+	TileX int
+	TileY int
+}
+
+// ForwardInfo structure
+type ForwardInfo struct {
+	// Prefix matched in incoming request
+	Prefix string
+	// DecodedURL is the decoded base64 segment
+	DecodedURL string
+	// Base64Segment is the raw base64 segment of incoming request
+	Base64Segment string
+	// RemainingPath follows base64 segment in incoming request, including query
+	RemainingPath string
+	route         *route
 }
 
 func (gi *GisInfo) String() string {
+	if gi.ServerType == "XYZ" || gi.ServerType == "TMS" || gi.ServerType == "MVT" {
+		return fmt.Sprintf("GisInfo ServerURL=%v ServerType=%v ServiceType=%v ServiceName=%v Tile=%v/%v/%v", gi.ServerURL, gi.ServerType, gi.ServiceType, gi.ServiceName, gi.TileZ, gi.TileX, gi.TileY)
+	}
+	if gi.Operation != "" || gi.OutputFormat != "" {
+		return fmt.Sprintf("GisInfo ServerURL=%v ServerType=%v ServiceType=%v ServiceName=%v Operation=%v OutputFormat=%v", gi.ServerURL, gi.ServerType, gi.ServiceType, gi.ServiceName, gi.Operation, gi.OutputFormat)
+	}
 	return fmt.Sprintf("GisInfo ServerURL=%v ServerType=%v ServiceType=%v ServiceName=%v", gi.ServerURL, gi.ServerType, gi.ServiceType, gi.ServiceName)
 }
 
@@ -103,21 +313,28 @@ func NewGisProxy(listen string, prefix string, allowCrossOrigin bool) *GisProxy
 		gp.server = &http.Server{Addr: listen, Handler: gp.serverMux}
 	}
 	gp.Prefix = prefix
+	// Compile forward url regexp once
+	gp.currentPrefix()
 	gp.AllowCrossOrigin = allowCrossOrigin
 	gp.https = false
+	gp.startTime = time.Now()
+	gp.maxFormParseBytes = 10 << 20
+	gp.formParseTimeout = 5 * time.Second
+	gp.logger = &StdLogger{}
+	gp.copyBuffers = newBufferPool(defaultCopyBufferSize)
 	// create http client
 	gp.client = &http.Client{
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			return http.ErrUseLastResponse
-		},
+		CheckRedirect: gp.checkRedirect,
+	}
+	gp.dialer = &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		DualStack: true,
+		Control:   gp.dialControl,
 	}
 	gp.client.Transport = &http.Transport{
-		Proxy: http.ProxyFromEnvironment,
-		DialContext: (&net.Dialer{
-			Timeout:   30 * time.Second,
-			KeepAlive: 30 * time.Second,
-			DualStack: true,
-		}).DialContext,
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           gp.dialer.DialContext,
 		ForceAttemptHTTP2:     true,
 		MaxIdleConns:          100,
 		IdleConnTimeout:       90 * time.Second,
@@ -128,6 +345,25 @@ func NewGisProxy(listen string, prefix string, allowCrossOrigin bool) *GisProxy
 	return gp
 }
 
+// NewGisProxyHandler constructs GisProxy used as http.Handler, without server: Start and Stop return an error
+func NewGisProxyHandler(prefix string, allowCrossOrigin bool) *GisProxy {
+	return NewGisProxy("", prefix, allowCrossOrigin)
+}
+
+// SetServerTimeouts sets server read, read header, write and idle timeouts, 0 disables a timeout.
+// Write timeout covers whole response: it aborts large tile or feature downloads and long streamed
+// responses still being written, so it should be larger than request timeout or 0 when streaming.
+func (gp *GisProxy) SetServerTimeouts(read time.Duration, readHeader time.Duration, write time.Duration, idle time.Duration) error {
+	if gp.server == nil {
+		return errors.New("no server defined")
+	}
+	gp.server.ReadTimeout = read
+	gp.server.ReadHeaderTimeout = readHeader
+	gp.server.WriteTimeout = write
+	gp.server.IdleTimeout = idle
+	return nil
+}
+
 // UseHttps uses Https with certificate
 func (gp *GisProxy) UseHttps(crtfile string, keyfile string) {
 	gp.https = true
@@ -136,124 +372,602 @@ func (gp *GisProxy) UseHttps(crtfile string, keyfile string) {
 }
 
 func (gp *GisProxy) Start() error {
-	log.Println("Start server")
-	log.Println("Listen=", gp.server.Addr)
-	log.Println("Prefix=", gp.Prefix)
-	log.Println("AllowCrossOrigin=", gp.AllowCrossOrigin)
-	log.Println("https=", gp.https)
-	if gp.https {
-		log.Println("crtfile=", gp.crtfile)
-		log.Println("keyfile=", gp.keyfile)
-	}
 	if gp.serverMux == nil || gp.server == nil {
 		return errors.New("no server mux or server defined")
 	}
+	gp.logger.Infof("Start server")
+	gp.logger.Infof("Listen=%v", gp.server.Addr)
+	gp.logger.Infof("Prefix=%v", gp.currentPrefix().raw)
+	gp.logger.Infof("AllowCrossOrigin=%v", gp.AllowCrossOrigin)
+	gp.logger.Infof("https=%v", gp.https)
+	if gp.https {
+		gp.logger.Infof("crtfile=%v", gp.crtfile)
+		gp.logger.Infof("keyfile=%v", gp.keyfile)
+	}
+	if err := gp.startAdmin(); err != nil {
+		return err
+	}
 	gp.serverMux.HandleFunc("/", gp.ServeHTTP)
 	if gp.https {
-		gp.server.ListenAndServeTLS(gp.crtfile, gp.keyfile)
+		return gp.server.ListenAndServeTLS(gp.crtfile, gp.keyfile)
 	}
 	return gp.server.ListenAndServe()
 }
 
 func (gp *GisProxy) Stop(timeout time.Duration) error {
+	if gp.server == nil {
+		return errors.New("no server defined")
+	}
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
+	if gp.drainCallback != nil {
+		// Report remaining requests while draining
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			ticker := time.NewTicker(time.Second)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+					gp.drainCallback(gp.ActiveRequests())
+				}
+			}
+		}()
+	}
+	if gp.adminServer != nil {
+		if err := gp.adminServer.Shutdown(ctx); err != nil {
+			gp.server.Shutdown(ctx)
+			return err
+		}
+	}
 	return gp.server.Shutdown(ctx)
 }
 
-// SetNextHandler sets next handler for middleware use
+// ActiveRequests returns number of requests being served
+func (gp *GisProxy) ActiveRequests() int {
+	return int(atomic.LoadInt32(&gp.activeRequests))
+}
+
+// SetDrainCallback sets function called every second with remaining active requests during Stop
+func (gp *GisProxy) SetDrainCallback(drainCallback func(remaining int)) {
+	gp.drainCallback = drainCallback
+}
+
+// SetTLSVerify enables or disables upstream TLS certificate verification.
+// Verification is disabled by default for compatibility, which exposes upstream
+// connections to man-in-the-middle attacks: enable it in production.
+func (gp *GisProxy) SetTLSVerify(verify bool) {
+	gp.updateTransport(func(transport *http.Transport) {
+		transport.TLSClientConfig.InsecureSkipVerify = !verify
+	})
+}
+
+// SetRootCAs sets certificate authorities used to verify upstream certificates
+// (nil means system pool). Only effective when TLS verification is enabled.
+func (gp *GisProxy) SetRootCAs(pool *x509.CertPool) {
+	gp.updateTransport(func(transport *http.Transport) {
+		transport.TLSClientConfig.RootCAs = pool
+	})
+}
+
+// updateTransport rebuilds client transport with update function
+func (gp *GisProxy) updateTransport(update func(*http.Transport)) {
+	previous := gp.client.Transport.(*http.Transport)
+	transport := previous.Clone()
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{}
+	}
+	update(transport)
+	if gp.h2cTransport != nil {
+		// Alternate protocols are not cloned
+		transport.RegisterProtocol("http", gp.h2cTransport)
+	}
+	gp.client.Transport = transport
+	previous.CloseIdleConnections()
+}
+
+// SetRequestTimeout sets upstream request timeout (0 means no timeout)
+func (gp *GisProxy) SetRequestTimeout(requestTimeout time.Duration) {
+	gp.requestTimeout = requestTimeout
+}
+
+// SetTimeoutHeader sets name of trusted request header ("X-Proxy-Timeout: 120s" for instance)
+// overriding request timeout, empty name disables override
+func (gp *GisProxy) SetTimeoutHeader(name string) {
+	gp.timeoutHeader = name
+}
+
+// SetMaxTimeout sets maximum request timeout allowed by timeout header (0 means no maximum)
+func (gp *GisProxy) SetMaxTimeout(maxTimeout time.Duration) {
+	gp.maxTimeout = maxTimeout
+}
+
+// requestTimeoutFor returns request timeout, overridden by timeout header
+func (gp *GisProxy) requestTimeoutFor(header http.Header) (time.Duration, error) {
+	if gp.timeoutHeader == "" {
+		return gp.requestTimeout, nil
+	}
+	value := header.Get(gp.timeoutHeader)
+	if value == "" {
+		return gp.requestTimeout, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout <= 0 {
+		return 0, NewStatusError("Invalid timeout "+value, 400)
+	}
+	if gp.maxTimeout > 0 && timeout > gp.maxTimeout {
+		timeout = gp.maxTimeout
+	}
+	return timeout, nil
+}
+
+// SetErrorFormat sets error response body format
+func (gp *GisProxy) SetErrorFormat(errorFormat ErrorFormat) {
+	gp.errorFormat = errorFormat
+}
+
+// SetArcGISTokenProvider sets provider called for each ArcGIS request to append token
+// parameter to forward url, after BeforeSend callback so that token is not exposed
+func (gp *GisProxy) SetArcGISTokenProvider(arcGISTokenProvider ArcGISTokenProvider) {
+	gp.arcGISTokenProvider = arcGISTokenProvider
+}
+
+// SetMaxFormParseBytes sets maximum request body size parsed to extract form based GisInfo
+// (default 10 MB, 0 disables), larger bodies are forwarded without extraction
+func (gp *GisProxy) SetMaxFormParseBytes(maxFormParseBytes int64) {
+	gp.maxFormParseBytes = maxFormParseBytes
+}
+
+// SetFormParseTimeout sets maximum duration of request body read to extract form based GisInfo
+// (default 5 seconds, 0 means no timeout), slower bodies are forwarded without extraction
+func (gp *GisProxy) SetFormParseTimeout(formParseTimeout time.Duration) {
+	gp.formParseTimeout = formParseTimeout
+}
+
+// SetNextHandler sets next handler for middleware use, serving requests whose path is not a proxy
+// path. Invalid proxy requests are answered with their error.
 func (gp *GisProxy) SetNextHandler(next http.Handler) {
 	gp.next = next
 }
 
+// handle serves path with handler instead of forwarding
+func (gp *GisProxy) handle(path string, handler http.Handler) {
+	if gp.handlers == nil {
+		gp.handlers = make(map[string]http.Handler)
+	}
+	gp.handlers[path] = handler
+}
+
+// forwardResolver defines function computing forward url of incoming request instead of proxy path
+type forwardResolver func(*http.Request) (*url.URL, *ForwardInfo, error)
+
+// resolvePrefix computes forward url of paths starting with prefix with resolver, requests are then
+// forwarded as proxy path requests
+func (gp *GisProxy) resolvePrefix(prefix string, resolver forwardResolver) {
+	if gp.prefixResolvers == nil {
+		gp.prefixResolvers = make(map[string]forwardResolver)
+	}
+	gp.prefixResolvers[prefix] = resolver
+}
+
+// handlerFor returns handler of path, nil if path is forwarded
+func (gp *GisProxy) handlerFor(path string) http.Handler {
+	if handler, ok := gp.handlers[path]; ok {
+		return handler
+	}
+	if handler, ok := gp.adminHandlers[path]; ok && gp.adminServer == nil && !gp.adminOnlyPaths[path] {
+		return handler
+	}
+	return nil
+}
+
+// resolverFor returns forward resolver of longest prefix of path, computeForward by default
+func (gp *GisProxy) resolverFor(path string) forwardResolver {
+	resolver := gp.computeForward
+	longest := 0
+	for prefix, prefixResolver := range gp.prefixResolvers {
+		if len(prefix) > longest && strings.HasPrefix(path, prefix) {
+			resolver = prefixResolver
+			longest = len(prefix)
+		}
+	}
+	return resolver
+}
+
 // SetBeforeSendFunc sets BeforeSend callback function
 func (gp *GisProxy) SetBeforeSendFunc(beforeSendFunc BeforeSend) {
 	gp.beforeSendFunc = beforeSendFunc
 }
 
+// SetURLRewriteFunc sets URLRewrite callback function, called with a copy of forward url right
+// after request is built. Returned url (nil keeps current one) is forwarded. Returned error aborts
+// request: a *StatusError is written with its code (403 to block request), other errors with 500.
+func (gp *GisProxy) SetURLRewriteFunc(urlRewriteFunc URLRewrite) {
+	gp.urlRewriteFunc = urlRewriteFunc
+}
+
 // SetAfterReceiveFunc sets AfterReceive callback function
 func (gp *GisProxy) SetAfterReceiveFunc(afterReceiveFunc AfterReceive) {
 	gp.afterReceiveFunc = afterReceiveFunc
 }
 
-// ServeHTTP serves rest request
+// SetRequestInterceptor sets RequestInterceptor callback function, called with incoming request
+// before forwarding (GisInfo and ForwardInfo are in context). When it returns true, it has written
+// response (maintenance page, local tile...) and request is not forwarded. Returned error is
+// written as error response, a *RedirectError redirects and a *DirectResponse is written as is.
+func (gp *GisProxy) SetRequestInterceptor(requestInterceptor RequestInterceptor) {
+	gp.requestInterceptor = requestInterceptor
+}
+
+// ServeHTTP serves rest request, GisProxy may be mounted as http.Handler in any router or server
 func (gp *GisProxy) ServeHTTP(writer http.ResponseWriter, incomingRequest *http.Request) {
-	if gp.Prefix == "" {
-		gp.Prefix = "/"
+	atomic.AddInt32(&gp.activeRequests, 1)
+	defer atomic.AddInt32(&gp.activeRequests, -1)
+	if handler := gp.handlerFor(incomingRequest.URL.Path); handler != nil {
+		handler.ServeHTTP(writer, incomingRequest)
+		return
 	}
-	if !strings.HasPrefix(gp.Prefix, "/") {
-		gp.Prefix = "/" + gp.Prefix
+	forwardUrl, forwardInfo, err := gp.resolverFor(incomingRequest.URL.Path)(incomingRequest)
+	var notFound *prefixNotFoundError
+	proxyPath := err == nil || !errors.As(err, &notFound)
+	if !proxyPath && gp.next != nil {
+		// Only requests which are not proxy requests are served by next handler
+		gp.next.ServeHTTP(writer, incomingRequest)
+		return
 	}
-	if !strings.HasSuffix(gp.Prefix, "/") {
-		gp.Prefix = gp.Prefix + "/"
+	if proxyPath && (gp.corsPolicy != nil || gp.AllowCrossOrigin) && isPreflight(incomingRequest) {
+		// Answer CORS preflight of proxy path without forwarding
+		gp.writePreflight(writer, incomingRequest)
+		return
 	}
-	if forwardUrl, err := gp.ComputeForwardUrl(incomingRequest); err != nil {
-		if gp.next != nil {
-			gp.next.ServeHTTP(writer, incomingRequest)
-		} else {
+	// Observed from entry so that rejected requests are logged and counted
+	obs := gp.newObservation(writer, incomingRequest)
+	defer gp.observe(obs)
+	if err != nil {
+		gp.writeError(obs.recorder, incomingRequest, err)
+		return
+	}
+	release, err := gp.checkConnLimit(incomingRequest)
+	if err != nil {
+		gp.writeError(obs.recorder, incomingRequest, err)
+		return
+	}
+	// Deferred so that count is decremented on panic and client disconnect
+	defer release()
+	gp.forward(obs, incomingRequest, forwardUrl, forwardInfo)
+}
+
+// forward forwards incoming request to forward url and writes response to observation recorder
+func (gp *GisProxy) forward(obs *observation, incomingRequest *http.Request, forwardUrl *url.URL, forwardInfo *ForwardInfo) {
+	recorder := obs.recorder
+	var writer http.ResponseWriter = recorder
+	// Set GisProxy to context
+	ctx := context.WithValue(incomingRequest.Context(), contextKey("GisProxy"), gp)
+	// Set ForwardInfo to context
+	ctx = context.WithValue(ctx, contextKey("ForwardInfo"), forwardInfo)
+	// Set TransferStats to context
+	ctx = context.WithValue(ctx, contextKey("TransferStats"), recorder.stats)
+	// Set client host to context
+	ctx = context.WithValue(ctx, contextKey("ClientHost"), incomingRequest.Host)
+	if requestID := gp.requestID(incomingRequest); requestID != "" {
+		// Set request id to context
+		ctx = context.WithValue(ctx, contextKey("RequestID"), requestID)
+	}
+	if fh := gp.computeForwardedHeader(incomingRequest); fh != nil {
+		// Set forwarded header to context
+		ctx = context.WithValue(ctx, contextKey("ForwardedHeader"), fh)
+	}
+	incomingRequest = incomingRequest.WithContext(ctx)
+	obs.request, obs.forwardUrl = incomingRequest, forwardUrl
+	// Checked before GisInfo extraction so that body of rejected request is not read
+	if err := gp.checkMethod(writer, incomingRequest); err != nil {
+		gp.writeError(writer, incomingRequest, err)
+		return
+	}
+	if err := gp.checkRateLimit(writer, incomingRequest); err != nil {
+		gp.writeError(writer, incomingRequest, err)
+		return
+	}
+	if err := gp.limitRequestBody(writer, incomingRequest); err != nil {
+		gp.writeError(writer, incomingRequest, err)
+		return
+	}
+	limited, _ := incomingRequest.Body.(*limitedBody)
+	gisInfo := gp.extractInfo(incomingRequest, forwardUrl)
+	// Set GisInfo to context
+	ctx = context.WithValue(ctx, contextKey("GisInfo"), gisInfo)
+	incomingRequest = incomingRequest.WithContext(ctx)
+	obs.request, obs.gisInfo = incomingRequest, gisInfo
+	if limited != nil && limited.tooLarge() {
+		// Body without Content-Length exceeded limit while parsed
+		gp.writeError(writer, incomingRequest, NewStatusError("Request body too large", http.StatusRequestEntityTooLarge))
+		return
+	}
+	if err := gp.checkService(gisInfo); err != nil {
+		gp.writeError(writer, incomingRequest, err)
+		return
+	}
+	if err := gp.checkWMSLimits(incomingRequest, forwardUrl, gisInfo); err != nil {
+		gp.writeError(writer, incomingRequest, err)
+		return
+	}
+	if err := gp.limitFeatureInfo(incomingRequest, forwardUrl, gisInfo); err != nil {
+		gp.writeError(writer, incomingRequest, err)
+		return
+	}
+	gp.normalizeWMSVersion(forwardUrl, gisInfo)
+	if gp.requestInterceptor != nil {
+		// Call request interceptor
+		handled, err := gp.requestInterceptor(ctx, writer, incomingRequest)
+		if err != nil {
 			gp.writeError(writer, incomingRequest, err)
 			return
 		}
+		if handled {
+			return
+		}
+	}
+	gp.logger.Debugf("Forward %v %v %v", incomingRequest.Method, redactURL(forwardUrl), gisInfo)
+	response, err := gp.SendRequestWithContext(ctx, writer, incomingRequest.Method, forwardUrl, incomingRequest.Body, incomingRequest.Header)
+	if response != nil {
+		if response.Body != nil {
+			defer response.Body.Close()
+		}
 	} else {
-		// Set GisProxy to context
-		ctx := context.WithValue(incomingRequest.Context(), contextKey("GisProxy"), gp)
-		// Set GisInfo to context
-		ctx = context.WithValue(ctx, contextKey("GisInfo"), gp.extractInfo(incomingRequest, forwardUrl))
-		response, err := gp.SendRequestWithContext(ctx, writer, incomingRequest.Method, forwardUrl, incomingRequest.Body, incomingRequest.Header)
-		if response != nil {
-			if response.Body != nil {
-				defer response.Body.Close()
-			}
-		} else {
-			response = &http.Response{
-				Request: incomingRequest,
-			}
+		response = &http.Response{
+			Request: incomingRequest,
 		}
-		if gp.afterReceiveFunc != nil {
-			// Call after receive function
-			if err := gp.afterReceiveFunc(writer, response); err != nil {
-				statusError, valid := err.(*StatusError)
-				if !valid || statusError.Code != 302 {
-					log.Println("After receive error", err, incomingRequest.URL)
-				}
-				gp.writeError(writer, incomingRequest, err)
-				return
+	}
+	if afterReceiveFunc := gp.afterReceiveFor(ctx); afterReceiveFunc != nil {
+		// Call after receive function
+		if err := afterReceiveFunc(writer, response); err != nil {
+			if !isCallbackResponse(err) {
+				gp.logger.Errorf("After receive error %v %v", err, incomingRequest.URL)
 			}
-		}
-		if err != nil {
 			gp.writeError(writer, incomingRequest, err)
 			return
 		}
-		gp.writeResponse(writer, incomingRequest, response)
 	}
+	if err != nil {
+		gp.writeError(writer, incomingRequest, err)
+		return
+	}
+	if response.StatusCode == http.StatusSwitchingProtocols && gp.isUpgrade(incomingRequest.Header) {
+		gp.switchProtocols(writer, incomingRequest, response)
+		return
+	}
+	gp.writeResponse(writer, incomingRequest, response)
 }
 
 // ComputeRewriteUrl computes forward url
 func (gp *GisProxy) ComputeForwardUrl(incomingRequest *http.Request) (*url.URL, error) {
-	incomingRequestURL := incomingRequest.URL.String()
-	idx := strings.Index(incomingRequestURL, "://")
-	if idx != -1 && idx < 10 {
-		incomingRequestURL = incomingRequestURL[idx+3:]
+	forwardUrl, _, err := gp.computeForward(incomingRequest)
+	return forwardUrl, err
+}
+
+// computeForward computes forward url and forward info
+func (gp *GisProxy) computeForward(incomingRequest *http.Request) (*url.URL, *ForwardInfo, error) {
+	forwardUrl, forwardInfo, err := gp.resolveForward(incomingRequest)
+	if err != nil {
+		return nil, nil, err
+	}
+	if gp.pathNormalization {
+		normalizePath(forwardUrl)
 	}
-	re := regexp.MustCompile("(" + gp.Prefix + ")([^/\\?]+)([/\\?]?.*)?")
-	submatch := re.FindStringSubmatch(incomingRequestURL)
+	if gp.webSocketProxy {
+		forwardUrl.Scheme = webSocketScheme(forwardUrl.Scheme)
+	}
+	if err := gp.validateForwardUrl(forwardUrl); err != nil {
+		return nil, nil, err
+	}
+	if err := gp.checkURLSignature(incomingRequest, forwardUrl); err != nil {
+		return nil, nil, err
+	}
+	return forwardUrl, forwardInfo, nil
+}
+
+// validateForwardUrl checks that forward url is an absolute url with allowed scheme
+func (gp *GisProxy) validateForwardUrl(forwardUrl *url.URL) error {
+	if forwardUrl.Scheme == "" || forwardUrl.Host == "" {
+		return NewStatusError("Forward URL must be absolute", 400)
+	}
+	allowedSchemes := gp.allowedSchemes
+	if allowedSchemes == nil {
+		allowedSchemes = defaultSchemes
+	}
+	scheme := strings.ToLower(forwardUrl.Scheme)
+	for _, allowedScheme := range allowedSchemes {
+		if scheme == allowedScheme {
+			return nil
+		}
+	}
+	return NewStatusError("Forward URL scheme "+forwardUrl.Scheme+" not allowed", 400)
+}
+
+// SetAllowedSchemes sets forward url schemes allowed, nil restores default http and https
+func (gp *GisProxy) SetAllowedSchemes(schemes []string) {
+	if schemes == nil {
+		gp.allowedSchemes = nil
+		return
+	}
+	gp.allowedSchemes = make([]string, 0, len(schemes))
+	for _, scheme := range schemes {
+		gp.allowedSchemes = append(gp.allowedSchemes, strings.ToLower(scheme))
+	}
+}
+
+// resolveForward resolves forward url and forward info from incoming request
+func (gp *GisProxy) resolveForward(incomingRequest *http.Request) (*url.URL, *ForwardInfo, error) {
+	// Prefixes are matched against path only, never against query
+	incomingPath := incomingRequest.URL.EscapedPath()
+	query := ""
+	if incomingRequest.URL.RawQuery != "" || incomingRequest.URL.ForceQuery {
+		query = "?" + incomingRequest.URL.RawQuery
+	}
+	for _, r := range gp.routes {
+		if r.options.PlainURL {
+			if strings.HasPrefix(incomingRequest.URL.Path, r.prefix) {
+				return gp.decodePlainURL(incomingRequest, r)
+			}
+			continue
+		}
+		if submatch := r.re.FindStringSubmatch(incomingPath); len(submatch) >= 4 {
+			submatch[3] += query
+			return decodeForwardUrl(submatch, r)
+		}
+	}
+	pm := gp.currentPrefix()
+	prefix := pm.prefix
+	submatch := pm.re.FindStringSubmatch(incomingPath)
 	if len(submatch) >= 4 {
-		// replace '%2B' by '+', '%2F' by '/' and '%3D' by '='
-		b64URL := strings.ReplaceAll(submatch[2], "%2B", "+")
-		b64URL = strings.ReplaceAll(b64URL, "%2F", "/")
-		b64URL = strings.ReplaceAll(b64URL, "%3D", "=")
-		if decURL, err := base64.StdEncoding.DecodeString(b64URL); err != nil {
-			return nil, err
-		} else {
-			if forwardUrl, err := url.Parse(string(decURL) + submatch[3]); err != nil {
-				return nil, err
-			} else {
-				return forwardUrl, nil
+		submatch[3] += query
+		return decodeForwardUrl(submatch, nil)
+	}
+	if gp.urlParamName != "" {
+		// Path segment takes precedence over url parameter
+		for _, r := range gp.routes {
+			if strings.HasPrefix(normalizePrefix(incomingRequest.URL.Path), r.prefix) {
+				return gp.decodeForwardParam(incomingRequest, r.prefix, r)
+			}
+		}
+		if strings.HasPrefix(normalizePrefix(incomingRequest.URL.Path), prefix) {
+			return gp.decodeForwardParam(incomingRequest, prefix, nil)
+		}
+	}
+	return nil, nil, &prefixNotFoundError{prefix: prefix}
+}
+
+// prefixNotFoundError structure, returned when incoming request is not a proxy request
+type prefixNotFoundError struct {
+	prefix string
+}
+
+func (e *prefixNotFoundError) Error() string {
+	return "Prefix " + e.prefix + " not found in request"
+}
+
+// SetURLParamMode also reads base64 forward url from paramName query parameter (?url=<base64> for
+// instance) when path has no base64 segment, other query parameters are forwarded. Empty disables it.
+func (gp *GisProxy) SetURLParamMode(paramName string) {
+	gp.urlParamName = paramName
+}
+
+// decodeForwardParam decodes forward url from url query parameter
+func (gp *GisProxy) decodeForwardParam(incomingRequest *http.Request, prefix string, r *route) (*url.URL, *ForwardInfo, error) {
+	b64URL := ""
+	parts := make([]string, 0)
+	for _, part := range strings.Split(incomingRequest.URL.RawQuery, "&") {
+		if part == "" {
+			continue
+		}
+		if b64URL == "" && queryKey(part) == gp.urlParamName {
+			// Path unescape keeps '+' of standard base64
+			value := ""
+			if keyValue := strings.SplitN(part, "=", 2); len(keyValue) == 2 {
+				value = keyValue[1]
+			}
+			if unescaped, err := url.PathUnescape(value); err == nil {
+				value = unescaped
 			}
+			b64URL = value
+		} else {
+			parts = append(parts, part)
+		}
+	}
+	if b64URL == "" {
+		return nil, nil, errors.New("Parameter " + gp.urlParamName + " not found in request")
+	}
+	decURL, err := decodeBase64(b64URL)
+	if err != nil {
+		return nil, nil, err
+	}
+	forwardUrl, err := url.Parse(string(decURL))
+	if err != nil {
+		return nil, nil, err
+	}
+	remaining := ""
+	if len(parts) > 0 {
+		remaining = "?" + strings.Join(parts, "&")
+		if forwardUrl.RawQuery != "" {
+			forwardUrl.RawQuery += "&"
 		}
+		forwardUrl.RawQuery += strings.Join(parts, "&")
+	}
+	forwardInfo := &ForwardInfo{Prefix: prefix, DecodedURL: string(decURL), Base64Segment: b64URL, RemainingPath: remaining, route: r}
+	return forwardUrl, forwardInfo, nil
+}
+
+// decodeForwardUrl decodes forward url from prefix regexp submatch
+func decodeForwardUrl(submatch []string, r *route) (*url.URL, *ForwardInfo, error) {
+	// replace '%2B' by '+', '%2F' by '/' and '%3D' by '='
+	b64URL := strings.ReplaceAll(submatch[2], "%2B", "+")
+	b64URL = strings.ReplaceAll(b64URL, "%2F", "/")
+	b64URL = strings.ReplaceAll(b64URL, "%3D", "=")
+	if decURL, err := decodeBase64(b64URL); err != nil {
+		return nil, nil, err
 	} else {
-		return nil, errors.New("Prefix " + gp.Prefix + " not found in request")
+		if forwardUrl, err := url.Parse(string(decURL) + submatch[3]); err != nil {
+			return nil, nil, err
+		} else {
+			forwardInfo := &ForwardInfo{Prefix: submatch[1], DecodedURL: string(decURL), Base64Segment: submatch[2], RemainingPath: submatch[3], route: r}
+			return forwardUrl, forwardInfo, nil
+		}
+	}
+}
+
+// SetPathNormalization collapses repeated slashes of forward url path, produced for instance by
+// decoded url ending with '/' followed by remaining path starting with '/'. Query is unchanged.
+func (gp *GisProxy) SetPathNormalization(enabled bool) {
+	gp.pathNormalization = enabled
+}
+
+// normalizePath collapses repeated slashes of url path
+func normalizePath(u *url.URL) {
+	u.Path = collapseSlashes(u.Path)
+	if u.RawPath != "" {
+		u.RawPath = collapseSlashes(u.RawPath)
+	}
+}
+
+// collapseSlashes replaces consecutive slashes by a single slash
+func collapseSlashes(path string) string {
+	for strings.Contains(path, "//") {
+		path = strings.ReplaceAll(path, "//", "/")
+	}
+	return path
+}
+
+// encodeForwardUrl encodes target url through proxy base url (including prefix)
+func encodeForwardUrl(base string, target *url.URL) string {
+	if !strings.HasSuffix(base, "/") {
+		base += "/"
+	}
+	origin := *target
+	origin.RawQuery = ""
+	origin.Fragment = ""
+	encoded := base + base64.URLEncoding.EncodeToString([]byte(origin.String()))
+	if target.RawQuery != "" || target.ForceQuery {
+		encoded += "?" + target.RawQuery
+	}
+	return encoded
+}
+
+// decodeBase64 decodes standard base64 with fallback to URL-safe base64 (padded or not)
+func decodeBase64(b64 string) ([]byte, error) {
+	dec, err := base64.StdEncoding.DecodeString(b64)
+	if err == nil {
+		return dec, nil
+	}
+	if dec, urlErr := base64.URLEncoding.DecodeString(b64); urlErr == nil {
+		return dec, nil
+	}
+	if dec, urlErr := base64.RawURLEncoding.DecodeString(b64); urlErr == nil {
+		return dec, nil
 	}
+	return nil, err
 }
 
 func (gp *GisProxy) extractInfo(request *http.Request, forwardUrl *url.URL) *GisInfo {
@@ -261,6 +975,9 @@ func (gp *GisProxy) extractInfo(request *http.Request, forwardUrl *url.URL) *Gis
 	serverType := "unknown"
 	serviceType := "unknown"
 	serviceName := ""
+	operation := ""
+	outputFormat := ""
+	tileZ, tileX, tileY := 0, 0, 0
 	lowerURL := strings.ToLower(forwardUrl.String())
 	path := forwardUrl.Path
 	if res := reMapServer.FindStringSubmatch(path); res != nil {
@@ -278,97 +995,439 @@ func (gp *GisProxy) extractInfo(request *http.Request, forwardUrl *url.URL) *Gis
 		serverType = "ArcGIS"
 		serviceType = "ImageServer"
 		serviceName = res[1]
+	} else if info := gp.matchWMTSRest(forwardUrl); info != nil {
+		return info
+	} else if res := reTMS.FindStringSubmatchIndex(path); res != nil {
+		serverURL = serverPrefix(forwardUrl, path[:res[2]-1])
+		serverType = "TMS"
+		serviceType = "TMS"
+		serviceName = path[res[2]:res[3]]
+		tileZ, tileX, tileY = parseTile(path[res[4]:res[5]], path[res[6]:res[7]], path[res[8]:res[9]])
+		if tileZ < 31 {
+			// TMS y axis origin is bottom-left
+			tileY = (1 << uint(tileZ)) - 1 - tileY
+		}
+	} else if res := reVectorTile.FindStringSubmatchIndex(path); res != nil {
+		serverURL = serverPrefix(forwardUrl, path[:res[0]])
+		serverType = "MVT"
+		serviceType = "MVT"
+		if res[2] != -1 {
+			serviceName = path[res[2]:res[3]]
+		}
+		tileZ, tileX, tileY = parseTile(path[res[4]:res[5]], path[res[6]:res[7]], path[res[8]:res[9]])
+	} else if res := reXYZ.FindStringSubmatchIndex(path); res != nil {
+		serverURL = serverPrefix(forwardUrl, path[:res[0]])
+		serverType = "XYZ"
+		serviceType = "XYZ"
+		if res[2] != -1 {
+			serviceName = path[res[2]:res[3]]
+		}
+		tileZ, tileX, tileY = parseTile(path[res[4]:res[5]], path[res[6]:res[7]], path[res[8]:res[9]])
 	} else {
 		if request.Method == "PUT" || request.Method == "POST" || request.Method == "PATCH" {
 			if strings.Contains(strings.ToLower(request.Header.Get("Content-Type")), "application/x-www-form-urlencoded") ||
 				strings.Contains(strings.ToLower(request.Header.Get("Content-Type")), "multipart/form-data") {
-				if bodyByte, err := ioutil.ReadAll(request.Body); err == nil {
-					request.Body = ioutil.NopCloser(bytes.NewBuffer(bodyByte))
-					request.ParseMultipartForm(2 << 20)
-					request.Body = ioutil.NopCloser(bytes.NewBuffer(bodyByte))
-				}
+				gp.parseForm(request)
 			}
 		}
 		serverURL = strings.Split(lowerURL, "?")[0]
-		for key, values := range request.Form {
-			lowerKey := strings.ToLower(key)
-			if lowerKey == "service" {
-				if len(values) > 0 {
-					serverType = strings.ToUpper(values[0])
-					serviceType = serverType
-				}
+		form := requestForm(request, forwardUrl)
+		if service := formValue(form, "service"); service != "" {
+			serverType = strings.ToUpper(service)
+			serviceType = serverType
+		}
+		if serverType == "WMS" || serverType == "WFS" || serverType == "WMTS" {
+			operation = formValue(form, "request")
+		}
+		if serverType == "WMS" {
+			if serviceName = formValue(form, "layers"); serviceName == "" {
+				serviceName = formValue(form, "query_layers")
 			}
-			if serverType == "WMS" {
-				if lowerKey == "layers" {
-					serviceName = strings.Join(values, ",")
-				} else if lowerKey == "query_layers" {
-					serviceName = strings.Join(values, ",")
-				}
-			} else if serverType == "WMTS" {
-				if lowerKey == "layer" {
-					serviceName = strings.Join(values, ",")
-				}
-			} else if serverType == "WFS" {
-				if lowerKey == "typenames" {
-					serviceName = strings.Join(values, ",")
-				} else if lowerKey == "typename" {
-					serviceName = strings.Join(values, ",")
-				}
+		} else if serverType == "WMTS" {
+			serviceName = formValue(form, "layer")
+		} else if serverType == "WFS" {
+			if serviceName = formValue(form, "typenames"); serviceName == "" {
+				serviceName = formValue(form, "typename")
 			}
+			outputFormat = formValue(form, "outputformat")
 		}
 	}
-	return &GisInfo{ServerURL: serverURL, ServerType: serverType, ServiceType: serviceType, ServiceName: serviceName}
+	return &GisInfo{ServerURL: serverURL, ServerType: serverType, ServiceType: serviceType, ServiceName: serviceName, Operation: operation, OutputFormat: outputFormat, TileZ: tileZ, TileX: tileX, TileY: tileY}
+}
+
+// requestForm returns forward url query merged with parsed request body form
+func requestForm(request *http.Request, forwardUrl *url.URL) url.Values {
+	form := forwardUrl.Query()
+	for key, values := range request.PostForm {
+		form[key] = append(form[key], values...)
+	}
+	return form
+}
+
+// formValue returns comma separated values of case-insensitive form key
+func formValue(form url.Values, name string) string {
+	for key, values := range form {
+		if strings.EqualFold(key, name) {
+			return strings.Join(values, ",")
+		}
+	}
+	return ""
+}
+
+// formPeek structure
+type formPeek struct {
+	peek []byte
+	err  error
+}
+
+// parseForm parses request body form when not larger than max form parse bytes and read before
+// form parse timeout or client cancellation, request body is replayed so that it remains fully readable
+func (gp *GisProxy) parseForm(request *http.Request) {
+	if gp.maxFormParseBytes <= 0 || request.Body == nil {
+		return
+	}
+	body := request.Body
+	done := make(chan formPeek, 1)
+	go func() {
+		peek, err := ioutil.ReadAll(io.LimitReader(body, gp.maxFormParseBytes+1))
+		done <- formPeek{peek: peek, err: err}
+	}()
+	var timeout <-chan time.Time
+	if gp.formParseTimeout > 0 {
+		timer := time.NewTimer(gp.formParseTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	var result formPeek
+	select {
+	case result = <-done:
+	case <-timeout:
+		gp.logger.Debugf("Form parse timeout %v", request.URL)
+		request.Body = &pendingBody{done: done, ReadCloser: body}
+		return
+	case <-request.Context().Done():
+		request.Body = &pendingBody{done: done, ReadCloser: body}
+		return
+	}
+	peek, err := result.peek, result.err
+	replay := &readCloser{Reader: io.MultiReader(bytes.NewReader(peek), body), Closer: body}
+	if err != nil || int64(len(peek)) > gp.maxFormParseBytes {
+		request.Body = replay
+		return
+	}
+	request.Body = ioutil.NopCloser(bytes.NewReader(peek))
+	request.ParseMultipartForm(2 << 20)
+	if request.MultipartForm != nil {
+		request.MultipartForm.RemoveAll()
+	}
+	request.Body = replay
+}
+
+// pendingBody replays body whose beginning is still being read
+type pendingBody struct {
+	done chan formPeek
+	io.ReadCloser
+	reader io.Reader
+}
+
+// Read waits for pending read then reads body
+func (pb *pendingBody) Read(p []byte) (int, error) {
+	if pb.reader == nil {
+		result := <-pb.done
+		pb.reader = io.MultiReader(bytes.NewReader(result.peek), pb.ReadCloser)
+	}
+	return pb.reader.Read(p)
+}
+
+// readCloser combines reader and closer
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// serverPrefix returns lower case server url with path
+func serverPrefix(forwardUrl *url.URL, path string) string {
+	return strings.ToLower(forwardUrl.Scheme + "://" + forwardUrl.Host + path + "/")
+}
+
+// parseTile parses tile coordinates
+func parseTile(z string, x string, y string) (int, int, int) {
+	tileZ, _ := strconv.Atoi(z)
+	tileX, _ := strconv.Atoi(x)
+	tileY, _ := strconv.Atoi(y)
+	return tileZ, tileX, tileY
 }
 
 // SendRequestWithContext sends request with context
-func (gp *GisProxy) SendRequestWithContext(ctx context.Context, writer http.ResponseWriter, method string, url *url.URL, body io.Reader, header http.Header) (*http.Response, error) {
+func (gp *GisProxy) SendRequestWithContext(ctx context.Context, writer http.ResponseWriter, method string, forwardUrl *url.URL, body io.Reader, header http.Header) (*http.Response, error) {
+	// Apply request timeout, incoming context cancellation still propagates
+	requestTimeout, err := gp.requestTimeoutFor(header)
+	if err != nil {
+		return nil, err
+	}
+	upgrade := gp.isUpgrade(header)
+	cancel := context.CancelFunc(func() {})
+	var handshakeTimer *time.Timer
+	if requestTimeout > 0 && upgrade {
+		// Timeout applies to upgrade handshake, not to upgraded connection
+		ctx, cancel = context.WithCancel(ctx)
+		handshakeTimer = time.AfterFunc(requestTimeout, cancel)
+	} else if requestTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, requestTimeout)
+	}
 	// Create request
 	var request *http.Request
-	var err error
 	if method == "PUT" || method == "POST" || method == "PATCH" {
-		request, err = http.NewRequestWithContext(ctx, method, url.String(), body)
+		request, err = http.NewRequestWithContext(ctx, method, forwardUrl.String(), body)
 	} else {
-		request, err = http.NewRequestWithContext(ctx, method, url.String(), nil)
+		request, err = http.NewRequestWithContext(ctx, method, forwardUrl.String(), nil)
 	}
 	if err != nil {
-		log.Println("New request error")
+		cancel()
+		gp.logger.Errorf("New request error %v", err)
 		return nil, err
 	}
+	if gp.urlRewriteFunc != nil {
+		// Call url rewrite function
+		u := *request.URL
+		rewritten, err := gp.urlRewriteFunc(ctx, GisInfoFromContext(ctx), &u)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		if rewritten != nil {
+			request.URL = rewritten
+			request.Host = rewritten.Host
+		}
+	}
+	gp.stripQueryParams(request)
 	// Add request header
-	for h, vs := range header {
+	for h, vs := range gp.forwardHeaderPolicy.filter(header) {
 		for _, v := range vs {
 			request.Header.Add(h, v)
 		}
 	}
-	if gp.beforeSendFunc != nil {
+	if upgrade {
+		// Upgrade headers are hop-by-hop headers
+		applyUpgrade(request.Header, header.Get("Upgrade"))
+	}
+	if requestID := RequestIDFromContext(ctx); requestID != "" {
+		request.Header.Set(gp.requestIDHeader, requestID)
+	}
+	if gp.timeoutHeader != "" {
+		// Timeout header is not forwarded
+		request.Header.Del(gp.timeoutHeader)
+	}
+	if fh, ok := ctx.Value(contextKey("ForwardedHeader")).(*forwardedHeader); ok {
+		fh.apply(request.Header)
+	}
+	gp.applyUserAgent(request.Header)
+	if beforeSendFunc := gp.beforeSendFor(ctx); beforeSendFunc != nil {
 		// Call before send function
-		err := gp.beforeSendFunc(writer, request)
+		err := beforeSendFunc(writer, request)
 		if err != nil {
-			statusError, valid := err.(*StatusError)
-			if !valid || statusError.Code != 302 {
-				log.Println("Before send error", err, request.URL)
+			if !isCallbackResponse(err) {
+				gp.logger.Errorf("Before send error %v %v", err, redactURL(request.URL))
 			}
+			cancel()
+			return nil, err
+		}
+	}
+	gp.injectDefaultParams(ctx, request)
+	if err := gp.injectArcGISToken(ctx, request); err != nil {
+		cancel()
+		return nil, err
+	}
+	// Injected after before send function so that credentials are never exposed to it
+	gp.injectCredentials(request)
+	// Check forward url before opening any connection
+	if err := gp.checkForwardURL(request.URL, gp.allowedHostsFor(ctx)); err != nil {
+		cancel()
+		return nil, err
+	}
+	// Forward to pool replica of origin host
+	pool, replica := gp.selectReplica(request)
+	gp.applyUpstreamHost(ctx, request)
+	// Hold upstream slot until body is closed, acquired before circuit check so that a rejected
+	// request never leaves a half-open circuit without probe result
+	release, err := gp.acquireUpstream(ctx, request)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	cancelContext := cancel
+	cancel = func() {
+		cancelContext()
+		release()
+	}
+	// Fail fast when upstream host circuit is open
+	breaker := gp.circuitBreaker
+	if breaker != nil {
+		if err := breaker.allow(strings.ToLower(request.URL.Host)); err != nil {
+			cancel()
 			return nil, err
 		}
 	}
 	// Send
-	return gp.client.Do(request)
+	spanCtx, span := gp.startSpan(ctx, header)
+	if span != nil {
+		request = request.WithContext(spanCtx)
+		gp.tracer.Inject(spanCtx, request.Header)
+	}
+	response, err := gp.doRequest(request)
+	if handshakeTimer != nil && !handshakeTimer.Stop() && err != nil {
+		err = context.DeadlineExceeded
+	}
+	if err == nil {
+		response, err = gp.retryArcGISToken(spanCtx, request, response)
+	}
+	endSpan(span, request, response, err)
+	if pool != nil {
+		pool.report(replica, isConnectionError(err))
+	}
+	if breaker != nil {
+		statusCode := 0
+		if response != nil {
+			statusCode = response.StatusCode
+		}
+		breaker.report(strings.ToLower(request.URL.Host), err, statusCode)
+	}
+	if urlError, valid := err.(*url.Error); valid {
+		// Hide injected token from error message
+		urlError.URL = redactURL(request.URL)
+	}
+	if err != nil || response.Body == nil {
+		cancel()
+		return response, err
+	}
+	if conn, ok := response.Body.(io.ReadWriteCloser); ok && response.StatusCode == http.StatusSwitchingProtocols {
+		// Upgraded connection stays writable
+		response.Body = &cancelConn{ReadWriteCloser: conn, cancel: cancel}
+		return response, nil
+	}
+	// Cancel context when body is closed
+	response.Body = &cancelBody{ReadCloser: response.Body, cancel: cancel}
+	return response, nil
+}
+
+// injectArcGISToken appends token from provider to ArcGIS request
+func (gp *GisProxy) injectArcGISToken(ctx context.Context, request *http.Request) error {
+	if gp.arcGISTokenProvider == nil {
+		return nil
+	}
+	info := GisInfoFromContext(ctx)
+	if info == nil || info.ServerType != "ArcGIS" {
+		return nil
+	}
+	token, err := gp.arcGISTokenProvider(ctx, info)
+	if err != nil {
+		gp.logger.Errorf("ArcGIS token error %v %v", err, redactURL(request.URL))
+		return NewStatusError("ArcGIS token error: "+err.Error(), 502)
+	}
+	if token != "" {
+		setQueryParam(request.URL, "token", token)
+	}
+	return nil
+}
+
+// cancelBody cancels context when body is closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+// Close closes body and cancels context
+func (cb *cancelBody) Close() error {
+	err := cb.ReadCloser.Close()
+	cb.cancel()
+	return err
+}
+
+// contextReader stops reading when context is done
+type contextReader struct {
+	ctx context.Context
+	io.Reader
+}
+
+// Read reads unless context is done
+func (cr *contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.Reader.Read(p)
 }
 
 // writeResponse writes response
 func (gp *GisProxy) writeResponse(writer http.ResponseWriter, request *http.Request, response *http.Response) {
+	gp.rewriteLocation(request, response)
 	if response.StatusCode == 302 {
-		location, _ := response.Location()
-		gp.writeError(writer, request, NewStatusError(location.String(), 302))
+		if location, err := response.Location(); err == nil {
+			gp.writeError(writer, request, &RedirectError{Location: location.String()})
+			return
+		}
+	}
+	response.Header = gp.responseHeaderPolicy.filter(response.Header)
+	tagVectorTile(request, response)
+	if err := gp.validateResponse(request, response); err != nil {
+		gp.writeError(writer, request, err)
 		return
 	}
+	if request.Method == "HEAD" || response.StatusCode == http.StatusNotModified || response.StatusCode == http.StatusNoContent {
+		// No body to limit, rewrite or compress, upstream Content-Length is kept
+		gp.writeResponseHeader(writer, request, response.Header)
+		writer.WriteHeader(response.StatusCode)
+		return
+	}
+	if err := gp.limitResponseBody(response); err != nil {
+		gp.logger.Infof("Response body too large %v %v", response.ContentLength, request.URL)
+		gp.writeError(writer, request, err)
+		return
+	}
+	streaming := gp.streamingOnly || isEventStream(response.Header)
+	var body io.Reader = response.Body
+	if rewriters := gp.bodyRewriters(request, response); len(rewriters) > 0 && !streaming {
+		var err error
+		if body, err = gp.rewriteBody(request, response, rewriters); err != nil {
+			gp.logger.Errorf("Rewrite body error %v %v", err, request.URL)
+			gp.writeError(writer, request, err)
+			return
+		}
+	}
+	body = gp.applyContentType(request, response, body)
+	// Stop copy when client disconnects
+	body = &contextReader{ctx: request.Context(), Reader: body}
+	compress := false
+	if gp.shouldCompress(request, response) && !streaming {
+		body, compress = gp.compressBody(response, body)
+	}
+	if !compress {
+		applyContentLength(response)
+	}
 	// Write header
 	gp.writeResponseHeader(writer, request, response.Header)
 	// Set status
 	writer.WriteHeader(response.StatusCode)
 	// Copy body
-	if _, err := io.Copy(writer, response.Body); err != nil {
-		log.Println("Copy response error")
+	var err error
+	if compress {
+		_, err = gp.copyGzip(writer, body)
+	} else if streaming || response.ContentLength < 0 {
+		// Flush streamed and chunked responses as received
+		_, err = gp.copyBuffer(streamingWriter(writer), body)
+	} else {
+		_, err = gp.copyBuffer(writer, body)
+	}
+	if err == errResponseTooLarge {
+		// Header already written, abort connection so that client sees an incomplete response
+		gp.logger.Infof("Response body truncated %v %v", gp.maxResponseBodyBytes, request.URL)
+		panic(http.ErrAbortHandler)
+	}
+	if err != nil && request.Context().Err() != nil {
+		gp.logger.Debugf("Client disconnected %v", request.URL)
+		return
+	}
+	if err != nil {
+		gp.logger.Errorf("Copy response error %v", err)
 		gp.writeError(writer, request, err)
 	}
 }
@@ -376,23 +1435,66 @@ func (gp *GisProxy) writeResponse(writer http.ResponseWriter, request *http.Requ
 // writeResponse writes error
 func (gp *GisProxy) writeError(writer http.ResponseWriter, request *http.Request, err error) {
 	gp.writeResponseHeader(writer, request, nil)
-	statusError, valid := err.(*StatusError)
-	if valid {
+	var statusError *StatusError
+	var redirectError *RedirectError
+	var directResponse *DirectResponse
+	if errors.As(err, &redirectError) {
+		writer.Header().Set("Location", redirectError.Location)
+		writer.WriteHeader(302)
+	} else if errors.As(err, &directResponse) {
+		for h, vs := range directResponse.Header {
+			writer.Header()[h] = vs
+		}
+		writer.WriteHeader(directResponse.status())
+		writer.Write(directResponse.Body)
+	} else if errors.As(err, &statusError) {
 		if statusError.Code == 200 {
 			writer.Write([]byte(statusError.Message))
 		} else if statusError.Code == 302 {
 			writer.Header().Set("Location", statusError.Message)
 			writer.WriteHeader(302)
 		} else {
-			log.Println("Error", http.StatusInternalServerError, err)
-			http.Error(writer, err.Error(), statusError.Code)
+			if statusError.Code >= 500 {
+				gp.logger.Errorf("Error %v %v", statusError.Code, err)
+			} else {
+				gp.logger.Infof("Error %v %v", statusError.Code, err)
+			}
+			gp.writeErrorBody(writer, statusError, statusError.Code)
+		}
+	} else if isTimeout(err) {
+		gp.logger.Errorf("Error %v %v", http.StatusGatewayTimeout, err)
+		gp.writeErrorBody(writer, err, http.StatusGatewayTimeout)
+	} else {
+		gp.logger.Errorf("Error %v %v", http.StatusInternalServerError, err)
+		gp.writeErrorBody(writer, err, http.StatusInternalServerError)
+	}
+}
+
+// writeErrorBody writes error and status code in configured format
+func (gp *GisProxy) writeErrorBody(writer http.ResponseWriter, err error, code int) {
+	if gp.errorFormat == ErrorFormatJSON {
+		message := err.Error()
+		if statusError, valid := err.(*StatusError); valid {
+			message = statusError.Message
 		}
+		writer.Header().Set("Content-Type", "application/json")
+		writer.Header().Set("X-Content-Type-Options", "nosniff")
+		writer.WriteHeader(code)
+		json.NewEncoder(writer).Encode(&errorBody{Error: message, Code: code})
 	} else {
-		log.Println("Error", http.StatusInternalServerError, err)
-		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		http.Error(writer, err.Error(), code)
 	}
 }
 
+// isTimeout checks if error is caused by a timeout
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
 // writeResponseHeader writes response header
 func (gp *GisProxy) writeResponseHeader(writer http.ResponseWriter, request *http.Request, header http.Header) {
 	// Add response header
@@ -401,16 +1503,8 @@ func (gp *GisProxy) writeResponseHeader(writer http.ResponseWriter, request *htt
 			writer.Header().Add(h, v)
 		}
 	}
-	if gp.AllowCrossOrigin {
-		// Allow access origin
-		origin := request.Header.Get("Origin")
-		if origin != "" {
-			writer.Header().Set("Access-Control-Allow-Origin", origin)
-			writer.Header().Set("Access-Control-Allow-Credentials", "true")
-			writer.Header().Set("Access-Control-Allow-Methods", "GET, PUT, POST, HEAD, TRACE, DELETE, PATCH, COPY, HEAD, LINK, OPTIONS")
-		} else {
-			writer.Header().Set("Access-Control-Allow-Origin", "*")
-			writer.Header().Set("Access-Control-Allow-Methods", "GET, PUT, POST, HEAD, TRACE, DELETE, PATCH, COPY, HEAD, LINK, OPTIONS")
-		}
+	if requestID := RequestIDFromContext(request.Context()); requestID != "" {
+		writer.Header().Set(gp.requestIDHeader, requestID)
 	}
+	gp.writeCORSHeader(writer, request)
 }
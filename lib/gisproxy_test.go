@@ -0,0 +1,263 @@
+package lib
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestExtractInfoTiles(t *testing.T) {
+	tests := []struct {
+		name       string
+		forwardURL string
+		want       GisInfo
+	}{
+		{"xyz", "https://tile.example.com/osm/3/4/2.png", GisInfo{ServerURL: "https://tile.example.com/", ServerType: "XYZ", ServiceType: "XYZ", ServiceName: "osm", TileZ: 3, TileX: 4, TileY: 2}},
+		{"xyz without layer", "https://tile.example.com/12/2048/1361.jpg", GisInfo{ServerURL: "https://tile.example.com/", ServerType: "XYZ", ServiceType: "XYZ", TileZ: 12, TileX: 2048, TileY: 1361}},
+		{"xyz upper case extension", "https://tile.example.com/osm/3/4/2.PNG", GisInfo{ServerURL: "https://tile.example.com/", ServerType: "XYZ", ServiceType: "XYZ", ServiceName: "osm", TileZ: 3, TileX: 4, TileY: 2}},
+		{"tms y flipped", "https://tile.example.com/tms/1.0.0/osm/3/4/1.png", GisInfo{ServerURL: "https://tile.example.com/tms/1.0.0/", ServerType: "TMS", ServiceType: "TMS", ServiceName: "osm", TileZ: 3, TileX: 4, TileY: 6}},
+		{"tms without version", "https://tile.example.com/tms/osm/0/0/0.png", GisInfo{ServerURL: "https://tile.example.com/tms/", ServerType: "TMS", ServiceType: "TMS", ServiceName: "osm"}},
+		{"vector tile pbf", "https://tile.example.com/roads/tiles/14/8345/5894.pbf", GisInfo{ServerURL: "https://tile.example.com/", ServerType: "MVT", ServiceType: "MVT", ServiceName: "roads", TileZ: 14, TileX: 8345, TileY: 5894}},
+		{"vector tile mvt", "https://tile.example.com/tiles/2/1/3.MVT", GisInfo{ServerURL: "https://tile.example.com/", ServerType: "MVT", ServiceType: "MVT", TileZ: 2, TileX: 1, TileY: 3}},
+		{"not a tile", "https://tile.example.com/osm/3/4/2.json", GisInfo{ServerURL: "https://tile.example.com/osm/3/4/2.json", ServerType: "unknown", ServiceType: "unknown"}},
+	}
+	gp := NewGisProxyHandler("/gisproxy/", false)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			forwardURL, err := url.Parse(test.forwardURL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			info := gp.extractInfo(httptest.NewRequest("GET", "/gisproxy/", nil), forwardURL)
+			if *info != test.want {
+				t.Errorf("%v, want %v", info, &test.want)
+			}
+		})
+	}
+}
+
+func TestTLSVerify(t *testing.T) {
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Write([]byte("secure"))
+	}))
+	t.Cleanup(upstream.Close)
+	pool := x509.NewCertPool()
+	pool.AddCert(upstream.Certificate())
+	tests := []struct {
+		name    string
+		verify  bool
+		rootCAs *x509.CertPool
+		status  int
+	}{
+		{"verification disabled", false, nil, http.StatusOK},
+		{"unknown authority", true, nil, http.StatusInternalServerError},
+		{"trusted root", true, pool, http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gp := NewGisProxyHandler("/gisproxy/", false)
+			gp.SetTLSVerify(test.verify)
+			gp.SetRootCAs(test.rootCAs)
+			if recorder := serve(gp, httptest.NewRequest("GET", "/gisproxy/"+encodeSegment(upstream.URL+"/wms"), nil)); recorder.Code != test.status {
+				t.Errorf("status %v, want %v", recorder.Code, test.status)
+			}
+		})
+	}
+}
+
+func TestForwardInfoFromContext(t *testing.T) {
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {})
+	segment := encodeSegment(upstream.URL + "/arcgis/rest/services")
+	var info *ForwardInfo
+	gp := NewGisProxyHandler("/gisproxy/", false)
+	gp.SetBeforeSendFunc(func(writer http.ResponseWriter, request *http.Request) error {
+		info = ForwardInfoFromContext(request.Context())
+		return nil
+	})
+	if recorder := serve(gp, httptest.NewRequest("GET", "/gisproxy/"+segment+"/roads/MapServer?f=json", nil)); recorder.Code != http.StatusOK {
+		t.Fatalf("status %v, want %v", recorder.Code, http.StatusOK)
+	}
+	if info == nil {
+		t.Fatal("no ForwardInfo in context")
+	}
+	want := ForwardInfo{Prefix: "/gisproxy/", DecodedURL: upstream.URL + "/arcgis/rest/services", Base64Segment: segment, RemainingPath: "/roads/MapServer?f=json"}
+	if got := (ForwardInfo{Prefix: info.Prefix, DecodedURL: info.DecodedURL, Base64Segment: info.Base64Segment, RemainingPath: info.RemainingPath}); got != want {
+		t.Errorf("ForwardInfo %+v, want %+v", got, want)
+	}
+	if ForwardInfoFromContext(context.Background()) != nil {
+		t.Error("ForwardInfo without proxied request")
+	}
+}
+
+func TestURLRewriteFunc(t *testing.T) {
+	var received string
+	origin := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		received = "origin " + request.URL.RequestURI()
+	})
+	replica := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		received = "replica " + request.URL.RequestURI()
+	})
+	replicaURL, err := url.Parse(replica.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		rewrite  URLRewrite
+		status   int
+		received string
+	}{
+		{"nil url kept", func(ctx context.Context, info *GisInfo, u *url.URL) (*url.URL, error) {
+			return nil, nil
+		}, http.StatusOK, "origin /wms?SERVICE=WMS"},
+		{"query appended", func(ctx context.Context, info *GisInfo, u *url.URL) (*url.URL, error) {
+			u.RawQuery += "&apikey=secret"
+			return u, nil
+		}, http.StatusOK, "origin /wms?SERVICE=WMS&apikey=secret"},
+		{"host swapped", func(ctx context.Context, info *GisInfo, u *url.URL) (*url.URL, error) {
+			u.Host = replicaURL.Host
+			return u, nil
+		}, http.StatusOK, "replica /wms?SERVICE=WMS"},
+		{"blocked", func(ctx context.Context, info *GisInfo, u *url.URL) (*url.URL, error) {
+			return nil, NewStatusError("Blocked", http.StatusForbidden)
+		}, http.StatusForbidden, ""},
+		{"failed", func(ctx context.Context, info *GisInfo, u *url.URL) (*url.URL, error) {
+			return nil, errors.New("rewrite failed")
+		}, http.StatusInternalServerError, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			received = ""
+			gp := NewGisProxyHandler("/gisproxy/", false)
+			gp.SetURLRewriteFunc(func(ctx context.Context, info *GisInfo, u *url.URL) (*url.URL, error) {
+				if info == nil || info.ServiceType != "WMS" {
+					t.Errorf("rewrite GisInfo %v", info)
+				}
+				return test.rewrite(ctx, info, u)
+			})
+			if recorder := serve(gp, httptest.NewRequest("GET", "/gisproxy/"+encodeSegment(origin.URL+"/wms?SERVICE=WMS"), nil)); recorder.Code != test.status {
+				t.Errorf("status %v, want %v", recorder.Code, test.status)
+			}
+			if received != test.received {
+				t.Errorf("upstream received %q, want %q", received, test.received)
+			}
+		})
+	}
+}
+
+func TestServeHTTPMounted(t *testing.T) {
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		writer.Write([]byte("tile " + request.URL.Path))
+	})
+	var handler http.Handler = NewGisProxyHandler("/api/gisproxy/", false)
+	mux := http.NewServeMux()
+	mux.Handle("/api/gisproxy/", http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		// Middleware wrapping proxy
+		writer.Header().Set("X-Middleware", "yes")
+		handler.ServeHTTP(writer, request)
+	}))
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	response, err := http.Get(server.URL + "/api/gisproxy/" + encodeSegment(upstream.URL+"/tiles") + "/1/2/3.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body := readBody(t, response); response.StatusCode != http.StatusOK || body != "tile /tiles/1/2/3.png" {
+		t.Errorf("status %v body %q, want %v %q", response.StatusCode, body, http.StatusOK, "tile /tiles/1/2/3.png")
+	}
+	if response.Header.Get("X-Middleware") != "yes" {
+		t.Error("middleware header missing")
+	}
+}
+
+func TestExtractInfoOperation(t *testing.T) {
+	tests := []struct {
+		name         string
+		method       string
+		forwardURL   string
+		form         string
+		operation    string
+		outputFormat string
+		serviceName  string
+	}{
+		{"wfs get feature", "GET", "https://maps.example.com/wfs?service=WFS&request=GetFeature&typeNames=roads&outputFormat=application/json", "", "GetFeature", "application/json", "roads"},
+		{"wfs upper case parameters", "GET", "https://maps.example.com/wfs?SERVICE=WFS&REQUEST=DescribeFeatureType&TYPENAME=rivers", "", "DescribeFeatureType", "", "rivers"},
+		{"wfs form", "POST", "https://maps.example.com/wfs", "service=WFS&request=GetFeature&typename=roads&outputformat=GML3", "GetFeature", "GML3", "roads"},
+		{"wms get map", "GET", "https://maps.example.com/wms?SERVICE=WMS&REQUEST=GetMap&LAYERS=roads&FORMAT=image/png", "", "GetMap", "", "roads"},
+		{"wms get feature info", "GET", "https://maps.example.com/wms?SERVICE=WMS&REQUEST=GetFeatureInfo&QUERY_LAYERS=roads", "", "GetFeatureInfo", "", "roads"},
+		{"arcgis", "GET", "https://maps.example.com/arcgis/rest/services/roads/MapServer/export?f=image", "", "", "", "roads"},
+	}
+	gp := NewGisProxyHandler("/gisproxy/", false)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			forwardURL, err := url.Parse(test.forwardURL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			request := httptest.NewRequest(test.method, "/gisproxy/", strings.NewReader(test.form))
+			if test.form != "" {
+				request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+			info := gp.extractInfo(request, forwardURL)
+			if info.Operation != test.operation || info.OutputFormat != test.outputFormat || info.ServiceName != test.serviceName {
+				t.Errorf("%v, want Operation=%v OutputFormat=%v ServiceName=%v", info, test.operation, test.outputFormat, test.serviceName)
+			}
+		})
+	}
+}
+
+func TestRequestInterceptor(t *testing.T) {
+	var called bool
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		called = true
+		writer.Write([]byte("upstream"))
+	})
+	tests := []struct {
+		name        string
+		interceptor RequestInterceptor
+		status      int
+		body        string
+		called      bool
+	}{
+		{"not handled", func(ctx context.Context, writer http.ResponseWriter, request *http.Request) (bool, error) {
+			return false, nil
+		}, http.StatusOK, "upstream", true},
+		{"handled", func(ctx context.Context, writer http.ResponseWriter, request *http.Request) (bool, error) {
+			writer.Write([]byte("local tile"))
+			return true, nil
+		}, http.StatusOK, "local tile", false},
+		{"maintenance", func(ctx context.Context, writer http.ResponseWriter, request *http.Request) (bool, error) {
+			return true, NewStatusError("Maintenance", http.StatusServiceUnavailable)
+		}, http.StatusServiceUnavailable, "Maintenance (503)\n", false},
+		{"direct response", func(ctx context.Context, writer http.ResponseWriter, request *http.Request) (bool, error) {
+			return false, &DirectResponse{Status: http.StatusAccepted, Body: []byte("override")}
+		}, http.StatusAccepted, "override", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			called = false
+			gp := NewGisProxyHandler("/gisproxy/", false)
+			gp.SetRequestInterceptor(func(ctx context.Context, writer http.ResponseWriter, request *http.Request) (bool, error) {
+				if info := GisInfoFromContext(ctx); info == nil || info.ServiceType != "WMS" {
+					t.Errorf("interceptor GisInfo %v", info)
+				}
+				return test.interceptor(ctx, writer, request)
+			})
+			recorder := serve(gp, httptest.NewRequest("GET", "/gisproxy/"+encodeSegment(upstream.URL+"/wms?SERVICE=WMS"), nil))
+			if recorder.Code != test.status {
+				t.Errorf("status %v, want %v", recorder.Code, test.status)
+			}
+			if body := recorder.Body.String(); body != test.body {
+				t.Errorf("body %q, want %q", body, test.body)
+			}
+			if called != test.called {
+				t.Errorf("upstream called %v, want %v", called, test.called)
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+package lib
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// hopByHopHeaders lists headers not forwarded by proxies (RFC 7230)
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// headerPolicy structure, empty allow means any header
+type headerPolicy struct {
+	allow map[string]bool
+	deny  map[string]bool
+}
+
+// newHeaderPolicy constructs headerPolicy
+func newHeaderPolicy(allow []string, deny []string) *headerPolicy {
+	return &headerPolicy{allow: headerSet(allow), deny: headerSet(deny)}
+}
+
+// headerSet returns set of canonical header names
+func headerSet(names []string) map[string]bool {
+	set := make(map[string]bool)
+	for _, name := range names {
+		set[http.CanonicalHeaderKey(name)] = true
+	}
+	return set
+}
+
+// filter returns copy of header without hop-by-hop headers and headers rejected by policy
+func (hp *headerPolicy) filter(header http.Header) http.Header {
+	filtered := header.Clone()
+	if filtered == nil {
+		return nil
+	}
+	removeHopByHopHeaders(filtered)
+	if hp == nil {
+		return filtered
+	}
+	for name := range filtered {
+		if hp.deny[name] || (len(hp.allow) > 0 && !hp.allow[name]) {
+			filtered.Del(name)
+		}
+	}
+	return filtered
+}
+
+// removeHopByHopHeaders removes hop-by-hop headers and headers listed in Connection header
+func removeHopByHopHeaders(header http.Header) {
+	for _, value := range header["Connection"] {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				header.Del(name)
+			}
+		}
+	}
+	for _, name := range hopByHopHeaders {
+		header.Del(name)
+	}
+}
+
+// SetForwardHeaderPolicy filters request headers forwarded upstream: when allow is not
+// empty only listed headers are forwarded, and denied headers are never forwarded.
+// Hop-by-hop headers are always removed.
+func (gp *GisProxy) SetForwardHeaderPolicy(allow []string, deny []string) {
+	gp.forwardHeaderPolicy = newHeaderPolicy(allow, deny)
+}
+
+// SetResponseHeaderPolicy filters upstream response headers returned to client: when allow
+// is not empty only listed headers are returned, and denied headers (Set-Cookie for instance)
+// are never returned. Hop-by-hop headers are always removed.
+func (gp *GisProxy) SetResponseHeaderPolicy(allow []string, deny []string) {
+	gp.responseHeaderPolicy = newHeaderPolicy(allow, deny)
+}
+
+// forwardedHeader structure
+type forwardedHeader struct {
+	forwardedFor   string
+	forwardedHost  string
+	forwardedProto string
+}
+
+// SetForwardedHeaders sets X-Forwarded-For, X-Forwarded-Host and X-Forwarded-Proto headers of
+// forwarded requests. Incoming X-Forwarded-* headers are extended only when immediate peer is
+// one of trustedProxies (IP or CIDR), otherwise a new chain is started from peer address.
+// Client IP of rate and connection limits and access log is also taken from X-Forwarded-For only
+// when peer is a trusted proxy.
+func (gp *GisProxy) SetForwardedHeaders(enabled bool, trustedProxies []string) error {
+	networks := make([]*net.IPNet, 0, len(trustedProxies))
+	for _, trustedProxy := range trustedProxies {
+		if !strings.Contains(trustedProxy, "/") {
+			if ip := net.ParseIP(trustedProxy); ip != nil && ip.To4() != nil {
+				trustedProxy += "/32"
+			} else {
+				trustedProxy += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(trustedProxy)
+		if err != nil {
+			return err
+		}
+		networks = append(networks, network)
+	}
+	gp.forwardedHeaders = enabled
+	gp.trustedProxies = networks
+	return nil
+}
+
+// isTrustedProxy checks if address is a trusted proxy
+func (gp *GisProxy) isTrustedProxy(address string) bool {
+	ip := parseHostIP(address)
+	if ip == nil {
+		return false
+	}
+	for _, network := range gp.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// computeForwardedHeader computes X-Forwarded-* headers of incoming request, nil if disabled
+func (gp *GisProxy) computeForwardedHeader(request *http.Request) *forwardedHeader {
+	if !gp.forwardedHeaders {
+		return nil
+	}
+	peer := request.RemoteAddr
+	if host, _, err := net.SplitHostPort(peer); err == nil {
+		peer = host
+	}
+	proto := "http"
+	if request.TLS != nil {
+		proto = "https"
+	}
+	fh := &forwardedHeader{forwardedFor: peer, forwardedHost: request.Host, forwardedProto: proto}
+	if gp.isTrustedProxy(peer) {
+		if forwardedFor := strings.Join(request.Header["X-Forwarded-For"], ", "); forwardedFor != "" {
+			fh.forwardedFor = forwardedFor + ", " + peer
+		}
+		if forwardedHost := request.Header.Get("X-Forwarded-Host"); forwardedHost != "" {
+			fh.forwardedHost = forwardedHost
+		}
+		if forwardedProto := request.Header.Get("X-Forwarded-Proto"); forwardedProto != "" {
+			fh.forwardedProto = forwardedProto
+		}
+	}
+	return fh
+}
+
+// apply sets X-Forwarded-* headers, replacing forwarded client ones
+func (fh *forwardedHeader) apply(header http.Header) {
+	header.Set("X-Forwarded-For", fh.forwardedFor)
+	header.Set("X-Forwarded-Host", fh.forwardedHost)
+	header.Set("X-Forwarded-Proto", fh.forwardedProto)
+}
+
+// SetUpstreamUserAgent sets User-Agent header of forwarded requests, appended to client one
+// ("client-ua gisproxy/1.0") when appendToClient is true. Empty userAgent forwards client one.
+func (gp *GisProxy) SetUpstreamUserAgent(userAgent string, appendToClient bool) {
+	gp.upstreamUserAgent = userAgent
+	gp.appendUserAgent = appendToClient
+}
+
+// applyUserAgent sets upstream User-Agent header
+func (gp *GisProxy) applyUserAgent(header http.Header) {
+	if gp.upstreamUserAgent == "" {
+		return
+	}
+	if clientUserAgent := header.Get("User-Agent"); gp.appendUserAgent && clientUserAgent != "" {
+		header.Set("User-Agent", clientUserAgent+" "+gp.upstreamUserAgent)
+	} else {
+		header.Set("User-Agent", gp.upstreamUserAgent)
+	}
+}
+
+// SetUpstreamHost sets Host header of forwarded requests (virtual-hosted servers), empty uses
+// upstream url host. TLS server name (SNI) and certificate verification still use upstream url
+// host, so that https upstream certificate must be valid for url host, not overridden Host.
+func (gp *GisProxy) SetUpstreamHost(host string) {
+	gp.upstreamHost = host
+}
+
+// PreserveClientHost forwards Host header of incoming request instead of upstream url host,
+// upstream host override (see SetUpstreamHost) takes precedence. As for upstream host, TLS
+// server name (SNI) is upstream url host.
+func (gp *GisProxy) PreserveClientHost(preserve bool) {
+	gp.preserveClientHost = preserve
+}
+
+// applyUpstreamHost overrides Host header of forwarded request
+func (gp *GisProxy) applyUpstreamHost(ctx context.Context, request *http.Request) {
+	if host := gp.upstreamHostFor(ctx); host != "" {
+		request.Host = host
+	} else if clientHost, ok := ctx.Value(contextKey("ClientHost")).(string); ok && gp.preserveClientHost && clientHost != "" {
+		request.Host = clientHost
+	}
+}
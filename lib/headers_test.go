@@ -0,0 +1,219 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestForwardHeaderPolicy(t *testing.T) {
+	var received http.Header
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		received = request.Header.Clone()
+	})
+	incoming := http.Header{
+		"Connection":          {"keep-alive, X-Hop"},
+		"Keep-Alive":          {"timeout=5"},
+		"Proxy-Authenticate":  {"Basic"},
+		"Proxy-Authorization": {"Basic dXNlcjpwYXNz"},
+		"Upgrade":             {"h2c"},
+		"Te":                  {"trailers"},
+		"X-Hop":               {"listed in connection"},
+		"Authorization":       {"Bearer token"},
+		"Cookie":              {"session=1"},
+		"Accept":              {"image/png"},
+	}
+	hopByHop := []string{"Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization", "Upgrade", "Te", "X-Hop"}
+	tests := []struct {
+		name      string
+		allow     []string
+		deny      []string
+		forwarded []string
+		dropped   []string
+	}{
+		{"default", nil, nil, []string{"Authorization", "Cookie", "Accept"}, nil},
+		{"deny", nil, []string{"authorization", "Cookie"}, []string{"Accept"}, []string{"Authorization", "Cookie"}},
+		{"allow", []string{"Accept", "X-Hop", "Connection"}, nil, []string{"Accept"}, []string{"Authorization", "Cookie"}},
+		{"allow and deny", []string{"Accept", "Cookie"}, []string{"Cookie"}, []string{"Accept"}, []string{"Authorization", "Cookie"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gp := NewGisProxyHandler("/gisproxy/", false)
+			if test.allow != nil || test.deny != nil {
+				gp.SetForwardHeaderPolicy(test.allow, test.deny)
+			}
+			request := httptest.NewRequest("GET", "/gisproxy/"+encodeSegment(upstream.URL), nil)
+			for name, values := range incoming {
+				request.Header[name] = values
+			}
+			if recorder := serve(gp, request); recorder.Code != http.StatusOK {
+				t.Fatalf("status %v, want %v", recorder.Code, http.StatusOK)
+			}
+			for _, name := range test.forwarded {
+				if received.Get(name) != incoming.Get(name) {
+					t.Errorf("header %v %q, want %q", name, received.Get(name), incoming.Get(name))
+				}
+			}
+			for _, name := range append(hopByHop, test.dropped...) {
+				// Go transport may add its own Connection header, never client values
+				if value := received.Get(name); value != "" && value == incoming.Get(name) {
+					t.Errorf("header %v forwarded %q", name, value)
+				}
+			}
+		})
+	}
+}
+
+func TestResponseHeaderPolicy(t *testing.T) {
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Connection", "X-Internal")
+		writer.Header().Set("X-Internal", "listed in connection")
+		writer.Header().Set("Set-Cookie", "upstream=1")
+		writer.Header().Set("Content-Type", "image/png")
+	})
+	gp := NewGisProxyHandler("/gisproxy/", false)
+	gp.SetResponseHeaderPolicy(nil, []string{"Set-Cookie"})
+	recorder := serve(gp, httptest.NewRequest("GET", "/gisproxy/"+encodeSegment(upstream.URL), nil))
+	for _, name := range []string{"Connection", "X-Internal", "Set-Cookie"} {
+		if value := recorder.Header().Get(name); value != "" {
+			t.Errorf("header %v returned %q", name, value)
+		}
+	}
+	if contentType := recorder.Header().Get("Content-Type"); contentType != "image/png" {
+		t.Errorf("content type %q, want image/png", contentType)
+	}
+}
+
+func TestForwardedHeaders(t *testing.T) {
+	var received http.Header
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		received = request.Header.Clone()
+	})
+	incoming := http.Header{
+		"X-Forwarded-For":   {"203.0.113.7, 198.51.100.2"},
+		"X-Forwarded-Host":  {"maps.example.com"},
+		"X-Forwarded-Proto": {"https"},
+	}
+	tests := []struct {
+		name       string
+		enabled    bool
+		remoteAddr string
+		incoming   http.Header
+		want       http.Header
+	}{
+		{"disabled", false, "10.0.0.5:41000", incoming, incoming},
+		{"new chain", true, "192.0.2.1:41000", nil, http.Header{
+			"X-Forwarded-For": {"192.0.2.1"}, "X-Forwarded-Host": {"proxy.local"}, "X-Forwarded-Proto": {"http"},
+		}},
+		{"untrusted peer replaces chain", true, "192.0.2.1:41000", incoming, http.Header{
+			"X-Forwarded-For": {"192.0.2.1"}, "X-Forwarded-Host": {"proxy.local"}, "X-Forwarded-Proto": {"http"},
+		}},
+		{"trusted peer extends chain", true, "10.0.0.5:41000", incoming, http.Header{
+			"X-Forwarded-For": {"203.0.113.7, 198.51.100.2, 10.0.0.5"}, "X-Forwarded-Host": {"maps.example.com"}, "X-Forwarded-Proto": {"https"},
+		}},
+		{"trusted ipv6 peer", true, "[2001:db8::7]:41000", incoming, http.Header{
+			"X-Forwarded-For": {"203.0.113.7, 198.51.100.2, 2001:db8::7"}, "X-Forwarded-Host": {"maps.example.com"}, "X-Forwarded-Proto": {"https"},
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gp := NewGisProxyHandler("/gisproxy/", false)
+			if err := gp.SetForwardedHeaders(test.enabled, []string{"10.0.0.0/8", "2001:db8::7"}); err != nil {
+				t.Fatal(err)
+			}
+			request := httptest.NewRequest("GET", "http://proxy.local/gisproxy/"+encodeSegment(upstream.URL+"/wms"), nil)
+			request.RemoteAddr = test.remoteAddr
+			for name, values := range test.incoming {
+				request.Header[name] = values
+			}
+			if recorder := serve(gp, request); recorder.Code != http.StatusOK {
+				t.Fatalf("status %v, want %v", recorder.Code, http.StatusOK)
+			}
+			for name := range test.want {
+				if got, want := received.Get(name), test.want.Get(name); got != want {
+					t.Errorf("%v %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSetForwardedHeadersInvalidProxy(t *testing.T) {
+	gp := NewGisProxyHandler("/gisproxy/", false)
+	if err := gp.SetForwardedHeaders(true, []string{"10.0.0.0/33"}); err == nil {
+		t.Error("invalid trusted proxy accepted")
+	}
+}
+
+func TestUpstreamUserAgent(t *testing.T) {
+	var received string
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		received = request.Header.Get("User-Agent")
+	})
+	tests := []struct {
+		name      string
+		userAgent string
+		append    bool
+		client    string
+		want      string
+	}{
+		{"client forwarded", "", false, "QGIS/3.34", "QGIS/3.34"},
+		{"replaced", "gisproxy/1.0", false, "QGIS/3.34", "gisproxy/1.0"},
+		{"appended", "gisproxy/1.0", true, "QGIS/3.34", "QGIS/3.34 gisproxy/1.0"},
+		{"appended without client", "gisproxy/1.0", true, "", "gisproxy/1.0"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gp := NewGisProxyHandler("/gisproxy/", false)
+			gp.SetUpstreamUserAgent(test.userAgent, test.append)
+			request := httptest.NewRequest("GET", "/gisproxy/"+encodeSegment(upstream.URL+"/wms"), nil)
+			request.Header.Set("User-Agent", test.client)
+			if test.client == "" {
+				request.Header.Del("User-Agent")
+			}
+			serve(gp, request)
+			if received != test.want {
+				t.Errorf("User-Agent %q, want %q", received, test.want)
+			}
+		})
+	}
+}
+
+func TestUpstreamHost(t *testing.T) {
+	var received string
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		received = request.Host
+	})
+	upstreamHost := strings.TrimPrefix(upstream.URL, "http://")
+	tests := []struct {
+		name     string
+		host     string
+		preserve bool
+		prefix   string
+		want     string
+	}{
+		{"upstream url host", "", false, "/gisproxy/", upstreamHost},
+		{"overridden", "maps.example.com", false, "/gisproxy/", "maps.example.com"},
+		{"client preserved", "", true, "/gisproxy/", "proxy.example.com"},
+		{"override before client", "maps.example.com", true, "/gisproxy/", "maps.example.com"},
+		{"route override", "maps.example.com", true, "/virtual/", "tiles.example.com"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			received = ""
+			gp := NewGisProxyHandler("/gisproxy/", false)
+			gp.SetUpstreamHost(test.host)
+			gp.PreserveClientHost(test.preserve)
+			gp.AddRoute("/virtual/", RouteOptions{UpstreamHost: "tiles.example.com"})
+			request := httptest.NewRequest("GET", test.prefix+encodeSegment(upstream.URL+"/wms"), nil)
+			request.Host = "proxy.example.com"
+			if recorder := serve(gp, request); recorder.Code != http.StatusOK {
+				t.Errorf("status %v, want %v", recorder.Code, http.StatusOK)
+			}
+			if received != test.want {
+				t.Errorf("Host %q, want %q", received, test.want)
+			}
+		})
+	}
+}
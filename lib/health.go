@@ -0,0 +1,60 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// Version is gisproxy version reported by health check
+const Version = "1.1.0"
+
+// healthStatus structure
+type healthStatus struct {
+	Status  string `json:"status"`
+	Uptime  string `json:"uptime"`
+	Version string `json:"version"`
+	Error   string `json:"error,omitempty"`
+}
+
+// SetHealthCheckPath serves liveness status on path without forwarding
+func (gp *GisProxy) SetHealthCheckPath(path string) {
+	gp.handleAdmin(path, http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		gp.writeHealth(writer, nil)
+	}))
+}
+
+// SetReadinessCheckPath serves readiness status on path, checking upstreamURL with
+// a HEAD request when not empty
+func (gp *GisProxy) SetReadinessCheckPath(path string, upstreamURL string) {
+	gp.handleAdmin(path, http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if upstreamURL == "" {
+			gp.writeHealth(writer, nil)
+			return
+		}
+		ctx, cancel := context.WithTimeout(request.Context(), 5*time.Second)
+		defer cancel()
+		upstreamRequest, err := http.NewRequestWithContext(ctx, "HEAD", upstreamURL, nil)
+		if err == nil {
+			var response *http.Response
+			if response, err = gp.client.Do(upstreamRequest); err == nil {
+				response.Body.Close()
+			}
+		}
+		gp.writeHealth(writer, err)
+	}))
+}
+
+// writeHealth writes health status
+func (gp *GisProxy) writeHealth(writer http.ResponseWriter, err error) {
+	status := &healthStatus{Status: "ok", Uptime: time.Since(gp.startTime).Round(time.Second).String(), Version: Version}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Header().Set("Cache-Control", "no-store")
+	if err != nil {
+		status.Status = "unavailable"
+		status.Error = err.Error()
+		writer.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(writer).Encode(status)
+}
@@ -0,0 +1,55 @@
+package lib
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHealthChecks(t *testing.T) {
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != "HEAD" {
+			t.Errorf("readiness check method %v, want HEAD", request.Method)
+		}
+	})
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	tests := []struct {
+		name   string
+		path   string
+		status int
+		health string
+	}{
+		{"liveness", "/health", http.StatusOK, "ok"},
+		{"readiness without upstream", "/ready", http.StatusOK, "ok"},
+		{"readiness with upstream", "/ready/upstream", http.StatusOK, "ok"},
+		{"readiness with upstream down", "/ready/down", http.StatusServiceUnavailable, "unavailable"},
+	}
+	gp := NewGisProxyHandler("/gisproxy/", false)
+	gp.SetHealthCheckPath("/health")
+	gp.SetReadinessCheckPath("/ready", "")
+	gp.SetReadinessCheckPath("/ready/upstream", upstream.URL)
+	gp.SetReadinessCheckPath("/ready/down", down.URL)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := serve(gp, httptest.NewRequest("GET", test.path, nil))
+			if recorder.Code != test.status {
+				t.Errorf("status %v, want %v", recorder.Code, test.status)
+			}
+			if cacheControl := recorder.Header().Get("Cache-Control"); cacheControl != "no-store" {
+				t.Errorf("Cache-Control %q, want no-store", cacheControl)
+			}
+			var status healthStatus
+			if err := json.NewDecoder(recorder.Body).Decode(&status); err != nil {
+				t.Fatal(err)
+			}
+			if status.Status != test.health {
+				t.Errorf("health %q, want %q", status.Status, test.health)
+			}
+			if (status.Error != "") != (test.health != "ok") {
+				t.Errorf("health error %q", status.Error)
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package lib
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// encodeSegment returns URL-safe base64 segment of upstream url
+func encodeSegment(rawURL string) string {
+	return base64.URLEncoding.EncodeToString([]byte(rawURL))
+}
+
+// newUpstream starts test upstream server, closed at test end
+func newUpstream(t *testing.T, handler http.HandlerFunc) *httptest.Server {
+	t.Helper()
+	upstream := httptest.NewServer(handler)
+	t.Cleanup(upstream.Close)
+	return upstream
+}
+
+// serve serves request with proxy and returns recorded response
+func serve(gp *GisProxy, request *http.Request) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	gp.ServeHTTP(recorder, request)
+	return recorder
+}
+
+// readBody reads and closes response body
+func readBody(t *testing.T, response *http.Response) string {
+	t.Helper()
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
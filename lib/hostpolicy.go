@@ -0,0 +1,172 @@
+package lib
+
+import (
+	"net"
+	"net/url"
+	"strings"
+	"syscall"
+)
+
+var privateNetworks = mustParseCIDRs(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"::/128",
+	"::1/128",
+	"fc00::/7",
+	"fe80::/10",
+)
+
+// mustParseCIDRs parses CIDR list or panics
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// isPrivateIP checks if ip is loopback, link-local or private
+func isPrivateIP(ip net.IP) bool {
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseHostIP parses IP literal host, with or without brackets and IPv6 zone, nil if host is a name.
+// net.ParseIP is used rather than netip.ParseAddr since module supports Go 1.13, net.IPNet also
+// matches IPv4-mapped IPv6 addresses (::ffff:127.0.0.1) with IPv4 networks.
+func parseHostIP(host string) net.IP {
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	if zone := strings.LastIndex(host, "%"); zone != -1 {
+		host = host[:zone]
+	}
+	return net.ParseIP(host)
+}
+
+// SetAllowedHosts sets hosts allowed as forward target (empty means any host).
+// Wildcard subdomains are supported with "*.example.com". IP literals match any notation of
+// same address, IPv6 with or without brackets ("2001:db8::1", "[2001:db8::1]:8080").
+func (gp *GisProxy) SetAllowedHosts(hosts []string) {
+	gp.allowedHosts = lowerHosts(hosts)
+}
+
+// lowerHosts returns lower case hosts
+func lowerHosts(hosts []string) []string {
+	lower := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		lower = append(lower, strings.ToLower(host))
+	}
+	return lower
+}
+
+// SetBlockPrivateNetworks blocks forward targets in loopback, link-local and private ranges.
+// Resolved addresses are checked before connecting, also with custom dial function (see
+// SetDialContext), so an upstream http proxy in a private network is blocked too.
+func (gp *GisProxy) SetBlockPrivateNetworks(blockPrivateNetworks bool) {
+	gp.blockPrivateNetworks = blockPrivateNetworks
+}
+
+// checkForwardURL checks that forward url host is allowed
+func (gp *GisProxy) checkForwardURL(forwardURL *url.URL, allowedHosts []string) error {
+	hostname := strings.ToLower(forwardURL.Hostname())
+	if len(allowedHosts) > 0 && !matchHost(allowedHosts, hostname, strings.ToLower(forwardURL.Host)) {
+		return NewStatusError("Host "+forwardURL.Host+" not allowed", 403)
+	}
+	if gp.blockPrivateNetworks {
+		if ip := parseHostIP(hostname); ip != nil && isPrivateIP(ip) {
+			return NewStatusError("Host "+forwardURL.Host+" not allowed", 403)
+		}
+	}
+	return nil
+}
+
+// SetAllowedServices sets server or service types allowed (WMS, WMTS, MapServer...), empty means
+// any type. Requests with unknown type are allowed only when allowUnknown is true.
+func (gp *GisProxy) SetAllowedServices(types []string, allowUnknown bool) {
+	gp.allowedServices = make(map[string]bool)
+	for _, t := range types {
+		gp.allowedServices[strings.ToLower(t)] = true
+	}
+	gp.allowUnknownServices = allowUnknown
+}
+
+// checkService checks that detected server or service type is allowed
+func (gp *GisProxy) checkService(info *GisInfo) error {
+	if len(gp.allowedServices) == 0 {
+		return nil
+	}
+	if info.ServerType == "unknown" && info.ServiceType == "unknown" {
+		if gp.allowUnknownServices {
+			return nil
+		}
+		return NewStatusError("Unknown service not allowed", 403)
+	}
+	if gp.allowedServices[strings.ToLower(info.ServerType)] || gp.allowedServices[strings.ToLower(info.ServiceType)] {
+		return nil
+	}
+	return NewStatusError("Service "+info.ServerType+" "+info.ServiceType+" not allowed", 403)
+}
+
+// matchHost checks if hostname (or host with port) matches one of patterns
+func matchHost(patterns []string, hostname string, host string) bool {
+	for _, pattern := range patterns {
+		if pattern == hostname || pattern == host {
+			return true
+		}
+		if strings.HasPrefix(pattern, "*.") && strings.HasSuffix(hostname, pattern[1:]) {
+			return true
+		}
+		if matchHostIP(pattern, hostname, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchHostIP checks if IP literal pattern, with optional port, matches IP literal host
+func matchHostIP(pattern string, hostname string, host string) bool {
+	ip := parseHostIP(hostname)
+	if ip == nil {
+		return false
+	}
+	if patternIP := parseHostIP(pattern); patternIP != nil {
+		return patternIP.Equal(ip)
+	}
+	patternHost, patternPort, err := net.SplitHostPort(pattern)
+	if err != nil {
+		return false
+	}
+	_, port, err := net.SplitHostPort(host)
+	if err != nil || port != patternPort {
+		return false
+	}
+	patternIP := parseHostIP(patternHost)
+	return patternIP != nil && patternIP.Equal(ip)
+}
+
+// dialControl rejects connections to private networks before connecting
+func (gp *GisProxy) dialControl(network string, address string, conn syscall.RawConn) error {
+	if !gp.blockPrivateNetworks {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := parseHostIP(host); ip != nil && isPrivateIP(ip) {
+		return NewStatusError("Address "+address+" not allowed", 403)
+	}
+	return nil
+}
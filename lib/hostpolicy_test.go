@@ -0,0 +1,136 @@
+package lib
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestCheckForwardURL(t *testing.T) {
+	tests := []struct {
+		name         string
+		allowedHosts []string
+		blockPrivate bool
+		forwardURL   string
+		allowed      bool
+	}{
+		{"wildcard subdomain", []string{"*.arcgis.com"}, false, "https://services.arcgis.com/rest", true},
+		{"wildcard nested subdomain", []string{"*.arcgis.com"}, false, "https://a.b.arcgis.com/rest", true},
+		{"wildcard case-insensitive", []string{"*.ArcGIS.com"}, false, "https://Services.ARCGIS.com/rest", true},
+		{"wildcard apex", []string{"*.arcgis.com"}, false, "https://arcgis.com/rest", false},
+		{"wildcard suffix only", []string{"*.arcgis.com"}, false, "https://evilarcgis.com/rest", false},
+		{"exact host", []string{"maps.example.com"}, false, "https://maps.example.com/wms", true},
+		{"exact host other subdomain", []string{"maps.example.com"}, false, "https://tiles.example.com/wms", false},
+		{"metadata blocked", nil, true, "http://169.254.169.254/latest/meta-data", false},
+		{"any host", nil, false, "http://[2001:db8::1]:8080/wms", true},
+		{"ipv6 allowed without brackets", []string{"2001:db8::1"}, false, "http://[2001:db8::1]:8080/wms", true},
+		{"ipv6 allowed with brackets and port", []string{"[2001:db8::1]:8080"}, false, "http://[2001:db8::1]:8080/wms", true},
+		{"ipv6 other notation", []string{"2001:0db8:0000::1"}, false, "http://[2001:db8::1]/wms", true},
+		{"ipv6 other port", []string{"[2001:db8::1]:8080"}, false, "http://[2001:db8::1]:9090/wms", false},
+		{"ipv6 not allowed", []string{"2001:db8::2"}, false, "http://[2001:db8::1]/wms", false},
+		{"mixed allowlist ipv4", []string{"2001:db8::1", "192.0.2.10", "*.example.com"}, false, "http://192.0.2.10/wms", true},
+		{"mixed allowlist ipv6", []string{"192.0.2.10", "2001:db8::1", "*.example.com"}, false, "https://[2001:db8::1]/wms", true},
+		{"mixed allowlist name", []string{"192.0.2.10", "2001:db8::1", "*.example.com"}, false, "https://maps.example.com/wms", true},
+		{"mixed allowlist other ipv4", []string{"192.0.2.10", "2001:db8::1"}, false, "http://192.0.2.11/wms", false},
+		{"ipv6 loopback blocked", nil, true, "http://[::1]:8080/wms", false},
+		{"ipv6 link-local blocked", nil, true, "http://[fe80::1%25eth0]/wms", false},
+		{"ipv6 unique local blocked", nil, true, "http://[fd00::1]/wms", false},
+		{"ipv4-mapped loopback blocked", nil, true, "http://[::ffff:127.0.0.1]/wms", false},
+		{"ipv4 private blocked", nil, true, "http://10.1.2.3/wms", false},
+		{"ipv6 public allowed", nil, true, "http://[2001:db8::1]/wms", true},
+		{"ipv4 public allowed", nil, true, "http://192.0.2.10/wms", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gp := NewGisProxyHandler("/gisproxy/", false)
+			gp.SetBlockPrivateNetworks(test.blockPrivate)
+			forwardURL, err := url.Parse(test.forwardURL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = gp.checkForwardURL(forwardURL, lowerHosts(test.allowedHosts))
+			if allowed := err == nil; allowed != test.allowed {
+				t.Errorf("allowed %v (%v), want %v", allowed, err, test.allowed)
+			}
+		})
+	}
+}
+
+func TestHostPolicyNeverConnects(t *testing.T) {
+	var connections int32
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Write([]byte("internal"))
+	}))
+	upstream.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&connections, 1)
+		}
+	}
+	upstream.Start()
+	t.Cleanup(upstream.Close)
+	port := upstream.URL[strings.LastIndex(upstream.URL, ":"):]
+	tests := []struct {
+		name         string
+		allowedHosts []string
+		blockPrivate bool
+		forwardURL   string
+	}{
+		{"host not allowed", []string{"*.arcgis.com"}, false, upstream.URL + "/wms"},
+		{"private ip literal", nil, true, upstream.URL + "/wms"},
+		{"name resolving to loopback", nil, true, "http://localhost" + port + "/wms"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			atomic.StoreInt32(&connections, 0)
+			gp := NewGisProxyHandler("/gisproxy/", false)
+			gp.SetAllowedHosts(test.allowedHosts)
+			gp.SetBlockPrivateNetworks(test.blockPrivate)
+			recorder := serve(gp, httptest.NewRequest("GET", "/gisproxy/"+encodeSegment(test.forwardURL), nil))
+			if recorder.Code != http.StatusForbidden {
+				t.Errorf("status %v, want %v", recorder.Code, http.StatusForbidden)
+			}
+			if got := atomic.LoadInt32(&connections); got != 0 {
+				t.Errorf("%v upstream connections, want 0", got)
+			}
+		})
+	}
+}
+
+func TestAllowedServices(t *testing.T) {
+	var requests int32
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		atomic.AddInt32(&requests, 1)
+	})
+	tests := []struct {
+		name         string
+		types        []string
+		allowUnknown bool
+		path         string
+		status       int
+	}{
+		{"any service", nil, false, "/arcgis/rest/services/roads/FeatureServer/0/applyEdits", http.StatusOK},
+		{"allowed service type", []string{"WMS", "WMTS"}, false, "/ows?SERVICE=WMS&REQUEST=GetMap", http.StatusOK},
+		{"allowed case-insensitive", []string{"wmts"}, false, "/ows?service=WMTS&request=GetTile", http.StatusOK},
+		{"allowed server type", []string{"ArcGIS"}, false, "/arcgis/rest/services/roads/MapServer/export", http.StatusOK},
+		{"denied service type", []string{"WMS", "WMTS"}, false, "/arcgis/rest/services/roads/FeatureServer/0/applyEdits", http.StatusForbidden},
+		{"unknown denied", []string{"WMS"}, false, "/data.json", http.StatusForbidden},
+		{"unknown allowed", []string{"WMS"}, true, "/data.json", http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			atomic.StoreInt32(&requests, 0)
+			gp := NewGisProxyHandler("/gisproxy/", false)
+			gp.SetAllowedServices(test.types, test.allowUnknown)
+			if recorder := serve(gp, httptest.NewRequest("GET", "/gisproxy/"+encodeSegment(upstream.URL+test.path), nil)); recorder.Code != test.status {
+				t.Errorf("status %v, want %v", recorder.Code, test.status)
+			}
+			if got, want := atomic.LoadInt32(&requests), int32(0); test.status == http.StatusForbidden && got != want {
+				t.Errorf("%v upstream requests, want %v", got, want)
+			}
+		})
+	}
+}
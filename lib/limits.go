@@ -0,0 +1,133 @@
+package lib
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// SetMaxRequestBodyBytes limits incoming request body size, larger requests are answered with
+// 413 Payload Too Large. Zero (default) means no limit.
+func (gp *GisProxy) SetMaxRequestBodyBytes(maxRequestBodyBytes int64) {
+	gp.maxRequestBodyBytes = maxRequestBodyBytes
+}
+
+// limitRequestBody limits incoming request body before it is parsed or forwarded
+func (gp *GisProxy) limitRequestBody(writer http.ResponseWriter, request *http.Request) error {
+	if gp.maxRequestBodyBytes <= 0 || request.Body == nil || request.Body == http.NoBody {
+		return nil
+	}
+	if request.ContentLength > gp.maxRequestBodyBytes {
+		return NewStatusError("Request body too large", http.StatusRequestEntityTooLarge)
+	}
+	request.Body = &limitedBody{ReadCloser: http.MaxBytesReader(writer, request.Body, gp.maxRequestBodyBytes), max: gp.maxRequestBodyBytes}
+	return nil
+}
+
+// limitedBody turns body limit error into a 413 StatusError, also surfaced by upstream request error
+type limitedBody struct {
+	io.ReadCloser
+	max      int64
+	read     int64
+	exceeded int32
+}
+
+// Read reads body
+func (lb *limitedBody) Read(p []byte) (int, error) {
+	n, err := lb.ReadCloser.Read(p)
+	lb.read += int64(n)
+	if err != nil && err != io.EOF && lb.read >= lb.max {
+		atomic.StoreInt32(&lb.exceeded, 1)
+		return n, NewStatusError("Request body too large", http.StatusRequestEntityTooLarge)
+	}
+	return n, err
+}
+
+// tooLarge checks if limit has been exceeded, body may be read by form parse meanwhile
+func (lb *limitedBody) tooLarge() bool {
+	return atomic.LoadInt32(&lb.exceeded) == 1
+}
+
+// errResponseTooLarge is returned when upstream response body exceeds max response body bytes
+var errResponseTooLarge = NewStatusError("Response body too large", http.StatusBadGateway)
+
+// SetMaxResponseBodyBytes limits upstream response body size returned to client, larger responses
+// are answered with 502 when known before writing, truncated and aborted otherwise. Responses with
+// a content type starting with one of exemptContentTypes (image/ for instance) are not limited.
+// Zero (default) means no limit.
+func (gp *GisProxy) SetMaxResponseBodyBytes(maxResponseBodyBytes int64, exemptContentTypes ...string) {
+	gp.maxResponseBodyBytes = maxResponseBodyBytes
+	gp.responseLimitExemptTypes = make([]string, 0, len(exemptContentTypes))
+	for _, contentType := range exemptContentTypes {
+		gp.responseLimitExemptTypes = append(gp.responseLimitExemptTypes, strings.ToLower(contentType))
+	}
+}
+
+// limitResponseBody limits upstream response body before it is rewritten or copied
+func (gp *GisProxy) limitResponseBody(response *http.Response) error {
+	if gp.maxResponseBodyBytes <= 0 || response.Body == nil || hasContentTypePrefix(response, gp.responseLimitExemptTypes) {
+		return nil
+	}
+	if response.ContentLength > gp.maxResponseBodyBytes {
+		return errResponseTooLarge
+	}
+	response.Body = &limitedResponseBody{ReadCloser: response.Body, remaining: gp.maxResponseBodyBytes}
+	return nil
+}
+
+// limitedResponseBody reads at most remaining bytes, then fails if body has more
+type limitedResponseBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+// Read reads body
+func (lrb *limitedResponseBody) Read(p []byte) (int, error) {
+	if lrb.remaining <= 0 {
+		var probe [1]byte
+		n, err := lrb.ReadCloser.Read(probe[:])
+		if n > 0 {
+			return 0, errResponseTooLarge
+		}
+		return 0, err
+	}
+	if int64(len(p)) > lrb.remaining {
+		p = p[:lrb.remaining]
+	}
+	n, err := lrb.ReadCloser.Read(p)
+	lrb.remaining -= int64(n)
+	return n, err
+}
+
+// maxBufferedBodyBytes is maximum size of upstream response buffered for cache or coalescing
+const maxBufferedBodyBytes = 8 << 20
+
+// bufferLimit returns maximum size of buffered upstream response, lowered to max response body bytes
+func (gp *GisProxy) bufferLimit() int64 {
+	if gp.maxResponseBodyBytes > 0 && gp.maxResponseBodyBytes < maxBufferedBodyBytes {
+		return gp.maxResponseBodyBytes
+	}
+	return maxBufferedBodyBytes
+}
+
+// canBuffer checks if upstream response has a known size within buffer limit and is not an event
+// stream, other responses are streamed to client
+func (gp *GisProxy) canBuffer(response *http.Response) bool {
+	if response.ContentLength < 0 || response.ContentLength > gp.bufferLimit() {
+		return false
+	}
+	return !strings.HasPrefix(strings.ToLower(response.Header.Get("Content-Type")), "text/event-stream")
+}
+
+// readBuffered reads and closes upstream response body, failing when it exceeds buffer limit
+func (gp *GisProxy) readBuffered(response *http.Response) ([]byte, error) {
+	defer response.Body.Close()
+	limit := gp.bufferLimit()
+	body, err := ioutil.ReadAll(io.LimitReader(response.Body, limit+1))
+	if err == nil && int64(len(body)) > limit {
+		err = errResponseTooLarge
+	}
+	return body, err
+}
@@ -0,0 +1,122 @@
+package lib
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestMaxResponseBodyBytes(t *testing.T) {
+	body := strings.Repeat("x", 4096)
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Type", request.URL.Query().Get("ct"))
+		if request.URL.Path == "/chunked" {
+			writer.Write([]byte(body[:1024]))
+			writer.(http.Flusher).Flush()
+			writer.Write([]byte(body[1024:]))
+			return
+		}
+		writer.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		writer.Write([]byte(body))
+	})
+	tests := []struct {
+		name    string
+		path    string
+		status  int
+		size    int
+		aborted bool
+	}{
+		{"known size too large", "/features?ct=application/json", http.StatusBadGateway, -1, false},
+		{"unknown size truncated", "/chunked?ct=application/json", http.StatusOK, 2048, true},
+		{"exempt content type", "/tile?ct=image/png", http.StatusOK, len(body), false},
+		{"exempt content type unknown size", "/chunked?ct=image/png", http.StatusOK, len(body), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gp := NewGisProxyHandler("/gisproxy/", false)
+			gp.SetMaxResponseBodyBytes(2048, "image/")
+			recorder := httptest.NewRecorder()
+			aborted := func() (aborted bool) {
+				defer func() {
+					if r := recover(); r != nil {
+						if r != http.ErrAbortHandler {
+							panic(r)
+						}
+						aborted = true
+					}
+				}()
+				gp.ServeHTTP(recorder, httptest.NewRequest("GET", "/gisproxy/"+encodeSegment(upstream.URL)+test.path, nil))
+				return false
+			}()
+			if aborted != test.aborted {
+				t.Errorf("aborted %v, want %v", aborted, test.aborted)
+			}
+			if recorder.Code != test.status {
+				t.Errorf("status %v, want %v", recorder.Code, test.status)
+			}
+			if test.size >= 0 && recorder.Body.Len() != test.size {
+				t.Errorf("%v bytes written, want %v", recorder.Body.Len(), test.size)
+			}
+		})
+	}
+}
+
+// countingReader counts bytes read
+type countingReader struct {
+	io.Reader
+	read int
+}
+
+// Read reads and counts bytes
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.Reader.Read(p)
+	cr.read += n
+	return n, err
+}
+
+func TestMaxRequestBodyBytes(t *testing.T) {
+	var called bool
+	var received string
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		called = true
+		body, _ := ioutil.ReadAll(request.Body)
+		received = string(body)
+	})
+	large := "SERVICE=WMS&REQUEST=GetMap&LAYERS=" + strings.Repeat("x", 4096)
+	tests := []struct {
+		name    string
+		body    io.Reader
+		status  int
+		forward string
+	}{
+		{"known size too large", strings.NewReader(large), http.StatusRequestEntityTooLarge, ""},
+		{"unknown size too large", &countingReader{Reader: strings.NewReader(large)}, http.StatusRequestEntityTooLarge, ""},
+		{"within limit", strings.NewReader("SERVICE=WMS"), http.StatusOK, "SERVICE=WMS"},
+		{"within limit unknown size", &countingReader{Reader: strings.NewReader("SERVICE=WMS")}, http.StatusOK, "SERVICE=WMS"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			called, received = false, ""
+			gp := NewGisProxyHandler("/gisproxy/", false)
+			gp.SetMaxRequestBodyBytes(1024)
+			request := httptest.NewRequest("POST", "/gisproxy/"+encodeSegment(upstream.URL+"/wms"), test.body)
+			request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if recorder := serve(gp, request); recorder.Code != test.status {
+				t.Errorf("status %v, want %v", recorder.Code, test.status)
+			}
+			if forwarded := test.status == http.StatusOK; called != forwarded {
+				t.Errorf("upstream called %v, want %v", called, forwarded)
+			}
+			if received != test.forward {
+				t.Errorf("upstream received %q, want %q", received, test.forward)
+			}
+			if counting, valid := test.body.(*countingReader); valid && counting.read > 1025 {
+				t.Errorf("%v body bytes read, want at most %v", counting.read, 1025)
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package lib
+
+import (
+	"log"
+)
+
+// Logger defines leveled logger
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// StdLogger is a Logger writing to standard logger, debug messages (each forwarded request)
+// are written only when Debug is true
+type StdLogger struct {
+	Debug bool
+}
+
+// Debugf logs debug message
+func (sl *StdLogger) Debugf(format string, args ...interface{}) {
+	if sl.Debug {
+		log.Printf("DEBUG "+format, args...)
+	}
+}
+
+// Infof logs info message
+func (sl *StdLogger) Infof(format string, args ...interface{}) {
+	log.Printf("INFO "+format, args...)
+}
+
+// Errorf logs error message
+func (sl *StdLogger) Errorf(format string, args ...interface{}) {
+	log.Printf("ERROR "+format, args...)
+}
+
+// SetLogger sets logger, nil restores default StdLogger
+func (gp *GisProxy) SetLogger(logger Logger) {
+	if logger == nil {
+		logger = &StdLogger{}
+	}
+	gp.logger = logger
+}
@@ -0,0 +1,73 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testLogger structure, logger recording messages with level
+type testLogger struct {
+	mutex    sync.Mutex
+	messages []string
+}
+
+func (tl *testLogger) log(level string, format string, args ...interface{}) {
+	tl.mutex.Lock()
+	defer tl.mutex.Unlock()
+	tl.messages = append(tl.messages, level+" "+fmt.Sprintf(format, args...))
+}
+
+func (tl *testLogger) Debugf(format string, args ...interface{}) { tl.log("DEBUG", format, args...) }
+func (tl *testLogger) Infof(format string, args ...interface{})  { tl.log("INFO", format, args...) }
+func (tl *testLogger) Errorf(format string, args ...interface{}) { tl.log("ERROR", format, args...) }
+
+// count counts messages of level
+func (tl *testLogger) count(level string) int {
+	tl.mutex.Lock()
+	defer tl.mutex.Unlock()
+	n := 0
+	for _, message := range tl.messages {
+		if strings.HasPrefix(message, level+" ") {
+			n++
+		}
+	}
+	return n
+}
+
+func TestSetLogger(t *testing.T) {
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {})
+	logger := &testLogger{}
+	gp := NewGisProxyHandler("/gisproxy/", false)
+	gp.SetLogger(logger)
+	serve(gp, httptest.NewRequest("GET", "/gisproxy/"+encodeSegment(upstream.URL+"/wms"), nil))
+	if logger.count("DEBUG") == 0 {
+		t.Errorf("no debug message for forwarded request in %v", logger.messages)
+	}
+	serve(gp, httptest.NewRequest("GET", "/gisproxy/"+encodeSegment("http://127.0.0.1:1/wms"), nil))
+	if logger.count("ERROR") == 0 {
+		t.Errorf("no error message for failed request in %v", logger.messages)
+	}
+	gp.SetLogger(nil)
+	if _, ok := gp.logger.(*StdLogger); !ok {
+		t.Errorf("logger %T, want *StdLogger", gp.logger)
+	}
+}
+
+func TestStdLoggerDebug(t *testing.T) {
+	var output bytes.Buffer
+	log.SetOutput(&output)
+	defer log.SetOutput(os.Stderr)
+	(&StdLogger{}).Debugf("hidden %v", 1)
+	(&StdLogger{}).Infof("shown %v", 2)
+	(&StdLogger{Debug: true}).Debugf("shown %v", 3)
+	if got := output.String(); strings.Contains(got, "hidden") || !strings.Contains(got, "INFO shown 2") || !strings.Contains(got, "DEBUG shown 3") {
+		t.Errorf("log output %q", got)
+	}
+}
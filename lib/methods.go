@@ -0,0 +1,48 @@
+package lib
+
+import (
+	"net/http"
+	"strings"
+)
+
+// SetAllowedMethods sets methods of forwarded requests (GET, HEAD...), empty means any method
+// except TRACE. Other requests are rejected with 405 before reading body.
+func (gp *GisProxy) SetAllowedMethods(methods []string) {
+	gp.allowedMethods = make([]string, 0, len(methods))
+	seen := make(map[string]bool)
+	for _, method := range methods {
+		method = strings.ToUpper(method)
+		if !seen[method] {
+			seen[method] = true
+			gp.allowedMethods = append(gp.allowedMethods, method)
+		}
+	}
+}
+
+// methodsHeader returns allowed methods for Allow and Access-Control-Allow-Methods headers,
+// each method listed once
+func (gp *GisProxy) methodsHeader() string {
+	if len(gp.allowedMethods) == 0 {
+		return strings.Join(defaultMethods, ", ")
+	}
+	return strings.Join(gp.allowedMethods, ", ")
+}
+
+// checkMethod checks that request method is allowed, setting Allow header otherwise
+func (gp *GisProxy) checkMethod(writer http.ResponseWriter, request *http.Request) error {
+	if len(gp.allowedMethods) == 0 {
+		if request.Method == "TRACE" {
+			// Cross-site tracing risk
+			writer.Header().Set("Allow", gp.methodsHeader())
+			return NewStatusError("Method TRACE not allowed", http.StatusMethodNotAllowed)
+		}
+		return nil
+	}
+	for _, method := range gp.allowedMethods {
+		if method == request.Method {
+			return nil
+		}
+	}
+	writer.Header().Set("Allow", gp.methodsHeader())
+	return NewStatusError("Method "+request.Method+" not allowed", http.StatusMethodNotAllowed)
+}
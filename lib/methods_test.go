@@ -0,0 +1,111 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAllowMethodsHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		methods []string
+		want    string
+	}{
+		{"default methods", nil, "GET, PUT, POST, HEAD, DELETE, PATCH, COPY, LINK, OPTIONS"},
+		{"duplicated methods", []string{"GET", "head", "HEAD", "get", "POST"}, "GET, HEAD, POST"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gp := NewGisProxyHandler("/gisproxy/", true)
+			if test.methods != nil {
+				gp.SetAllowedMethods(test.methods)
+			}
+			request := httptest.NewRequest("OPTIONS", "/gisproxy/"+encodeSegment("http://example.com/wms"), nil)
+			request.Header.Set("Origin", "http://client.example.com")
+			request.Header.Set("Access-Control-Request-Method", "GET")
+			header := serve(gp, request).Header().Get("Access-Control-Allow-Methods")
+			if header != test.want {
+				t.Errorf("Access-Control-Allow-Methods %q, want %q", header, test.want)
+			}
+			seen := make(map[string]bool)
+			for _, method := range strings.Split(header, ", ") {
+				if seen[method] {
+					t.Errorf("method %v listed twice", method)
+				}
+				seen[method] = true
+			}
+			if seen["TRACE"] {
+				t.Error("TRACE allowed")
+			}
+		})
+	}
+}
+
+func TestCheckMethod(t *testing.T) {
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {})
+	tests := []struct {
+		name    string
+		methods []string
+		method  string
+		status  int
+		allow   string
+	}{
+		{"default get", nil, "GET", http.StatusOK, ""},
+		{"default trace", nil, "TRACE", http.StatusMethodNotAllowed, "GET, PUT, POST, HEAD, DELETE, PATCH, COPY, LINK, OPTIONS"},
+		{"allowed", []string{"get", "HEAD"}, "HEAD", http.StatusOK, ""},
+		{"not allowed", []string{"get", "HEAD"}, "POST", http.StatusMethodNotAllowed, "GET, HEAD"},
+		{"trace explicitly allowed", []string{"GET", "trace"}, "TRACE", http.StatusOK, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gp := NewGisProxyHandler("/gisproxy/", false)
+			if test.methods != nil {
+				gp.SetAllowedMethods(test.methods)
+			}
+			recorder := serve(gp, httptest.NewRequest(test.method, "/gisproxy/"+encodeSegment(upstream.URL), nil))
+			if recorder.Code != test.status {
+				t.Errorf("status %v, want %v", recorder.Code, test.status)
+			}
+			if allow := recorder.Header().Get("Allow"); allow != test.allow {
+				t.Errorf("Allow %q, want %q", allow, test.allow)
+			}
+		})
+	}
+}
+
+// unreadableBody fails test when read
+type unreadableBody struct {
+	t *testing.T
+}
+
+// Read fails test
+func (ub *unreadableBody) Read(p []byte) (int, error) {
+	ub.t.Fatal("body of rejected request read")
+	return 0, nil
+}
+
+func TestCheckMethodBeforeBodyRead(t *testing.T) {
+	var called bool
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		called = true
+	})
+	var logged *AccessLogEntry
+	gp := NewGisProxyHandler("/gisproxy/", false)
+	gp.SetAllowedMethods([]string{"GET"})
+	gp.SetAccessLogger(func(entry AccessLogEntry) {
+		logged = &entry
+	})
+	request := httptest.NewRequest("POST", "/gisproxy/"+encodeSegment(upstream.URL+"/wms"), &unreadableBody{t: t})
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if recorder := serve(gp, request); recorder.Code != http.StatusMethodNotAllowed {
+		t.Errorf("status %v, want %v", recorder.Code, http.StatusMethodNotAllowed)
+	}
+	if called {
+		t.Error("upstream called")
+	}
+	if logged == nil || logged.Method != "POST" || logged.Status != http.StatusMethodNotAllowed {
+		t.Errorf("access log %+v, want POST rejected with %v", logged, http.StatusMethodNotAllowed)
+	}
+}
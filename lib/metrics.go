@@ -0,0 +1,193 @@
+package lib
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// latencyBuckets defines upstream latency histogram buckets in seconds
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// metricLabels structure
+type metricLabels struct {
+	serverType  string
+	serviceType string
+	serviceName string
+}
+
+// String formats labels in Prometheus text format
+func (ml metricLabels) String() string {
+	labels := `server_type="` + escapeLabel(ml.serverType) + `",service_type="` + escapeLabel(ml.serviceType) + `"`
+	if ml.serviceName != "" {
+		labels += `,service_name="` + escapeLabel(ml.serviceName) + `"`
+	}
+	return labels
+}
+
+// histogram structure
+type histogram struct {
+	counts []int64
+	sum    float64
+	count  int64
+}
+
+// metrics collects proxied request metrics
+type metrics struct {
+	mutex           sync.Mutex
+	maxServiceNames int
+	serviceNames    map[string]bool
+	requests        map[metricLabels]int64
+	bytes           map[metricLabels]int64
+	latencies       map[metricLabels]*histogram
+	statuses        map[int]int64
+}
+
+// newMetrics constructs metrics
+func newMetrics() *metrics {
+	return &metrics{
+		serviceNames: make(map[string]bool),
+		requests:     make(map[metricLabels]int64),
+		bytes:        make(map[metricLabels]int64),
+		latencies:    make(map[metricLabels]*histogram),
+		statuses:     make(map[int]int64),
+	}
+}
+
+// observe records a proxied request
+func (m *metrics) observe(info *GisInfo, status int, written int64, latency time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	labels := metricLabels{serverType: info.ServerType, serviceType: info.ServiceType}
+	if m.maxServiceNames > 0 {
+		// Cap service name cardinality
+		labels.serviceName = info.ServiceName
+		if !m.serviceNames[labels.serviceName] {
+			if len(m.serviceNames) < m.maxServiceNames {
+				m.serviceNames[labels.serviceName] = true
+			} else {
+				labels.serviceName = "other"
+			}
+		}
+	}
+	m.requests[labels]++
+	m.bytes[labels] += written
+	m.statuses[status]++
+	latencyLabels := metricLabels{serverType: labels.serverType, serviceType: labels.serviceType}
+	h, ok := m.latencies[latencyLabels]
+	if !ok {
+		h = &histogram{counts: make([]int64, len(latencyBuckets))}
+		m.latencies[latencyLabels] = h
+	}
+	seconds := latency.Seconds()
+	for i, bucket := range latencyBuckets {
+		if seconds <= bucket {
+			h.counts[i]++
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+// writeTo writes metrics in Prometheus text format
+func (m *metrics) writeTo(writer io.Writer) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	fmt.Fprintln(writer, "# HELP gisproxy_requests_total Total number of proxied requests.")
+	fmt.Fprintln(writer, "# TYPE gisproxy_requests_total counter")
+	for _, labels := range sortedLabels(m.requests) {
+		fmt.Fprintf(writer, "gisproxy_requests_total{%v} %v\n", labels, m.requests[labels])
+	}
+	fmt.Fprintln(writer, "# HELP gisproxy_response_bytes_total Total number of response bytes written to clients.")
+	fmt.Fprintln(writer, "# TYPE gisproxy_response_bytes_total counter")
+	for _, labels := range sortedLabels(m.bytes) {
+		fmt.Fprintf(writer, "gisproxy_response_bytes_total{%v} %v\n", labels, m.bytes[labels])
+	}
+	fmt.Fprintln(writer, "# HELP gisproxy_responses_total Total number of responses by status code.")
+	fmt.Fprintln(writer, "# TYPE gisproxy_responses_total counter")
+	statuses := make([]int, 0, len(m.statuses))
+	for status := range m.statuses {
+		statuses = append(statuses, status)
+	}
+	sort.Ints(statuses)
+	for _, status := range statuses {
+		fmt.Fprintf(writer, "gisproxy_responses_total{code=\"%v\"} %v\n", status, m.statuses[status])
+	}
+	fmt.Fprintln(writer, "# HELP gisproxy_upstream_latency_seconds Upstream latency including body copy.")
+	fmt.Fprintln(writer, "# TYPE gisproxy_upstream_latency_seconds histogram")
+	latencyLabels := make([]metricLabels, 0, len(m.latencies))
+	for labels := range m.latencies {
+		latencyLabels = append(latencyLabels, labels)
+	}
+	sortLabels(latencyLabels)
+	for _, labels := range latencyLabels {
+		h := m.latencies[labels]
+		for i, bucket := range latencyBuckets {
+			fmt.Fprintf(writer, "gisproxy_upstream_latency_seconds_bucket{%v,le=\"%v\"} %v\n", labels, strconv.FormatFloat(bucket, 'g', -1, 64), h.counts[i])
+		}
+		fmt.Fprintf(writer, "gisproxy_upstream_latency_seconds_bucket{%v,le=\"+Inf\"} %v\n", labels, h.count)
+		fmt.Fprintf(writer, "gisproxy_upstream_latency_seconds_sum{%v} %v\n", labels, strconv.FormatFloat(h.sum, 'g', -1, 64))
+		fmt.Fprintf(writer, "gisproxy_upstream_latency_seconds_count{%v} %v\n", labels, h.count)
+	}
+}
+
+// sortedLabels returns sorted keys of counter map
+func sortedLabels(counters map[metricLabels]int64) []metricLabels {
+	labels := make([]metricLabels, 0, len(counters))
+	for l := range counters {
+		labels = append(labels, l)
+	}
+	sortLabels(labels)
+	return labels
+}
+
+// sortLabels sorts labels
+func sortLabels(labels []metricLabels) {
+	sort.Slice(labels, func(i, j int) bool {
+		return labels[i].String() < labels[j].String()
+	})
+}
+
+// escapeLabel escapes Prometheus label value
+func escapeLabel(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `"`, `\"`)
+	return strings.ReplaceAll(value, "\n", `\n`)
+}
+
+// EnableMetrics enables metrics collection and serves Prometheus metrics on path
+// (empty path only collects metrics, see MetricsHandler)
+func (gp *GisProxy) EnableMetrics(path string) {
+	if gp.metrics == nil {
+		gp.metrics = newMetrics()
+	}
+	if path != "" {
+		gp.handleAdmin(path, gp.MetricsHandler())
+	}
+}
+
+// SetMetricsServiceNameLabel adds service name label to metrics, with at most
+// maxServiceNames distinct values, others being reported as "other" (0 disables label)
+func (gp *GisProxy) SetMetricsServiceNameLabel(maxServiceNames int) {
+	if gp.metrics == nil {
+		gp.metrics = newMetrics()
+	}
+	gp.metrics.mutex.Lock()
+	defer gp.metrics.mutex.Unlock()
+	gp.metrics.maxServiceNames = maxServiceNames
+}
+
+// MetricsHandler returns Prometheus metrics handler
+func (gp *GisProxy) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		if gp.metrics != nil {
+			gp.metrics.writeTo(writer)
+		}
+	})
+}
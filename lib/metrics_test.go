@@ -0,0 +1,53 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		if request.URL.Query().Get("missing") != "" {
+			http.NotFound(writer, request)
+			return
+		}
+		writer.Write([]byte("tile"))
+	})
+	gp := NewGisProxyHandler("/gisproxy/", false)
+	gp.EnableMetrics("/metrics")
+	gp.SetMetricsServiceNameLabel(1)
+	for _, path := range []string{
+		"/arcgis/rest/services/roads/MapServer/tile/1/2/3",
+		"/arcgis/rest/services/roads/MapServer/tile/1/2/4",
+		"/arcgis/rest/services/rivers/MapServer/tile/1/2/3",
+		"/arcgis/rest/services/roads/MapServer/tile/1/2/3?missing=1",
+	} {
+		serve(gp, httptest.NewRequest("GET", "/gisproxy/"+encodeSegment(upstream.URL+path), nil))
+	}
+	recorder := serve(gp, httptest.NewRequest("GET", "/metrics", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status %v, want %v", recorder.Code, http.StatusOK)
+	}
+	if contentType := recorder.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain") {
+		t.Errorf("Content-Type %q, want text/plain", contentType)
+	}
+	labels := `server_type="ArcGIS",service_type="MapServer"`
+	body := recorder.Body.String()
+	for _, want := range []string{
+		"# TYPE gisproxy_requests_total counter",
+		"gisproxy_requests_total{" + labels + `,service_name="roads"} 3`,
+		"gisproxy_requests_total{" + labels + `,service_name="other"} 1`,
+		"gisproxy_response_bytes_total{" + labels + `,service_name="other"} 4`,
+		`gisproxy_responses_total{code="200"} 3`,
+		`gisproxy_responses_total{code="404"} 1`,
+		"# TYPE gisproxy_upstream_latency_seconds histogram",
+		"gisproxy_upstream_latency_seconds_bucket{" + labels + `,le="+Inf"} 4`,
+		"gisproxy_upstream_latency_seconds_count{" + labels + "} 4",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics missing %q in\n%v", want, body)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// poolRetryDelay is delay before an unhealthy replica is tried again
+const poolRetryDelay = 10 * time.Second
+
+// ReplicaHealth structure
+type ReplicaHealth struct {
+	Host        string
+	Healthy     bool
+	Failures    int
+	LastFailure time.Time
+}
+
+// upstreamPool structure
+type upstreamPool struct {
+	mutex    sync.Mutex
+	replicas []*ReplicaHealth
+	next     int
+}
+
+// pick returns next healthy replica in round-robin order, or next replica when none is healthy
+func (up *upstreamPool) pick() *ReplicaHealth {
+	up.mutex.Lock()
+	defer up.mutex.Unlock()
+	now := time.Now()
+	for i := 0; i < len(up.replicas); i++ {
+		replica := up.replicas[(up.next+i)%len(up.replicas)]
+		if replica.Healthy || now.Sub(replica.LastFailure) >= poolRetryDelay {
+			up.next = (up.next + i + 1) % len(up.replicas)
+			return replica
+		}
+	}
+	replica := up.replicas[up.next]
+	up.next = (up.next + 1) % len(up.replicas)
+	return replica
+}
+
+// report records replica request result
+func (up *upstreamPool) report(replica *ReplicaHealth, failed bool) {
+	up.mutex.Lock()
+	defer up.mutex.Unlock()
+	if failed {
+		replica.Healthy = false
+		replica.Failures++
+		replica.LastFailure = time.Now()
+	} else {
+		replica.Healthy = true
+		replica.Failures = 0
+	}
+}
+
+// health returns copy of replicas health
+func (up *upstreamPool) health() []ReplicaHealth {
+	up.mutex.Lock()
+	defer up.mutex.Unlock()
+	health := make([]ReplicaHealth, 0, len(up.replicas))
+	for _, replica := range up.replicas {
+		health = append(health, *replica)
+	}
+	return health
+}
+
+// SetUpstreamPool forwards requests for originHost to replicas (host or host:port) in round-robin
+// order. Replicas returning connection errors are skipped for a while. Empty replicas removes pool.
+func (gp *GisProxy) SetUpstreamPool(originHost string, replicas []string) {
+	originHost = strings.ToLower(originHost)
+	if len(replicas) == 0 {
+		delete(gp.upstreamPools, originHost)
+		return
+	}
+	pool := &upstreamPool{}
+	for _, replica := range replicas {
+		pool.replicas = append(pool.replicas, &ReplicaHealth{Host: replica, Healthy: true})
+	}
+	if gp.upstreamPools == nil {
+		gp.upstreamPools = make(map[string]*upstreamPool)
+	}
+	gp.upstreamPools[originHost] = pool
+}
+
+// UpstreamPoolHealth returns health of originHost pool replicas, nil if no pool is registered
+func (gp *GisProxy) UpstreamPoolHealth(originHost string) []ReplicaHealth {
+	pool, ok := gp.upstreamPools[strings.ToLower(originHost)]
+	if !ok {
+		return nil
+	}
+	return pool.health()
+}
+
+// selectReplica rewrites request host to pool replica, returns pool and replica or nil
+func (gp *GisProxy) selectReplica(request *http.Request) (*upstreamPool, *ReplicaHealth) {
+	pool, ok := gp.upstreamPools[strings.ToLower(request.URL.Host)]
+	if !ok {
+		return nil, nil
+	}
+	replica := pool.pick()
+	request.URL.Host = replica.Host
+	request.Host = replica.Host
+	return pool, replica
+}
+
+// isConnectionError checks if error is a connection error and not a cancellation
+func isConnectionError(err error) bool {
+	return err != nil && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+}
@@ -0,0 +1,72 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// replicaHost returns host of test server
+func replicaHost(server *httptest.Server) string {
+	return strings.TrimPrefix(server.URL, "http://")
+}
+
+func TestUpstreamPool(t *testing.T) {
+	newReplica := func(name string) *httptest.Server {
+		return newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+			writer.Write([]byte(name))
+		})
+	}
+	a, b := newReplica("a"), newReplica("b")
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+	tests := []struct {
+		name     string
+		replicas []string
+		want     []string
+	}{
+		{"round robin", []string{replicaHost(a), replicaHost(b)}, []string{"a", "b", "a", "b"}},
+		{"unhealthy replica skipped", []string{replicaHost(dead), replicaHost(a), replicaHost(b)}, []string{"", "a", "b", "a", "b"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gp := NewGisProxyHandler("/gisproxy/", false)
+			gp.SetUpstreamPool("Backend.example.com", test.replicas)
+			got := make([]string, 0)
+			for range test.want {
+				recorder := serve(gp, httptest.NewRequest("GET", "/gisproxy/"+encodeSegment("http://backend.example.com/wms"), nil))
+				if recorder.Code != http.StatusOK {
+					got = append(got, "")
+					continue
+				}
+				got = append(got, recorder.Body.String())
+			}
+			if strings.Join(got, ",") != strings.Join(test.want, ",") {
+				t.Errorf("replicas %v, want %v", got, test.want)
+			}
+			health := gp.UpstreamPoolHealth("backend.example.com")
+			if len(health) != len(test.replicas) {
+				t.Fatalf("%v replicas health, want %v", len(health), len(test.replicas))
+			}
+			for i, replica := range health {
+				healthy := test.replicas[i] != replicaHost(dead)
+				if replica.Host != test.replicas[i] || replica.Healthy != healthy || (replica.Failures == 0) != healthy {
+					t.Errorf("replica %+v, want healthy %v", replica, healthy)
+				}
+			}
+		})
+	}
+}
+
+func TestUpstreamPoolRemoved(t *testing.T) {
+	gp := NewGisProxyHandler("/gisproxy/", false)
+	if health := gp.UpstreamPoolHealth("backend.example.com"); health != nil {
+		t.Errorf("health %v without pool", health)
+	}
+	gp.SetUpstreamPool("backend.example.com", []string{"replica1:8080"})
+	gp.SetUpstreamPool("backend.example.com", nil)
+	if health := gp.UpstreamPoolHealth("backend.example.com"); health != nil {
+		t.Errorf("health %v of removed pool", health)
+	}
+}
@@ -0,0 +1,68 @@
+package lib
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// ProtocolOptions structure
+type ProtocolOptions struct {
+	// DisableHTTP2 disables HTTP/2 for https upstreams, keeping HTTP/1.1 keep-alive and idle settings
+	DisableHTTP2 bool
+	// H2C uses HTTP/2 cleartext with prior knowledge for http upstreams
+	H2C bool
+}
+
+// SetUpstreamProtocols configures upstream HTTP protocols and rebuilds transport
+func (gp *GisProxy) SetUpstreamProtocols(options ProtocolOptions) {
+	if options.H2C {
+		gp.h2cTransport = &http2.Transport{
+			AllowHTTP: true,
+			DialTLS: func(network string, addr string, cfg *tls.Config) (net.Conn, error) {
+				return gp.dial(context.Background(), network, addr)
+			},
+		}
+	} else {
+		gp.h2cTransport = nil
+	}
+	gp.updateTransport(func(transport *http.Transport) {
+		transport.ForceAttemptHTTP2 = !options.DisableHTTP2
+		if options.DisableHTTP2 {
+			// Non-nil empty map disables HTTP/2, h2 added to ALPN protocols by a previous transport
+			// would still be negotiated
+			transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+			transport.TLSClientConfig.NextProtos = withoutProto(transport.TLSClientConfig.NextProtos, "h2")
+		} else {
+			transport.TLSNextProto = nil
+		}
+	})
+}
+
+// withoutProto returns ALPN protocols without proto
+func withoutProto(protos []string, proto string) []string {
+	kept := make([]string, 0, len(protos))
+	for _, p := range protos {
+		if p != proto {
+			kept = append(kept, p)
+		}
+	}
+	return kept
+}
+
+// SetTransportLimits sets upstream connection pool limits and rebuilds transport (zero maxConnsPerHost
+// means no limit). Defaults are 100 idle connections, 2 idle connections per host and 90s idle timeout;
+// a tile proxy hammering a single origin benefits from maxIdleConnsPerHost close to maxIdleConns
+// (100, 100, 0, 90s for instance).
+func (gp *GisProxy) SetTransportLimits(maxIdleConns int, maxIdleConnsPerHost int, maxConnsPerHost int, idleTimeout time.Duration) {
+	gp.updateTransport(func(transport *http.Transport) {
+		transport.MaxIdleConns = maxIdleConns
+		transport.MaxIdleConnsPerHost = maxIdleConnsPerHost
+		transport.MaxConnsPerHost = maxConnsPerHost
+		transport.IdleConnTimeout = idleTimeout
+	})
+}
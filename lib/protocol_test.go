@@ -0,0 +1,85 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+func TestUpstreamProtocols(t *testing.T) {
+	proto := http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Write([]byte(request.Proto))
+	})
+	cleartext := httptest.NewServer(h2c.NewHandler(proto, &http2.Server{}))
+	t.Cleanup(cleartext.Close)
+	secure := httptest.NewUnstartedServer(proto)
+	secure.EnableHTTP2 = true
+	secure.StartTLS()
+	t.Cleanup(secure.Close)
+	tests := []struct {
+		name     string
+		options  *ProtocolOptions
+		upstream string
+		want     string
+	}{
+		{"cleartext default", nil, cleartext.URL, "HTTP/1.1"},
+		{"h2c", &ProtocolOptions{H2C: true}, cleartext.URL, "HTTP/2.0"},
+		{"tls default", nil, secure.URL, "HTTP/2.0"},
+		{"tls http2 disabled", &ProtocolOptions{DisableHTTP2: true}, secure.URL, "HTTP/1.1"},
+		{"tls h2c only affects cleartext", &ProtocolOptions{H2C: true, DisableHTTP2: true}, secure.URL, "HTTP/1.1"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gp := NewGisProxyHandler("/gisproxy/", false)
+			if test.options != nil {
+				gp.SetUpstreamProtocols(*test.options)
+			}
+			recorder := serve(gp, httptest.NewRequest("GET", "/gisproxy/"+encodeSegment(test.upstream+"/wms"), nil))
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("status %v, want %v", recorder.Code, http.StatusOK)
+			}
+			if recorder.Body.String() != test.want {
+				t.Errorf("upstream protocol %v, want %v", recorder.Body.String(), test.want)
+			}
+		})
+	}
+}
+
+func TestUpstreamProtocolsKeepTransportSettings(t *testing.T) {
+	gp := NewGisProxyHandler("/gisproxy/", false)
+	before := gp.client.Transport.(*http.Transport)
+	gp.SetUpstreamProtocols(ProtocolOptions{DisableHTTP2: true})
+	after := gp.client.Transport.(*http.Transport)
+	if after.ForceAttemptHTTP2 || after.TLSNextProto == nil {
+		t.Error("HTTP/2 not disabled")
+	}
+	if after.DisableKeepAlives != before.DisableKeepAlives || after.MaxIdleConns != before.MaxIdleConns ||
+		after.IdleConnTimeout != before.IdleConnTimeout || after.TLSHandshakeTimeout != before.TLSHandshakeTimeout {
+		t.Error("keep-alive and idle connection settings changed")
+	}
+}
+
+func TestSetTransportLimits(t *testing.T) {
+	gp := NewGisProxyHandler("/gisproxy/", false)
+	gp.SetUpstreamProtocols(ProtocolOptions{H2C: true})
+	gp.SetTransportLimits(200, 50, 64, 30*time.Second)
+	transport := gp.client.Transport.(*http.Transport)
+	if transport.MaxIdleConns != 200 || transport.MaxIdleConnsPerHost != 50 || transport.MaxConnsPerHost != 64 || transport.IdleConnTimeout != 30*time.Second {
+		t.Errorf("limits %v %v %v %v, want 200 50 64 30s", transport.MaxIdleConns, transport.MaxIdleConnsPerHost, transport.MaxConnsPerHost, transport.IdleConnTimeout)
+	}
+	if transport.TLSHandshakeTimeout == 0 {
+		t.Error("transport settings lost")
+	}
+	// h2c protocol is kept when transport is rebuilt
+	upstream := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Write([]byte(request.Proto))
+	}), &http2.Server{}))
+	t.Cleanup(upstream.Close)
+	if recorder := serve(gp, httptest.NewRequest("GET", "/gisproxy/"+encodeSegment(upstream.URL+"/wms"), nil)); recorder.Body.String() != "HTTP/2.0" {
+		t.Errorf("status %v upstream protocol %q, want HTTP/2.0", recorder.Code, recorder.Body.String())
+	}
+}
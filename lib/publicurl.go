@@ -0,0 +1,67 @@
+package lib
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// SetPublicBaseURL sets public proxy url including prefix (https://maps.example.com/proxy/ for
+// instance) used by capabilities, redirect location and TileJSON rewrites, empty computes it
+// from incoming request
+func (gp *GisProxy) SetPublicBaseURL(publicBaseURL string) {
+	gp.publicBaseURL = publicBaseURL
+}
+
+// SetTrustForwardedHeaders computes public proxy url from X-Forwarded-Proto and X-Forwarded-Host
+// headers set by a load balancer. When trusted proxies are set (see SetForwardedHeaders), headers
+// are trusted only from them.
+func (gp *GisProxy) SetTrustForwardedHeaders(trust bool) {
+	gp.trustForwardedHeaders = trust
+}
+
+// firstHeaderValue returns first value of comma separated header
+func firstHeaderValue(request *http.Request, name string) string {
+	return strings.TrimSpace(strings.Split(request.Header.Get(name), ",")[0])
+}
+
+// publicSchemeHost returns public scheme and host of incoming request
+func (gp *GisProxy) publicSchemeHost(request *http.Request) (string, string) {
+	scheme := "http"
+	if request.TLS != nil {
+		scheme = "https"
+	}
+	host := request.Host
+	if !gp.trustForwardedHeaders {
+		return scheme, host
+	}
+	if len(gp.trustedProxies) > 0 {
+		peer := request.RemoteAddr
+		if h, _, err := net.SplitHostPort(peer); err == nil {
+			peer = h
+		}
+		if !gp.isTrustedProxy(peer) {
+			return scheme, host
+		}
+	}
+	if proto := strings.ToLower(firstHeaderValue(request, "X-Forwarded-Proto")); proto == "http" || proto == "https" {
+		scheme = proto
+	}
+	if forwardedHost := firstHeaderValue(request, "X-Forwarded-Host"); forwardedHost != "" {
+		host = forwardedHost
+	}
+	return scheme, host
+}
+
+// proxyBaseURL returns public proxy url including matched prefix
+func (gp *GisProxy) proxyBaseURL(request *http.Request) string {
+	if gp.publicBaseURL != "" {
+		return gp.publicBaseURL
+	}
+	scheme, host := gp.publicSchemeHost(request)
+	prefix := gp.currentPrefix().prefix
+	if r := routeFromContext(request.Context()); r != nil {
+		prefix = r.prefix
+	}
+	return scheme + "://" + host + prefix
+}
@@ -0,0 +1,108 @@
+package lib
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestProxyBaseURL(t *testing.T) {
+	tests := []struct {
+		name           string
+		trust          bool
+		trustedProxies []string
+		publicBaseURL  string
+		remoteAddr     string
+		tls            bool
+		headers        map[string]string
+		base           string
+	}{
+		{"without forwarded headers", false, nil, "", "203.0.113.7:1234", false, nil, "http://proxy.local/gisproxy/"},
+		{"tls without forwarded headers", false, nil, "", "203.0.113.7:1234", true, nil, "https://proxy.local/gisproxy/"},
+		{"forwarded headers not trusted", false, nil, "", "203.0.113.7:1234", false, map[string]string{
+			"X-Forwarded-Proto": "https", "X-Forwarded-Host": "maps.example.com",
+		}, "http://proxy.local/gisproxy/"},
+		{"forwarded headers trusted", true, nil, "", "203.0.113.7:1234", false, map[string]string{
+			"X-Forwarded-Proto": "https", "X-Forwarded-Host": "maps.example.com",
+		}, "https://maps.example.com/gisproxy/"},
+		{"first forwarded values", true, nil, "", "203.0.113.7:1234", false, map[string]string{
+			"X-Forwarded-Proto": "https, http", "X-Forwarded-Host": "maps.example.com, lb.local",
+		}, "https://maps.example.com/gisproxy/"},
+		{"invalid forwarded proto", true, nil, "", "203.0.113.7:1234", false, map[string]string{
+			"X-Forwarded-Proto": "javascript",
+		}, "http://proxy.local/gisproxy/"},
+		{"forwarded host only", true, nil, "", "203.0.113.7:1234", true, map[string]string{
+			"X-Forwarded-Host": "maps.example.com",
+		}, "https://maps.example.com/gisproxy/"},
+		{"from trusted proxy", true, []string{"10.0.0.1"}, "", "10.0.0.1:1234", false, map[string]string{
+			"X-Forwarded-Proto": "https", "X-Forwarded-Host": "maps.example.com",
+		}, "https://maps.example.com/gisproxy/"},
+		{"from untrusted peer", true, []string{"10.0.0.1"}, "", "203.0.113.7:1234", false, map[string]string{
+			"X-Forwarded-Proto": "https", "X-Forwarded-Host": "maps.example.com",
+		}, "http://proxy.local/gisproxy/"},
+		{"public base url override", true, nil, "https://public.example.com/proxy/", "203.0.113.7:1234", false, map[string]string{
+			"X-Forwarded-Proto": "http", "X-Forwarded-Host": "maps.example.com",
+		}, "https://public.example.com/proxy/"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gp := NewGisProxyHandler("/gisproxy/", false)
+			gp.SetTrustForwardedHeaders(test.trust)
+			if err := gp.SetForwardedHeaders(false, test.trustedProxies); err != nil {
+				t.Fatal(err)
+			}
+			gp.SetPublicBaseURL(test.publicBaseURL)
+			request := httptest.NewRequest("GET", "http://proxy.local/gisproxy/", nil)
+			request.RemoteAddr = test.remoteAddr
+			if test.tls {
+				request.TLS = &tls.ConnectionState{}
+			}
+			for name, value := range test.headers {
+				request.Header.Set(name, value)
+			}
+			if base := gp.proxyBaseURL(request); base != test.base {
+				t.Errorf("base url %q, want %q", base, test.base)
+			}
+		})
+	}
+}
+
+func TestRedirectLocationPublicURL(t *testing.T) {
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		http.Redirect(writer, request, "/next", http.StatusFound)
+	})
+	next, err := url.Parse(upstream.URL + "/next")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		forwarded bool
+		base      string
+	}{
+		{"without forwarded headers", false, "http://proxy.local/gisproxy/"},
+		{"with forwarded headers", true, "https://maps.example.com/gisproxy/"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gp := NewGisProxyHandler("/gisproxy/", false)
+			gp.SetTrustForwardedHeaders(true)
+			gp.SetRewriteRedirectLocation("")
+			request := httptest.NewRequest("GET", "http://proxy.local/gisproxy/"+encodeSegment(upstream.URL+"/start"), nil)
+			if test.forwarded {
+				request.Header.Set("X-Forwarded-Proto", "https")
+				request.Header.Set("X-Forwarded-Host", "maps.example.com")
+			}
+			recorder := serve(gp, request)
+			if recorder.Code != http.StatusFound {
+				t.Fatalf("status %v, want %v", recorder.Code, http.StatusFound)
+			}
+			want := encodeForwardUrl(test.base, next)
+			if location := recorder.Header().Get("Location"); location != want {
+				t.Errorf("Location %q, want %q", location, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package lib
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// CacheInvalidator is implemented by caches supporting invalidation (MemoryCache and DiskCache)
+type CacheInvalidator interface {
+	// Invalidate removes entries whose key matches, returns number of removed entries
+	Invalidate(match func(key string) bool) int
+}
+
+// CacheFilter structure, selects cache entries by upstream url, host or layer (empty fields match
+// any entry, so that empty filter flushes cache)
+type CacheFilter struct {
+	// Pattern is an upstream url prefix, or a glob when it contains '*' (http://server/wmts/*/3/*)
+	Pattern string
+	// Host is an upstream host, with port if not default
+	Host string
+	// Layer is a service name (ArcGIS service, WMTS, XYZ or TMS layer) or a WMS layer
+	Layer string
+}
+
+// InvalidateCache removes cached responses whose upstream url matches pattern (prefix, or glob
+// when pattern contains '*'), returns number of removed entries. Empty pattern flushes cache.
+func (gp *GisProxy) InvalidateCache(pattern string) (int, error) {
+	return gp.InvalidateCacheFilter(CacheFilter{Pattern: pattern})
+}
+
+// InvalidateCacheFilter removes cached responses selected by filter, returns number of removed entries.
+// It is safe while serving, responses fetched concurrently may be cached again.
+func (gp *GisProxy) InvalidateCacheFilter(filter CacheFilter) (int, error) {
+	if gp.cache == nil {
+		return 0, errors.New("no cache")
+	}
+	invalidator, ok := gp.cache.(CacheInvalidator)
+	if !ok {
+		return 0, errors.New("cache does not support invalidation")
+	}
+	var glob *regexp.Regexp
+	if strings.Contains(filter.Pattern, "*") {
+		glob = compileGlob(filter.Pattern)
+	}
+	return invalidator.Invalidate(func(key string) bool {
+		rawURL := cacheKeyURL(key)
+		if glob != nil && !glob.MatchString(rawURL) {
+			return false
+		}
+		if glob == nil && !strings.HasPrefix(rawURL, filter.Pattern) {
+			return false
+		}
+		if filter.Host == "" && filter.Layer == "" {
+			return true
+		}
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return false
+		}
+		if filter.Host != "" && !strings.EqualFold(u.Host, filter.Host) {
+			return false
+		}
+		return filter.Layer == "" || gp.matchLayer(u, filter.Layer)
+	}), nil
+}
+
+// cacheKeyURL returns upstream url of cache key, without method and variant headers
+func cacheKeyURL(key string) string {
+	key = strings.SplitN(key, "\n", 2)[0]
+	if idx := strings.Index(key, " "); idx != -1 {
+		return key[idx+1:]
+	}
+	return key
+}
+
+// compileGlob compiles glob whose '*' matches any characters
+func compileGlob(pattern string) *regexp.Regexp {
+	parts := strings.Split(pattern, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	return regexp.MustCompile("^" + strings.Join(parts, ".*") + "$")
+}
+
+// matchLayer checks if service name or WMS layers of upstream url match layer
+func (gp *GisProxy) matchLayer(u *url.URL, layer string) bool {
+	request := &http.Request{Method: "GET", URL: u, Header: make(http.Header)}
+	if info := gp.extractInfo(request, u); strings.EqualFold(info.ServiceName, layer) {
+		return true
+	}
+	// Cache key query parameter names are lower case
+	for _, layers := range u.Query()["layers"] {
+		for _, name := range strings.Split(layers, ",") {
+			if strings.EqualFold(name, layer) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// cachePurgeResult structure
+type cachePurgeResult struct {
+	Invalidated int `json:"invalidated"`
+}
+
+// EnableCacheInvalidationEndpoint serves cache invalidation on path without forwarding (disabled by
+// default), on admin listener only (see AddAdminListener). POST or DELETE requests select entries
+// with pattern, host and layer query parameters (see CacheFilter).
+func (gp *GisProxy) EnableCacheInvalidationEndpoint(path string) {
+	gp.handleAdminOnly(path, http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != "POST" && request.Method != "DELETE" {
+			writer.Header().Set("Allow", "POST, DELETE")
+			http.Error(writer, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		query := request.URL.Query()
+		invalidated, err := gp.InvalidateCacheFilter(CacheFilter{Pattern: query.Get("pattern"), Host: query.Get("host"), Layer: query.Get("layer")})
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusNotImplemented)
+			return
+		}
+		gp.logger.Infof("Cache invalidated %v %v", invalidated, request.URL.RawQuery)
+		writer.Header().Set("Content-Type", "application/json")
+		writer.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(writer).Encode(&cachePurgeResult{Invalidated: invalidated})
+	}))
+}
@@ -0,0 +1,129 @@
+package lib
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// queryKey returns unescaped key of raw query part
+func queryKey(part string) string {
+	key := strings.SplitN(part, "=", 2)[0]
+	if unescaped, err := url.QueryUnescape(key); err == nil {
+		return unescaped
+	}
+	return key
+}
+
+// setQueryParam sets query parameter, replacing existing values and keeping other parameters order
+func setQueryParam(u *url.URL, name string, value string) {
+	parts := make([]string, 0)
+	for _, part := range strings.Split(u.RawQuery, "&") {
+		if part != "" && queryKey(part) != name {
+			parts = append(parts, part)
+		}
+	}
+	parts = append(parts, url.QueryEscape(name)+"="+url.QueryEscape(value))
+	u.RawQuery = strings.Join(parts, "&")
+}
+
+// removeQueryParam removes query parameter, keeping other parameters order
+func removeQueryParam(u *url.URL, name string) {
+	parts := make([]string, 0)
+	for _, part := range strings.Split(u.RawQuery, "&") {
+		if part != "" && queryKey(part) != name {
+			parts = append(parts, part)
+		}
+	}
+	u.RawQuery = strings.Join(parts, "&")
+}
+
+// redactURL returns url string with sensitive query parameter values hidden
+func redactURL(u *url.URL) string {
+	if u.RawQuery == "" {
+		return u.String()
+	}
+	redacted := *u
+	parts := strings.Split(u.RawQuery, "&")
+	for i, part := range parts {
+		if strings.EqualFold(queryKey(part), "token") {
+			parts[i] = strings.SplitN(part, "=", 2)[0] + "=xxx"
+		}
+	}
+	redacted.RawQuery = strings.Join(parts, "&")
+	return redacted.String()
+}
+
+// SetStripParams sets query parameters (case-insensitive) removed from forwarded requests, all
+// their occurrences are removed so that upstream never sees them ("_" cache-busters or tracking
+// parameters for instance). Unlike cache ignored parameters, they are not forwarded at all.
+func (gp *GisProxy) SetStripParams(params []string) {
+	gp.stripParams = make(map[string]bool)
+	for _, param := range params {
+		gp.stripParams[strings.ToLower(param)] = true
+	}
+}
+
+// stripQueryParams removes strip parameters from request query, keeping other parameters order
+func (gp *GisProxy) stripQueryParams(request *http.Request) {
+	if len(gp.stripParams) == 0 || request.URL.RawQuery == "" {
+		return
+	}
+	parts := make([]string, 0)
+	for _, part := range strings.Split(request.URL.RawQuery, "&") {
+		if part != "" && !gp.stripParams[strings.ToLower(queryKey(part))] {
+			parts = append(parts, part)
+		}
+	}
+	request.URL.RawQuery = strings.Join(parts, "&")
+}
+
+// SetDefaultParams sets query parameters added to requests of server type (WMS, ArcGIS...) when
+// client did not set them (parameter names are case-insensitive). Nil params removes defaults.
+func (gp *GisProxy) SetDefaultParams(serverType string, params url.Values) {
+	serverType = strings.ToLower(serverType)
+	if params == nil {
+		delete(gp.defaultParams, serverType)
+		return
+	}
+	if gp.defaultParams == nil {
+		gp.defaultParams = make(map[string]url.Values)
+	}
+	gp.defaultParams[serverType] = params
+}
+
+// injectDefaultParams adds default parameters missing from request query
+func (gp *GisProxy) injectDefaultParams(ctx context.Context, request *http.Request) {
+	info := GisInfoFromContext(ctx)
+	if info == nil {
+		return
+	}
+	params, ok := gp.defaultParams[strings.ToLower(info.ServerType)]
+	if !ok {
+		return
+	}
+	present := make(map[string]bool)
+	parts := make([]string, 0)
+	for _, part := range strings.Split(request.URL.RawQuery, "&") {
+		if part != "" {
+			present[strings.ToLower(queryKey(part))] = true
+			parts = append(parts, part)
+		}
+	}
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if present[strings.ToLower(name)] {
+			continue
+		}
+		for _, value := range params[name] {
+			parts = append(parts, url.QueryEscape(name)+"="+url.QueryEscape(value))
+		}
+	}
+	request.URL.RawQuery = strings.Join(parts, "&")
+}
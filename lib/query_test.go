@@ -0,0 +1,76 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestDefaultParams(t *testing.T) {
+	var query string
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		query = request.URL.RawQuery
+	})
+	gp := NewGisProxyHandler("/gisproxy/", false)
+	gp.SetDefaultParams("WMS", url.Values{"EXCEPTIONS": {"application/json"}, "TRANSPARENT": {"true"}})
+	gp.SetDefaultParams("arcgis", url.Values{"f": {"json"}})
+	tests := []struct {
+		name   string
+		target string
+		want   string
+	}{
+		{"wms defaults added", "/wms?SERVICE=WMS&REQUEST=GetMap", "SERVICE=WMS&REQUEST=GetMap&EXCEPTIONS=application%2Fjson&TRANSPARENT=true"},
+		{"client value kept", "/wms?SERVICE=WMS&exceptions=XML", "SERVICE=WMS&exceptions=XML&TRANSPARENT=true"},
+		{"arcgis defaults added", "/arcgis/rest/services/roads/MapServer", "f=json"},
+		{"arcgis client format kept", "/arcgis/rest/services/roads/MapServer?F=pjson", "F=pjson"},
+		{"other server type", "/tiles/1/2/3.png", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			query = ""
+			if recorder := serve(gp, httptest.NewRequest("GET", "/gisproxy/"+encodeSegment(upstream.URL+test.target), nil)); recorder.Code != http.StatusOK {
+				t.Fatalf("status %v, want %v", recorder.Code, http.StatusOK)
+			}
+			if query != test.want {
+				t.Errorf("forwarded query %q, want %q", query, test.want)
+			}
+		})
+	}
+	gp.SetDefaultParams("WMS", nil)
+	serve(gp, httptest.NewRequest("GET", "/gisproxy/"+encodeSegment(upstream.URL+"/wms?SERVICE=WMS"), nil))
+	if query != "SERVICE=WMS" {
+		t.Errorf("forwarded query %q after defaults removal, want %q", query, "SERVICE=WMS")
+	}
+}
+
+func TestStripParams(t *testing.T) {
+	var query string
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		query = request.URL.RawQuery
+	})
+	gp := NewGisProxyHandler("/gisproxy/", false)
+	gp.SetStripParams([]string{"_", "UTM_SOURCE"})
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{"stripped", "SERVICE=WMS&_=1712345&LAYERS=roads", "SERVICE=WMS&LAYERS=roads"},
+		{"case-insensitive and repeated", "utm_source=mail&SERVICE=WMS&Utm_Source=web", "SERVICE=WMS"},
+		{"valueless parameter", "_&SERVICE=WMS", "SERVICE=WMS"},
+		{"order and encoding kept", "b=2&a=%2F&_=1", "b=2&a=%2F"},
+		{"all stripped", "_=1", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			query = ""
+			if recorder := serve(gp, httptest.NewRequest("GET", "/gisproxy/"+encodeSegment(upstream.URL+"/wms")+"?"+test.query, nil)); recorder.Code != http.StatusOK {
+				t.Fatalf("status %v, want %v", recorder.Code, http.StatusOK)
+			}
+			if query != test.want {
+				t.Errorf("forwarded query %q, want %q", query, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,26 @@
+package lib
+
+import (
+	"net/http"
+)
+
+// rangeExposedHeaders lists headers of partial responses readable by cross-origin clients
+const rangeExposedHeaders = "Content-Range, Accept-Ranges, Content-Length"
+
+// isPartialResponse checks if response is a byte range of upstream content (206 Partial Content
+// or Content-Range header), whose body must be returned unchanged: Content-Range refers to
+// upstream bytes, so that body is never rewritten or compressed
+func isPartialResponse(response *http.Response) bool {
+	return response.StatusCode == http.StatusPartialContent || response.Header.Get("Content-Range") != ""
+}
+
+// exposeRangeHeaders exposes range headers to cross-origin clients (COG readers for instance),
+// upstream Access-Control-Expose-Headers being replaced by proxy CORS policy
+func exposeRangeHeaders(writer http.ResponseWriter) {
+	if writer.Header().Get("Access-Control-Allow-Origin") == "" {
+		return
+	}
+	if writer.Header().Get("Content-Range") != "" || writer.Header().Get("Accept-Ranges") != "" {
+		writer.Header().Set("Access-Control-Expose-Headers", rangeExposedHeaders)
+	}
+}
@@ -0,0 +1,79 @@
+package lib
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRangeRequest(t *testing.T) {
+	body := strings.Repeat("GeoTIFF tile data ", 200)
+	var forwardedRange string
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		forwardedRange = request.Header.Get("Range")
+		writer.Header().Set("Content-Type", "text/plain")
+		http.ServeContent(writer, request, "tile.txt", time.Time{}, strings.NewReader(body))
+	})
+	tests := []struct {
+		name         string
+		rangeHeader  string
+		status       int
+		contentRange string
+		body         string
+	}{
+		{"range", "bytes=0-9", http.StatusPartialContent, "bytes 0-9/" + strconv.Itoa(len(body)), body[:10]},
+		{"suffix range", "bytes=-8", http.StatusPartialContent, "bytes " + strconv.Itoa(len(body)-8) + "-" + strconv.Itoa(len(body)-1) + "/" + strconv.Itoa(len(body)), body[len(body)-8:]},
+		{"unsatisfiable range", "bytes=100000-", http.StatusRequestedRangeNotSatisfiable, "bytes */" + strconv.Itoa(len(body)), ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var rewritten bool
+			gp := NewGisProxyHandler("/gisproxy/", true)
+			gp.SetCompression(true, 0)
+			gp.SetContentTypeDetection(true)
+			gp.SetBodyRewriteFunc(func(ctx context.Context, info *GisInfo, contentType string, body []byte) ([]byte, error) {
+				rewritten = true
+				return body, nil
+			}, nil)
+			request := httptest.NewRequest("GET", "/gisproxy/"+encodeSegment(upstream.URL+"/tile.txt"), nil)
+			request.Header.Set("Range", test.rangeHeader)
+			request.Header.Set("Accept-Encoding", "gzip")
+			request.Header.Set("Origin", "https://app.example.com")
+			recorder := serve(gp, request)
+			if forwardedRange != test.rangeHeader {
+				t.Errorf("forwarded Range %q, want %q", forwardedRange, test.rangeHeader)
+			}
+			if recorder.Code != test.status {
+				t.Fatalf("status %v, want %v", recorder.Code, test.status)
+			}
+			if contentRange := recorder.Header().Get("Content-Range"); contentRange != test.contentRange {
+				t.Errorf("Content-Range %q, want %q", contentRange, test.contentRange)
+			}
+			if encoding := recorder.Header().Get("Content-Encoding"); encoding != "" {
+				t.Errorf("Content-Encoding %q, want none", encoding)
+			}
+			if rewritten {
+				t.Error("body rewrite called for range response")
+			}
+			if test.status != http.StatusPartialContent {
+				return
+			}
+			if acceptRanges := recorder.Header().Get("Accept-Ranges"); acceptRanges != "bytes" {
+				t.Errorf("Accept-Ranges %q, want %q", acceptRanges, "bytes")
+			}
+			if length := recorder.Header().Get("Content-Length"); length != strconv.Itoa(len(test.body)) {
+				t.Errorf("Content-Length %q, want %v", length, len(test.body))
+			}
+			if recorder.Body.String() != test.body {
+				t.Errorf("body %q, want %q", recorder.Body.String(), test.body)
+			}
+			if origin := recorder.Header().Get("Access-Control-Allow-Origin"); origin == "" {
+				t.Error("missing Access-Control-Allow-Origin header")
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package lib
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimitKeyFunc defines function returning rate limit key of request
+type RateLimitKeyFunc func(*http.Request) string
+
+// tokenBucket structure
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter is a token bucket rate limiter keyed by client
+type rateLimiter struct {
+	mutex     sync.Mutex
+	rate      float64
+	burst     int
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+// newRateLimiter constructs rateLimiter
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{rate: rate, burst: burst, buckets: make(map[string]*tokenBucket), lastSweep: time.Now()}
+}
+
+// allow consumes a token for key, returning false and retry delay when limit is exceeded
+func (rl *rateLimiter) allow(key string) (bool, time.Duration) {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+	now := time.Now()
+	rl.sweep(now)
+	bucket, ok := rl.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(rl.burst), last: now}
+		rl.buckets[key] = bucket
+	} else {
+		bucket.tokens = math.Min(float64(rl.burst), bucket.tokens+now.Sub(bucket.last).Seconds()*rl.rate)
+		bucket.last = now
+	}
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - bucket.tokens) / rl.rate * float64(time.Second))
+}
+
+// sweep removes buckets refilled to burst, mutex must be held
+func (rl *rateLimiter) sweep(now time.Time) {
+	if now.Sub(rl.lastSweep) < time.Minute {
+		return
+	}
+	rl.lastSweep = now
+	for key, bucket := range rl.buckets {
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*rl.rate >= float64(rl.burst) {
+			delete(rl.buckets, key)
+		}
+	}
+}
+
+// SetRateLimit limits requests per second by client with token bucket of burst size (0 disables)
+func (gp *GisProxy) SetRateLimit(requestsPerSecond float64, burst int) {
+	if requestsPerSecond <= 0 {
+		gp.rateLimiter = nil
+	} else {
+		gp.rateLimiter = newRateLimiter(requestsPerSecond, burst)
+	}
+}
+
+// SetRateLimitKeyFunc sets rate limit key function, client IP is used by default. It is called
+// before request body is read, GisInfo is not yet in request context.
+func (gp *GisProxy) SetRateLimitKeyFunc(rateLimitKeyFunc RateLimitKeyFunc) {
+	gp.rateLimitKeyFunc = rateLimitKeyFunc
+}
+
+// checkRateLimit returns 429 error when request exceeds rate limit
+func (gp *GisProxy) checkRateLimit(writer http.ResponseWriter, request *http.Request) error {
+	if gp.rateLimiter == nil {
+		return nil
+	}
+	keyFunc := gp.rateLimitKeyFunc
+	if keyFunc == nil {
+		keyFunc = gp.clientIP
+	}
+	if ok, retryAfter := gp.rateLimiter.allow(keyFunc(request)); !ok {
+		writer.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		return NewStatusError("Too many requests", 429)
+	}
+	return nil
+}
+
+// clientIP returns client IP from remote address. When remote address is a trusted proxy (see
+// SetForwardedHeaders), X-Forwarded-For is walked from right to left and the first address that is
+// not a trusted proxy is returned, so that clients cannot spoof their address.
+// IP literals are returned in canonical form, IPv6 without brackets.
+func (gp *GisProxy) clientIP(request *http.Request) string {
+	address := request.RemoteAddr
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		address = host
+	}
+	if gp.isTrustedProxy(address) {
+		forwardedFor := strings.Split(strings.Join(request.Header["X-Forwarded-For"], ","), ",")
+		for i := len(forwardedFor) - 1; i >= 0; i-- {
+			forwarded := strings.TrimSpace(forwardedFor[i])
+			if forwarded == "" {
+				continue
+			}
+			if host, _, err := net.SplitHostPort(forwarded); err == nil {
+				forwarded = host
+			}
+			address = forwarded
+			if !gp.isTrustedProxy(forwarded) {
+				break
+			}
+		}
+	}
+	if ip := parseHostIP(address); ip != nil {
+		return ip.String()
+	}
+	return address
+}
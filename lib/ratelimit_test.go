@@ -0,0 +1,118 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	gp := NewGisProxyHandler("/gisproxy/", false)
+	if err := gp.SetForwardedHeaders(false, []string{"10.0.0.1", "192.168.0.0/16"}); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor []string
+		ip           string
+	}{
+		{"remote address", "203.0.113.7:1234", nil, "203.0.113.7"},
+		{"spoofed by untrusted peer", "203.0.113.7:1234", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"trusted proxy", "10.0.0.1:1234", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"spoofed behind trusted proxy", "10.0.0.1:1234", []string{"1.2.3.4, 198.51.100.1"}, "198.51.100.1"},
+		{"trusted proxy chain", "10.0.0.1:1234", []string{"198.51.100.1, 192.168.1.2"}, "198.51.100.1"},
+		{"multiple headers", "10.0.0.1:1234", []string{"1.2.3.4", "198.51.100.1"}, "198.51.100.1"},
+		{"only trusted proxies", "10.0.0.1:1234", []string{"192.168.1.2"}, "192.168.1.2"},
+		{"trusted proxy without header", "10.0.0.1:1234", nil, "10.0.0.1"},
+		{"ipv6 remote address", "[2001:db8::1]:1234", nil, "2001:db8::1"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest("GET", "/gisproxy/", nil)
+			request.RemoteAddr = test.remoteAddr
+			for _, forwardedFor := range test.forwardedFor {
+				request.Header.Add("X-Forwarded-For", forwardedFor)
+			}
+			if ip := gp.clientIP(request); ip != test.ip {
+				t.Errorf("client IP %v, want %v", ip, test.ip)
+			}
+		})
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		writer.Write([]byte("tile"))
+	})
+	gp := NewGisProxyHandler("/gisproxy/", false)
+	gp.SetRateLimit(0.001, 2)
+	target := "/gisproxy/" + encodeSegment(upstream.URL+"/tile")
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor string
+		status       int
+	}{
+		{"first request", "203.0.113.7:1234", "", http.StatusOK},
+		{"burst request", "203.0.113.7:1234", "", http.StatusOK},
+		{"limit exceeded", "203.0.113.7:1234", "", http.StatusTooManyRequests},
+		{"spoofed forwarded for", "203.0.113.7:1234", "198.51.100.1", http.StatusTooManyRequests},
+		{"other client", "203.0.113.8:1234", "", http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest("GET", target, nil)
+			request.RemoteAddr = test.remoteAddr
+			if test.forwardedFor != "" {
+				request.Header.Set("X-Forwarded-For", test.forwardedFor)
+			}
+			recorder := serve(gp, request)
+			if recorder.Code != test.status {
+				t.Errorf("status %v, want %v", recorder.Code, test.status)
+			}
+			if test.status == http.StatusTooManyRequests && recorder.Header().Get("Retry-After") == "" {
+				t.Error("missing Retry-After header")
+			}
+		})
+	}
+}
+
+func TestRateLimitKeyFunc(t *testing.T) {
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {})
+	gp := NewGisProxyHandler("/gisproxy/", false)
+	gp.SetRateLimit(0.001, 1)
+	gp.SetRateLimitKeyFunc(func(request *http.Request) string {
+		return request.Header.Get("X-Api-Key")
+	})
+	target := "/gisproxy/" + encodeSegment(upstream.URL)
+	for i, test := range []struct {
+		key    string
+		status int
+	}{
+		{"a", http.StatusOK},
+		{"a", http.StatusTooManyRequests},
+		{"b", http.StatusOK},
+	} {
+		request := httptest.NewRequest("GET", target, nil)
+		request.Header.Set("X-Api-Key", test.key)
+		if recorder := serve(gp, request); recorder.Code != test.status {
+			t.Errorf("request %v: status %v, want %v", i, recorder.Code, test.status)
+		}
+	}
+}
+
+func TestRateLimitBeforeBodyRead(t *testing.T) {
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {})
+	gp := NewGisProxyHandler("/gisproxy/", false)
+	gp.SetRateLimit(0.001, 1)
+	target := "/gisproxy/" + encodeSegment(upstream.URL+"/wms")
+	if recorder := serve(gp, httptest.NewRequest("GET", target, nil)); recorder.Code != http.StatusOK {
+		t.Fatalf("status %v, want %v", recorder.Code, http.StatusOK)
+	}
+	request := httptest.NewRequest("POST", target, &unreadableBody{t: t})
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if recorder := serve(gp, request); recorder.Code != http.StatusTooManyRequests {
+		t.Errorf("status %v, want %v", recorder.Code, http.StatusTooManyRequests)
+	}
+}
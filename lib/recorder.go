@@ -0,0 +1,99 @@
+package lib
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync/atomic"
+)
+
+// TransferStats structure
+type TransferStats struct {
+	bytesWritten int64
+}
+
+// BytesWritten returns number of response bytes written to client
+func (ts *TransferStats) BytesWritten() int64 {
+	return atomic.LoadInt64(&ts.bytesWritten)
+}
+
+// TransferStatsFromContext retrives TransferStats from context
+func TransferStatsFromContext(ctx context.Context) *TransferStats {
+	v := ctx.Value(contextKey("TransferStats"))
+	if v == nil {
+		return nil
+	}
+	return v.(*TransferStats)
+}
+
+// responseRecorder records status code and bytes written to response writer
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	stats  *TransferStats
+	total  *int64
+}
+
+// newResponseRecorder constructs responseRecorder adding written bytes to total
+func newResponseRecorder(writer http.ResponseWriter, total *int64) *responseRecorder {
+	return &responseRecorder{ResponseWriter: writer, stats: &TransferStats{}, total: total}
+}
+
+// WriteHeader records status code
+func (rr *responseRecorder) WriteHeader(status int) {
+	if rr.status == 0 {
+		rr.status = status
+	}
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+// Write records bytes written, including partial writes
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	if rr.status == 0 {
+		rr.status = 200
+	}
+	n, err := rr.ResponseWriter.Write(b)
+	atomic.AddInt64(&rr.stats.bytesWritten, int64(n))
+	atomic.AddInt64(rr.total, int64(n))
+	return n, err
+}
+
+// Flush flushes response writer when supported
+func (rr *responseRecorder) Flush() {
+	if flusher, ok := rr.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack hijacks connection when supported (WebSocket upgrade), recording switching protocols status
+func (rr *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := rr.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijack not supported")
+	}
+	conn, buffer, err := hijacker.Hijack()
+	if err == nil && rr.status == 0 {
+		rr.status = http.StatusSwitchingProtocols
+	}
+	return conn, buffer, err
+}
+
+// Status returns recorded status code
+func (rr *responseRecorder) Status() int {
+	if rr.status == 0 {
+		return 200
+	}
+	return rr.status
+}
+
+// Written returns number of bytes written
+func (rr *responseRecorder) Written() int64 {
+	return rr.stats.BytesWritten()
+}
+
+// TotalBytesServed returns cumulative number of response bytes written to clients
+func (gp *GisProxy) TotalBytesServed() int64 {
+	return atomic.LoadInt64(&gp.totalBytesServed)
+}
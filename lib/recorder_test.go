@@ -0,0 +1,76 @@
+package lib
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// failingWriter fails writes once limit bytes are written, as a disconnected client
+type failingWriter struct {
+	*httptest.ResponseRecorder
+	limit int
+}
+
+func (fw *failingWriter) Write(b []byte) (int, error) {
+	if fw.Body.Len()+len(b) > fw.limit {
+		n, _ := fw.ResponseRecorder.Write(b[:fw.limit-fw.Body.Len()])
+		return n, errors.New("client disconnected")
+	}
+	return fw.ResponseRecorder.Write(b)
+}
+
+func TestTransferStats(t *testing.T) {
+	body := strings.Repeat("x", 100000)
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		if request.URL.Path == "/missing" {
+			http.Error(writer, "missing", http.StatusNotFound)
+			return
+		}
+		writer.Write([]byte(body))
+	})
+	tests := []struct {
+		name  string
+		path  string
+		limit int
+		bytes int64
+	}{
+		{"full copy", "/tile", len(body), int64(len(body))},
+		{"error response", "/missing", len(body), int64(len("missing\n"))},
+		{"client disconnect", "/tile", 1000, 1000},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var stats *TransferStats
+			gp := NewGisProxyHandler("/gisproxy/", false)
+			gp.SetAfterReceiveFunc(func(writer http.ResponseWriter, response *http.Response) error {
+				stats = TransferStatsFromContext(response.Request.Context())
+				return nil
+			})
+			writer := &failingWriter{ResponseRecorder: httptest.NewRecorder(), limit: test.limit}
+			gp.ServeHTTP(writer, httptest.NewRequest("GET", "/gisproxy/"+encodeSegment(upstream.URL+test.path), nil))
+			if total := gp.TotalBytesServed(); total != test.bytes {
+				t.Errorf("total bytes served %v, want %v", total, test.bytes)
+			}
+			if stats == nil {
+				t.Fatal("missing transfer stats in context")
+			}
+			if written := stats.BytesWritten(); written != test.bytes {
+				t.Errorf("bytes written %v, want %v", written, test.bytes)
+			}
+		})
+	}
+}
+
+func TestTotalBytesServedCountsRejections(t *testing.T) {
+	gp := NewGisProxyHandler("/gisproxy/", false)
+	recorder := serve(gp, httptest.NewRequest("GET", "/gisproxy/"+encodeSegment("file:///etc/passwd"), nil))
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("status %v, want %v", recorder.Code, http.StatusBadRequest)
+	}
+	if total := gp.TotalBytesServed(); total != int64(recorder.Body.Len()) || total == 0 {
+		t.Errorf("total bytes served %v, want %v", total, recorder.Body.Len())
+	}
+}
@@ -0,0 +1,54 @@
+package lib
+
+import (
+	"net/http"
+	"strings"
+)
+
+// SetFollowRedirects follows up to max upstream redirects and returns final response, redirect
+// targets must be allowed hosts and a redirect to an already visited url fails with 508 Loop
+// Detected. Zero (default) returns redirect responses as is.
+func (gp *GisProxy) SetFollowRedirects(max int) {
+	gp.followRedirects = max
+}
+
+// checkRedirect checks if upstream redirect is followed
+func (gp *GisProxy) checkRedirect(request *http.Request, via []*http.Request) error {
+	if gp.followRedirects <= 0 || len(via) > gp.followRedirects {
+		return http.ErrUseLastResponse
+	}
+	target := request.URL.String()
+	for _, previous := range via {
+		if previous.URL.String() == target {
+			return NewStatusError("Redirect loop to "+redactURL(request.URL), http.StatusLoopDetected)
+		}
+	}
+	return gp.checkForwardURL(request.URL, gp.allowedHostsFor(request.Context()))
+}
+
+// SetRewriteRedirectLocation rewrites Location header of upstream 3xx responses pointing at origin
+// server (relative locations included) to go through proxy. publicBaseURL is proxy url including
+// prefix (see SetPublicBaseURL), it is computed from incoming request when empty.
+func (gp *GisProxy) SetRewriteRedirectLocation(publicBaseURL string) {
+	gp.rewriteRedirectLocation = true
+	if publicBaseURL != "" {
+		gp.publicBaseURL = publicBaseURL
+	}
+}
+
+// rewriteLocation rewrites upstream redirect location to proxy url
+func (gp *GisProxy) rewriteLocation(request *http.Request, response *http.Response) {
+	if !gp.rewriteRedirectLocation || response.StatusCode < 300 || response.StatusCode >= 400 || response.Request == nil {
+		return
+	}
+	location := response.Header.Get("Location")
+	if location == "" {
+		return
+	}
+	origin := response.Request.URL
+	target, err := origin.Parse(location)
+	if err != nil || !strings.EqualFold(target.Host, origin.Host) {
+		return
+	}
+	response.Header.Set("Location", gp.proxyURL(request, gp.proxyBaseURL(request), target))
+}
@@ -0,0 +1,149 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+)
+
+func TestFollowRedirects(t *testing.T) {
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		switch request.URL.Path {
+		case "/self":
+			http.Redirect(writer, request, "/self", http.StatusFound)
+		case "/a":
+			http.Redirect(writer, request, "/b", http.StatusFound)
+		case "/b":
+			http.Redirect(writer, request, "/a", http.StatusMovedPermanently)
+		case "/chain":
+			n, _ := strconv.Atoi(request.URL.Query().Get("n"))
+			if n > 0 {
+				http.Redirect(writer, request, "/chain?n="+strconv.Itoa(n-1), http.StatusFound)
+				return
+			}
+			writer.Write([]byte("final"))
+		}
+	})
+	tests := []struct {
+		name   string
+		path   string
+		status int
+		body   string
+	}{
+		{"self redirect", "/self", http.StatusLoopDetected, ""},
+		{"redirect loop", "/a", http.StatusLoopDetected, ""},
+		{"redirect chain", "/chain?n=3", http.StatusOK, "final"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gp := NewGisProxyHandler("/gisproxy/", false)
+			gp.SetFollowRedirects(5)
+			recorder := serve(gp, httptest.NewRequest("GET", "/gisproxy/"+encodeSegment(upstream.URL+test.path), nil))
+			if recorder.Code != test.status {
+				t.Errorf("status %v, want %v", recorder.Code, test.status)
+			}
+			if test.body != "" && recorder.Body.String() != test.body {
+				t.Errorf("body %q, want %q", recorder.Body.String(), test.body)
+			}
+		})
+	}
+}
+
+func TestFollowRedirectsPolicy(t *testing.T) {
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		switch request.URL.Path {
+		case "/moved":
+			http.Redirect(writer, request, "/final", http.StatusTemporaryRedirect)
+		case "/external":
+			http.Redirect(writer, request, "http://other.example.com/final", http.StatusMovedPermanently)
+		case "/chain":
+			n, _ := strconv.Atoi(request.URL.Query().Get("n"))
+			if n > 0 {
+				http.Redirect(writer, request, "/chain?n="+strconv.Itoa(n-1), http.StatusFound)
+				return
+			}
+			writer.Write([]byte("final"))
+		case "/final":
+			writer.Write([]byte("final"))
+		}
+	})
+	upstreamURL, err := url.Parse(upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		follow int
+		path   string
+		status int
+		body   string
+	}{
+		{"passthrough by default", 0, "/moved", http.StatusTemporaryRedirect, ""},
+		{"followed", 5, "/moved", http.StatusOK, "final"},
+		{"max redirects exceeded", 2, "/chain?n=5", http.StatusFound, ""},
+		{"max redirects reached", 2, "/chain?n=2", http.StatusOK, "final"},
+		{"host outside allowlist", 5, "/external", http.StatusForbidden, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gp := NewGisProxyHandler("/gisproxy/", false)
+			gp.SetFollowRedirects(test.follow)
+			gp.SetAllowedHosts([]string{upstreamURL.Host})
+			recorder := serve(gp, httptest.NewRequest("GET", "/gisproxy/"+encodeSegment(upstream.URL+test.path), nil))
+			if recorder.Code != test.status {
+				t.Errorf("status %v, want %v", recorder.Code, test.status)
+			}
+			if test.body != "" && recorder.Body.String() != test.body {
+				t.Errorf("body %q, want %q", recorder.Body.String(), test.body)
+			}
+		})
+	}
+}
+
+func TestRewriteRedirectLocation(t *testing.T) {
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Location", request.URL.Query().Get("location"))
+		status, _ := strconv.Atoi(request.URL.Query().Get("status"))
+		writer.WriteHeader(status)
+	})
+	const base = "https://proxy.example.com/gisproxy/"
+	proxied := func(rawURL string) string {
+		target, err := url.Parse(rawURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return encodeForwardUrl(base, target)
+	}
+	tests := []struct {
+		name     string
+		rewrite  bool
+		status   int
+		location string
+		want     string
+	}{
+		{"absolute", true, http.StatusFound, upstream.URL + "/wms/next?a=1", proxied(upstream.URL + "/wms/next?a=1")},
+		{"root relative", true, http.StatusMovedPermanently, "/other/path", proxied(upstream.URL + "/other/path")},
+		{"path relative", true, http.StatusSeeOther, "next", proxied(upstream.URL + "/wms/next")},
+		{"other host", true, http.StatusFound, "https://login.example.com/sso", "https://login.example.com/sso"},
+		{"not a redirect", true, http.StatusCreated, "/wms/created", "/wms/created"},
+		{"disabled", false, http.StatusFound, upstream.URL + "/wms/next", upstream.URL + "/wms/next"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gp := NewGisProxyHandler("/gisproxy/", false)
+			if test.rewrite {
+				gp.SetRewriteRedirectLocation(base)
+			}
+			query := url.Values{"location": {test.location}, "status": {strconv.Itoa(test.status)}}
+			recorder := serve(gp, httptest.NewRequest("GET", "/gisproxy/"+encodeSegment(upstream.URL+"/wms/start?"+query.Encode()), nil))
+			if recorder.Code != test.status {
+				t.Fatalf("status %v, want %v", recorder.Code, test.status)
+			}
+			if location := recorder.Header().Get("Location"); location != test.want {
+				t.Errorf("Location %q, want %q", location, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package lib
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+// requestIDMaxLength is maximum length of incoming request id, longer ones are replaced
+const requestIDMaxLength = 128
+
+// SetRequestIDHeader sets request id header name (X-Request-ID for instance), empty disables request
+// ids. Incoming request id is kept, otherwise a random one is generated. It is forwarded upstream,
+// returned in response header and available in context (see RequestIDFromContext).
+func (gp *GisProxy) SetRequestIDHeader(name string) {
+	gp.requestIDHeader = http.CanonicalHeaderKey(name)
+}
+
+// RequestIDFromContext retrieves request id from context, empty if request ids are disabled
+func RequestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(contextKey("RequestID")).(string)
+	return requestID
+}
+
+// newRequestID generates a random 128-bit request id
+func newRequestID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// validRequestID checks that incoming request id is printable ascii of reasonable length
+func validRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > requestIDMaxLength {
+		return false
+	}
+	for i := 0; i < len(requestID); i++ {
+		if requestID[i] <= ' ' || requestID[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// requestID returns incoming request id or generates one, empty if request ids are disabled
+func (gp *GisProxy) requestID(request *http.Request) string {
+	if gp.requestIDHeader == "" {
+		return ""
+	}
+	if requestID := request.Header.Get(gp.requestIDHeader); validRequestID(requestID) {
+		return requestID
+	}
+	return newRequestID()
+}
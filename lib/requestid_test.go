@@ -0,0 +1,65 @@
+package lib
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestRequestID(t *testing.T) {
+	var received string
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		received = request.Header.Get("X-Request-ID")
+	})
+	generated := regexp.MustCompile("^[0-9a-f]{32}$")
+	tests := []struct {
+		name      string
+		header    string
+		incoming  string
+		want      string
+		generated bool
+	}{
+		{"disabled", "", "abc-123", "", false},
+		{"kept", "X-Request-ID", "abc-123", "abc-123", false},
+		{"generated", "x-request-id", "", "", true},
+		{"invalid replaced", "X-Request-ID", "abc 123", "", true},
+		{"too long replaced", "X-Request-ID", strings.Repeat("a", requestIDMaxLength+1), "", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			received = ""
+			var fromContext string
+			gp := NewGisProxyHandler("/gisproxy/", false)
+			gp.SetRequestIDHeader(test.header)
+			gp.SetRequestInterceptor(func(ctx context.Context, writer http.ResponseWriter, request *http.Request) (bool, error) {
+				fromContext = RequestIDFromContext(ctx)
+				return false, nil
+			})
+			request := httptest.NewRequest("GET", "/gisproxy/"+encodeSegment(upstream.URL+"/wms"), nil)
+			if test.incoming != "" {
+				request.Header.Set("X-Request-ID", test.incoming)
+			}
+			recorder := serve(gp, request)
+			requestID := recorder.Header().Get("X-Request-ID")
+			if test.generated {
+				if !generated.MatchString(requestID) {
+					t.Errorf("generated request id %q", requestID)
+				}
+			} else if requestID != test.want {
+				t.Errorf("response request id %q, want %q", requestID, test.want)
+			}
+			if fromContext != requestID {
+				t.Errorf("context request id %q, want %q", fromContext, requestID)
+			}
+			if test.header == "" {
+				requestID = test.incoming
+			}
+			if received != requestID {
+				t.Errorf("forwarded request id %q, want %q", received, requestID)
+			}
+		})
+	}
+}
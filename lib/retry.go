@@ -0,0 +1,92 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// defaultRetryMethods lists idempotent methods retried by default
+var defaultRetryMethods = []string{"GET", "HEAD", "OPTIONS"}
+
+// SetRetryPolicy retries transient upstream failures of GET, HEAD and OPTIONS requests (see
+// SetRetryMethods) up to maxRetries times, doubling backoff delay after each attempt
+func (gp *GisProxy) SetRetryPolicy(maxRetries int, backoff time.Duration) {
+	gp.maxRetries = maxRetries
+	gp.retryBackoff = backoff
+}
+
+// SetRetryMethods sets methods of retried requests, nil means GET, HEAD and OPTIONS. Upstream may
+// already have processed a failed request, other methods (POST OGC requests for instance) must only
+// be set when not changing upstream state. Requests with a body that cannot be replayed are not retried.
+func (gp *GisProxy) SetRetryMethods(methods []string) {
+	if methods == nil {
+		gp.retryMethods = nil
+		return
+	}
+	gp.retryMethods = make([]string, 0, len(methods))
+	for _, method := range methods {
+		gp.retryMethods = append(gp.retryMethods, strings.ToUpper(method))
+	}
+}
+
+// sendWithRetry sends request, retrying connection errors and 502/503/504 responses
+func (gp *GisProxy) sendWithRetry(request *http.Request) (*http.Response, error) {
+	if gp.maxRetries <= 0 || !gp.isRetried(request) {
+		return gp.client.Do(request)
+	}
+	for attempt := 0; ; attempt++ {
+		response, err := gp.client.Do(request)
+		if attempt >= gp.maxRetries || !isTransient(request.Context(), response, err) {
+			return response, err
+		}
+		addSpanEvent(request.Context(), "retry")
+		if err != nil {
+			gp.logger.Infof("Retry %v %v %v %v", attempt+1, request.Method, redactURL(request.URL), err)
+		} else {
+			gp.logger.Infof("Retry %v %v %v %v", attempt+1, request.Method, redactURL(request.URL), response.StatusCode)
+			io.Copy(ioutil.Discard, response.Body)
+			response.Body.Close()
+		}
+		select {
+		case <-request.Context().Done():
+			return nil, request.Context().Err()
+		case <-time.After(gp.retryBackoff << uint(attempt)):
+		}
+		if request.GetBody != nil {
+			if request.Body, err = request.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// isRetried checks if request method is retried and request may be sent again
+func (gp *GisProxy) isRetried(request *http.Request) bool {
+	methods := gp.retryMethods
+	if methods == nil {
+		methods = defaultRetryMethods
+	}
+	for _, method := range methods {
+		if method == request.Method {
+			return request.Body == nil || request.Body == http.NoBody || request.GetBody != nil
+		}
+	}
+	return false
+}
+
+// isTransient checks if response or error is a transient upstream failure
+func isTransient(ctx context.Context, response *http.Response, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if err != nil {
+		var statusError *StatusError
+		return !errors.As(err, &statusError)
+	}
+	return response.StatusCode == 502 || response.StatusCode == 503 || response.StatusCode == 504
+}
@@ -0,0 +1,47 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryMethods(t *testing.T) {
+	var attempts int32
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			writer.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+	tests := []struct {
+		name     string
+		method   string
+		methods  []string
+		status   int
+		attempts int32
+	}{
+		{"get", "GET", nil, http.StatusOK, 2},
+		{"head", "HEAD", nil, http.StatusOK, 2},
+		{"post", "POST", nil, http.StatusServiceUnavailable, 1},
+		{"delete", "DELETE", nil, http.StatusServiceUnavailable, 1},
+		{"post opt-in", "POST", []string{"get", "post"}, http.StatusOK, 2},
+		{"get not in methods", "GET", []string{"POST"}, http.StatusServiceUnavailable, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			atomic.StoreInt32(&attempts, 0)
+			gp := NewGisProxyHandler("/gisproxy/", false)
+			gp.SetRetryPolicy(2, time.Millisecond)
+			gp.SetRetryMethods(test.methods)
+			recorder := serve(gp, httptest.NewRequest(test.method, "/gisproxy/"+encodeSegment(upstream.URL), nil))
+			if recorder.Code != test.status {
+				t.Errorf("status %v, want %v", recorder.Code, test.status)
+			}
+			if n := atomic.LoadInt32(&attempts); n != test.attempts {
+				t.Errorf("%v attempts, want %v", n, test.attempts)
+			}
+		})
+	}
+}
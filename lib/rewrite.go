@@ -0,0 +1,155 @@
+package lib
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// BodyRewrite defines response body rewrite function
+type BodyRewrite func(ctx context.Context, info *GisInfo, contentType string, body []byte) ([]byte, error)
+
+// defaultRewriteContentTypes lists text-like content types rewritten by default
+var defaultRewriteContentTypes = []string{
+	"text/",
+	"application/json",
+	"application/geo+json",
+	"application/xml",
+	"application/vnd.ogc.",
+	"application/javascript",
+}
+
+// SetBodyRewriteFunc sets response body rewrite function, invoked only for responses
+// whose content type starts with one of contentTypes (nil means text-like content types)
+func (gp *GisProxy) SetBodyRewriteFunc(bodyRewriteFunc BodyRewrite, contentTypes []string) {
+	gp.bodyRewriteFunc = bodyRewriteFunc
+	if contentTypes == nil {
+		contentTypes = defaultRewriteContentTypes
+	}
+	gp.rewriteContentTypes = make([]string, 0, len(contentTypes))
+	for _, contentType := range contentTypes {
+		gp.rewriteContentTypes = append(gp.rewriteContentTypes, strings.ToLower(contentType))
+	}
+}
+
+// bodyRewriters returns rewrite functions applying to response
+func (gp *GisProxy) bodyRewriters(request *http.Request, response *http.Response) []BodyRewrite {
+	if request.Method == "HEAD" || response.StatusCode == 204 || response.StatusCode == 304 {
+		return nil
+	}
+	if isVectorTile(response) || isVectorTileRequest(request) {
+		// Binary vector tiles are never rewritten as text
+		return nil
+	}
+	if isPartialResponse(response) {
+		return nil
+	}
+	if !canDecodeBody(response) {
+		// Rewriting encoded bytes (brotli for instance) would corrupt body
+		return nil
+	}
+	rewriters := make([]BodyRewrite, 0)
+	if gp.bodyRewriteFunc != nil && hasContentTypePrefix(response, gp.rewriteContentTypes) {
+		rewriters = append(rewriters, gp.bodyRewriteFunc)
+	}
+	if rewriter := gp.capabilitiesRewriter(request, response); rewriter != nil {
+		rewriters = append(rewriters, rewriter)
+	}
+	if rewriter := gp.geoJSONBBoxRewriter(request, response); rewriter != nil {
+		rewriters = append(rewriters, rewriter)
+	}
+	return rewriters
+}
+
+// hasContentTypePrefix checks if response content type starts with one of prefixes
+func hasContentTypePrefix(response *http.Response, prefixes []string) bool {
+	contentType := strings.ToLower(response.Header.Get("Content-Type"))
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(contentType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// canDecodeBody checks if response content encoding is identity, gzip or deflate
+func canDecodeBody(response *http.Response) bool {
+	switch strings.ToLower(strings.TrimSpace(response.Header.Get("Content-Encoding"))) {
+	case "", "identity", "gzip", "x-gzip", "deflate":
+		return true
+	}
+	return false
+}
+
+// decodeBody decodes gzip or deflate (zlib or raw) encoded body, decoded body larger than limit
+// fails with errResponseTooLarge
+func decodeBody(encoding string, body []byte, limit int64) ([]byte, error) {
+	var reader io.Reader
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "gzip", "x-gzip":
+		gzipReader, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	case "deflate":
+		zlibReader, err := zlib.NewReader(bytes.NewReader(body))
+		if err != nil {
+			// Some servers send raw deflate without zlib header
+			reader = flate.NewReader(bytes.NewReader(body))
+		} else {
+			defer zlibReader.Close()
+			reader = zlibReader
+		}
+	default:
+		return body, nil
+	}
+	decoded, err := ioutil.ReadAll(io.LimitReader(reader, limit+1))
+	if err == nil && int64(len(decoded)) > limit {
+		err = errResponseTooLarge
+	}
+	return decoded, err
+}
+
+// rewriteBody reads, decodes and rewrites response body, removing Content-Encoding and updating Content-Length.
+// Body larger than buffer limit (see bufferLimit), encoded or decoded, is passed through without rewrite.
+func (gp *GisProxy) rewriteBody(request *http.Request, response *http.Response, rewriters []BodyRewrite) (io.Reader, error) {
+	limit := gp.bufferLimit()
+	body, err := ioutil.ReadAll(io.LimitReader(response.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > limit {
+		gp.logger.Debugf("Response too large to rewrite %v", request.URL)
+		return io.MultiReader(bytes.NewReader(body), response.Body), nil
+	}
+	if encoding := response.Header.Get("Content-Encoding"); encoding != "" {
+		decoded, err := decodeBody(encoding, body, limit)
+		if err == errResponseTooLarge {
+			gp.logger.Debugf("Decoded response too large to rewrite %v", request.URL)
+			return bytes.NewReader(body), nil
+		}
+		if err != nil {
+			return nil, NewStatusError("Invalid "+encoding+" response body", http.StatusBadGateway)
+		}
+		body = decoded
+		response.Header.Del("Content-Encoding")
+	}
+	for _, rewriter := range rewriters {
+		body, err = rewriter(request.Context(), GisInfoFromContext(request.Context()), response.Header.Get("Content-Type"), body)
+		if err != nil {
+			return nil, err
+		}
+	}
+	response.ContentLength = int64(len(body))
+	response.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	return bytes.NewReader(body), nil
+}
@@ -0,0 +1,200 @@
+package lib
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// encodeTestBody returns body encoded with content encoding
+func encodeTestBody(t *testing.T, encoding string, body string) []byte {
+	t.Helper()
+	var buffer bytes.Buffer
+	var writer io.WriteCloser
+	switch encoding {
+	case "gzip":
+		writer = gzip.NewWriter(&buffer)
+	case "deflate":
+		writer = zlib.NewWriter(&buffer)
+	case "raw deflate":
+		writer, _ = flate.NewWriter(&buffer, flate.DefaultCompression)
+	default:
+		// Opaque bytes stand for encodings proxy cannot decode
+		return []byte("\x0b\x02\x80" + body)
+	}
+	writer.Write([]byte(body))
+	writer.Close()
+	return buffer.Bytes()
+}
+
+func TestBodyRewriteEncodedUpstream(t *testing.T) {
+	const body = `{"url":"http://internal/wms"}`
+	tests := []struct {
+		name     string
+		encoding string
+		header   string
+		rewrite  bool
+	}{
+		{"gzip", "gzip", "gzip", true},
+		{"deflate", "deflate", "deflate", true},
+		{"raw deflate", "raw deflate", "deflate", true},
+		{"brotli skipped", "br", "br", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			encoded := encodeTestBody(t, test.encoding, body)
+			upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+				writer.Header().Set("Content-Type", "application/json")
+				writer.Header().Set("Content-Encoding", test.header)
+				writer.Header().Set("Content-Length", strconv.Itoa(len(encoded)))
+				writer.Write(encoded)
+			})
+			var seen []byte
+			gp := NewGisProxyHandler("/gisproxy/", false)
+			gp.SetBodyRewriteFunc(func(ctx context.Context, info *GisInfo, contentType string, body []byte) ([]byte, error) {
+				seen = body
+				return bytes.Replace(body, []byte("http://internal"), []byte("https://public"), 1), nil
+			}, nil)
+			request := httptest.NewRequest("GET", "/gisproxy/"+encodeSegment(upstream.URL), nil)
+			request.Header.Set("Accept-Encoding", test.header)
+			recorder := serve(gp, request)
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("status %v, want %v", recorder.Code, http.StatusOK)
+			}
+			if !test.rewrite {
+				if seen != nil {
+					t.Error("rewrite called on undecodable body")
+				}
+				if encoding := recorder.Header().Get("Content-Encoding"); encoding != test.header {
+					t.Errorf("Content-Encoding %q, want %q", encoding, test.header)
+				}
+				if !bytes.Equal(recorder.Body.Bytes(), encoded) {
+					t.Error("undecodable body altered")
+				}
+				return
+			}
+			if string(seen) != body {
+				t.Errorf("rewrite saw %q, want %q", seen, body)
+			}
+			if encoding := recorder.Header().Get("Content-Encoding"); encoding != "" {
+				t.Errorf("Content-Encoding %q, want none", encoding)
+			}
+			want := strings.Replace(body, "http://internal", "https://public", 1)
+			if recorder.Body.String() != want {
+				t.Errorf("body %q, want %q", recorder.Body.String(), want)
+			}
+			if length := recorder.Header().Get("Content-Length"); length != strconv.Itoa(len(want)) {
+				t.Errorf("Content-Length %v, want %v", length, len(want))
+			}
+		})
+	}
+}
+
+func TestBodyRewriteInvalidEncoding(t *testing.T) {
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Type", "application/json")
+		writer.Header().Set("Content-Encoding", "gzip")
+		writer.Write([]byte("not gzip"))
+	})
+	gp := NewGisProxyHandler("/gisproxy/", false)
+	gp.SetBodyRewriteFunc(func(ctx context.Context, info *GisInfo, contentType string, body []byte) ([]byte, error) {
+		return body, nil
+	}, nil)
+	request := httptest.NewRequest("GET", "/gisproxy/"+encodeSegment(upstream.URL), nil)
+	request.Header.Set("Accept-Encoding", "gzip")
+	if recorder := serve(gp, request); recorder.Code != http.StatusBadGateway {
+		t.Errorf("status %v, want %v", recorder.Code, http.StatusBadGateway)
+	}
+}
+
+func TestBodyRewriteContentTypes(t *testing.T) {
+	const body = "http://internal/wms"
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Type", request.URL.Query().Get("type"))
+		writer.Write([]byte(body))
+	})
+	tests := []struct {
+		name         string
+		contentTypes []string
+		contentType  string
+		rewrite      bool
+	}{
+		{"default json", nil, "application/json; charset=utf-8", true},
+		{"default xml", nil, "application/vnd.ogc.wms_xml", true},
+		{"default text", nil, "text/html", true},
+		{"default png", nil, "image/png", false},
+		{"default octet stream", nil, "application/octet-stream", false},
+		{"custom type", []string{"Application/X-Custom"}, "application/x-custom", true},
+		{"custom excludes json", []string{"application/x-custom"}, "application/json", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			called := false
+			gp := NewGisProxyHandler("/gisproxy/", false)
+			gp.SetBodyRewriteFunc(func(ctx context.Context, info *GisInfo, contentType string, body []byte) ([]byte, error) {
+				called = true
+				if info == nil {
+					t.Error("rewrite called without GisInfo")
+				}
+				if contentType != test.contentType {
+					t.Errorf("rewrite content type %q, want %q", contentType, test.contentType)
+				}
+				return bytes.Replace(body, []byte("http://internal"), []byte("https://proxy.example.com/gisproxy"), 1), nil
+			}, test.contentTypes)
+			recorder := serve(gp, httptest.NewRequest("GET", "/gisproxy/"+encodeSegment(upstream.URL+"/data?type="+url.QueryEscape(test.contentType)), nil))
+			if called != test.rewrite {
+				t.Errorf("rewrite called %v, want %v", called, test.rewrite)
+			}
+			want := body
+			if test.rewrite {
+				want = "https://proxy.example.com/gisproxy/wms"
+			}
+			if recorder.Body.String() != want {
+				t.Errorf("body %q, want %q", recorder.Body.String(), want)
+			}
+			if length := recorder.Header().Get("Content-Length"); length != strconv.Itoa(len(want)) {
+				t.Errorf("Content-Length %v, want %v", length, len(want))
+			}
+		})
+	}
+}
+
+func TestBodyRewriteError(t *testing.T) {
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Type", "application/json")
+		writer.Write([]byte(`{"secret":true}`))
+	})
+	tests := []struct {
+		name   string
+		err    error
+		status int
+	}{
+		{"error", errors.New("rewrite failed"), http.StatusInternalServerError},
+		{"status error", NewStatusError("Forbidden content", http.StatusForbidden), http.StatusForbidden},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gp := NewGisProxyHandler("/gisproxy/", false)
+			gp.SetBodyRewriteFunc(func(ctx context.Context, info *GisInfo, contentType string, body []byte) ([]byte, error) {
+				return nil, test.err
+			}, nil)
+			recorder := serve(gp, httptest.NewRequest("GET", "/gisproxy/"+encodeSegment(upstream.URL), nil))
+			if recorder.Code != test.status {
+				t.Errorf("status %v, want %v", recorder.Code, test.status)
+			}
+			if strings.Contains(recorder.Body.String(), "secret") {
+				t.Errorf("body %q leaks upstream response", recorder.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package lib
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// RouteOptions structure, nil fields default to GisProxy settings
+type RouteOptions struct {
+	BeforeSend   BeforeSend
+	AfterReceive AfterReceive
+	AllowedHosts []string
+	// PlainURL reads literal upstream url after prefix instead of base64 segment
+	// (/raw/https://server/path?query, scheme may be percent-encoded or with collapsed slash),
+	// it requires allowed hosts on route or GisProxy so that route is not an open proxy
+	PlainURL bool
+	// UpstreamHost overrides Host header of forwarded requests (see SetUpstreamHost)
+	UpstreamHost string
+}
+
+// route structure
+type route struct {
+	prefix  string
+	options RouteOptions
+	re      *regexp.Regexp
+}
+
+// routeFromContext retrieves matched route from context
+func routeFromContext(ctx context.Context) *route {
+	if forwardInfo := ForwardInfoFromContext(ctx); forwardInfo != nil {
+		return forwardInfo.route
+	}
+	return nil
+}
+
+// normalizePrefix adds leading and trailing slashes to prefix
+func normalizePrefix(prefix string) string {
+	if !strings.HasPrefix(prefix, "/") {
+		prefix = "/" + prefix
+	}
+	if !strings.HasSuffix(prefix, "/") {
+		prefix = prefix + "/"
+	}
+	return prefix
+}
+
+// prefixMatcher structure, normalized Prefix and its compiled forward url regexp
+type prefixMatcher struct {
+	raw    string
+	prefix string
+	re     *regexp.Regexp
+	set    bool
+}
+
+// SetPrefix sets prefix, safe while serving requests: forward url regexp is compiled once here,
+// and Prefix field is then ignored. Prefix field set before serving is compiled on first request.
+func (gp *GisProxy) SetPrefix(prefix string) {
+	normalized := normalizePrefix(prefix)
+	gp.prefixMatcher.Store(&prefixMatcher{raw: prefix, prefix: normalized, re: compilePrefix(normalized), set: true})
+}
+
+// currentPrefix returns prefix matcher, compiled again only when Prefix field has changed
+func (gp *GisProxy) currentPrefix() *prefixMatcher {
+	pm, _ := gp.prefixMatcher.Load().(*prefixMatcher)
+	if pm != nil && (pm.set || pm.raw == gp.Prefix) {
+		return pm
+	}
+	normalized := normalizePrefix(gp.Prefix)
+	pm = &prefixMatcher{raw: gp.Prefix, prefix: normalized, re: compilePrefix(normalized)}
+	gp.prefixMatcher.Store(pm)
+	return pm
+}
+
+// compilePrefix compiles forward url regexp for prefix, matched literally at start of escaped path
+func compilePrefix(prefix string) *regexp.Regexp {
+	return regexp.MustCompile("^(" + regexp.QuoteMeta(prefix) + ")([^/]+)(/.*)?$")
+}
+
+// AddRoute adds prefix with its own options, matched before Prefix (longest prefix first)
+func (gp *GisProxy) AddRoute(prefix string, options RouteOptions) {
+	prefix = normalizePrefix(prefix)
+	r := &route{prefix: prefix, options: options, re: compilePrefix(prefix)}
+	if options.AllowedHosts != nil {
+		r.options.AllowedHosts = lowerHosts(options.AllowedHosts)
+	}
+	gp.routes = append(gp.routes, r)
+	sort.SliceStable(gp.routes, func(i, j int) bool {
+		return len(gp.routes[i].prefix) > len(gp.routes[j].prefix)
+	})
+}
+
+// beforeSendFor returns BeforeSend callback for context route
+func (gp *GisProxy) beforeSendFor(ctx context.Context) BeforeSend {
+	if r := routeFromContext(ctx); r != nil && r.options.BeforeSend != nil {
+		return r.options.BeforeSend
+	}
+	return gp.beforeSendFunc
+}
+
+// afterReceiveFor returns AfterReceive callback for context route
+func (gp *GisProxy) afterReceiveFor(ctx context.Context) AfterReceive {
+	if r := routeFromContext(ctx); r != nil && r.options.AfterReceive != nil {
+		return r.options.AfterReceive
+	}
+	return gp.afterReceiveFunc
+}
+
+// upstreamHostFor returns upstream Host header override for context route
+func (gp *GisProxy) upstreamHostFor(ctx context.Context) string {
+	if r := routeFromContext(ctx); r != nil && r.options.UpstreamHost != "" {
+		return r.options.UpstreamHost
+	}
+	return gp.upstreamHost
+}
+
+// allowedHostsFor returns allowed hosts for context route
+func (gp *GisProxy) allowedHostsFor(ctx context.Context) []string {
+	if r := routeFromContext(ctx); r != nil && r.options.AllowedHosts != nil {
+		return r.options.AllowedHosts
+	}
+	return gp.allowedHosts
+}
+
+// decodePlainURL reads literal forward url following route prefix
+func (gp *GisProxy) decodePlainURL(incomingRequest *http.Request, r *route) (*url.URL, *ForwardInfo, error) {
+	allowedHosts := r.options.AllowedHosts
+	if allowedHosts == nil {
+		allowedHosts = gp.allowedHosts
+	}
+	if len(allowedHosts) == 0 {
+		return nil, nil, NewStatusError("Plain url prefix "+r.prefix+" requires allowed hosts", 403)
+	}
+	rawURL := strings.TrimPrefix(incomingRequest.URL.EscapedPath(), r.prefix)
+	if lower := strings.ToLower(rawURL); strings.HasPrefix(lower, "http%3a") || strings.HasPrefix(lower, "https%3a") {
+		// Percent-encoded url
+		unescaped, err := url.PathUnescape(rawURL)
+		if err != nil {
+			return nil, nil, err
+		}
+		rawURL = unescaped
+	}
+	for _, scheme := range []string{"http:/", "https:/"} {
+		if strings.HasPrefix(rawURL, scheme) && !strings.HasPrefix(rawURL, scheme+"/") {
+			// Slashes collapsed by path cleaning
+			rawURL = scheme + rawURL[len(scheme)-1:]
+		}
+	}
+	forwardUrl, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	remaining := ""
+	if incomingRequest.URL.RawQuery != "" || incomingRequest.URL.ForceQuery {
+		remaining = "?" + incomingRequest.URL.RawQuery
+		forwardUrl.RawQuery = incomingRequest.URL.RawQuery
+	}
+	forwardInfo := &ForwardInfo{Prefix: r.prefix, DecodedURL: rawURL, RemainingPath: remaining, route: r}
+	return forwardUrl, forwardInfo, nil
+}
@@ -0,0 +1,174 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRoutePrefixMatchesPathOnly(t *testing.T) {
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		writer.Write([]byte("protected"))
+	})
+	gp := NewGisProxyHandler("/gisproxy/", false)
+	gp.AddRoute("/secure/", RouteOptions{BeforeSend: func(writer http.ResponseWriter, request *http.Request) error {
+		return NewStatusError("Unauthorized", http.StatusUnauthorized)
+	}})
+	gp.AddRoute("/publicdata/", RouteOptions{})
+	segment := encodeSegment(upstream.URL + "/data")
+	tests := []struct {
+		name   string
+		target string
+		status int
+	}{
+		{"secure route", "/secure/" + segment, http.StatusUnauthorized},
+		{"public route in query of secure route", "/secure/" + segment + "?a=/publicdata/" + segment, http.StatusUnauthorized},
+		{"public route", "/publicdata/" + segment, http.StatusOK},
+		{"prefix not at path start", "/other/publicdata/" + segment, http.StatusInternalServerError},
+		{"default prefix", "/gisproxy/" + segment + "?a=b", http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := serve(gp, httptest.NewRequest("GET", test.target, nil))
+			if recorder.Code != test.status {
+				t.Errorf("status %v, want %v", recorder.Code, test.status)
+			}
+		})
+	}
+}
+
+func TestRouteRemainingPathKeepsQuery(t *testing.T) {
+	gp := NewGisProxyHandler("/", false)
+	request := httptest.NewRequest("GET", "/"+encodeSegment("http://server/wms")+"/sub?SERVICE=WMS&a=/b", nil)
+	forwardUrl, forwardInfo, err := gp.resolveForward(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := forwardUrl.String(); got != "http://server/wms/sub?SERVICE=WMS&a=/b" {
+		t.Errorf("forward url %v", got)
+	}
+	if forwardInfo.RemainingPath != "/sub?SERVICE=WMS&a=/b" {
+		t.Errorf("remaining path %v", forwardInfo.RemainingPath)
+	}
+}
+
+func TestPrefixMetacharacters(t *testing.T) {
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		writer.Write([]byte("upstream"))
+	})
+	next := http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusTeapot)
+	})
+	segment := encodeSegment(upstream.URL)
+	tests := []struct {
+		name   string
+		prefix string
+		target string
+		status int
+	}{
+		{"dot", "/v1.0/", "/v1.0/" + segment, http.StatusOK},
+		{"dot matched literally", "/v1.0/", "/v1x0/" + segment, http.StatusTeapot},
+		{"plus", "/a+b/", "/a+b/" + segment, http.StatusOK},
+		{"plus matched literally", "/a+b/", "/aab/" + segment, http.StatusTeapot},
+		{"parenthesis", "/geo(proxy/", "/geo(proxy/" + segment, http.StatusOK},
+		{"parenthesis matched literally", "/geo(proxy/", "/geoproxy/" + segment, http.StatusTeapot},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, configure := range []func(gp *GisProxy){
+				func(gp *GisProxy) { gp.SetPrefix(test.prefix) },
+				func(gp *GisProxy) { gp.Prefix = test.prefix },
+				func(gp *GisProxy) { gp.AddRoute(test.prefix, RouteOptions{}) },
+			} {
+				gp := NewGisProxyHandler("/gisproxy/", false)
+				gp.SetNextHandler(next)
+				configure(gp)
+				if recorder := serve(gp, httptest.NewRequest("GET", test.target, nil)); recorder.Code != test.status {
+					t.Errorf("status %v, want %v", recorder.Code, test.status)
+				}
+			}
+		})
+	}
+}
+
+func TestSetPrefixWhileServing(t *testing.T) {
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {})
+	gp := NewGisProxyHandler("/gisproxy/", false)
+	segment := encodeSegment(upstream.URL)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				gp.ComputeForwardUrl(httptest.NewRequest("GET", "/gisproxy/"+segment, nil))
+			}
+		}()
+	}
+	for j := 0; j < 50; j++ {
+		gp.SetPrefix([]string{"/gisproxy/", "/other/"}[j%2])
+	}
+	wg.Wait()
+	gp.SetPrefix("/other/")
+	if _, err := gp.ComputeForwardUrl(httptest.NewRequest("GET", "/other/"+segment, nil)); err != nil {
+		t.Errorf("forward url error %v after prefix change", err)
+	}
+}
+
+func BenchmarkComputeForwardUrl(b *testing.B) {
+	gp := NewGisProxyHandler("/gisproxy/", false)
+	request := httptest.NewRequest("GET", "/gisproxy/"+encodeSegment("http://example.com/wms")+"?SERVICE=WMS", nil)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := gp.ComputeForwardUrl(request); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkCompilePrefix measures per request cost of compiling forward url regexp, as done before
+// prefix regexp was compiled once
+func BenchmarkCompilePrefix(b *testing.B) {
+	path := "/gisproxy/" + encodeSegment("http://example.com/wms")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		compilePrefix("/gisproxy/").FindStringSubmatch(path)
+	}
+}
+
+func TestPlainURLRoute(t *testing.T) {
+	var received string
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		received = request.URL.RequestURI()
+	})
+	upstreamHost := strings.TrimPrefix(upstream.URL, "http://")
+	tests := []struct {
+		name         string
+		allowedHosts []string
+		target       string
+		status       int
+		received     string
+	}{
+		{"literal url", []string{upstreamHost}, "/raw/" + upstream.URL + "/arcgis/rest/services?f=json", http.StatusOK, "/arcgis/rest/services?f=json"},
+		{"percent-encoded scheme", []string{upstreamHost}, "/raw/http%3A%2F%2F" + upstreamHost + "/wms?SERVICE=WMS", http.StatusOK, "/wms?SERVICE=WMS"},
+		{"collapsed slash", []string{upstreamHost}, "/raw/http:/" + upstreamHost + "/wms", http.StatusOK, "/wms"},
+		{"encoded query kept", []string{upstreamHost}, "/raw/" + upstream.URL + "/wms?LAYERS=a%2Cb&STYLES=", http.StatusOK, "/wms?LAYERS=a%2Cb&STYLES="},
+		{"host not allowed", []string{"maps.example.com"}, "/raw/" + upstream.URL + "/wms", http.StatusForbidden, ""},
+		{"no allowlist", nil, "/raw/" + upstream.URL + "/wms", http.StatusForbidden, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			received = ""
+			gp := NewGisProxyHandler("/gisproxy/", false)
+			gp.AddRoute("/raw/", RouteOptions{PlainURL: true, AllowedHosts: test.allowedHosts})
+			if recorder := serve(gp, httptest.NewRequest("GET", test.target, nil)); recorder.Code != test.status {
+				t.Errorf("status %v, want %v", recorder.Code, test.status)
+			}
+			if received != test.received {
+				t.Errorf("upstream received %q, want %q", received, test.received)
+			}
+		})
+	}
+}
@@ -0,0 +1,236 @@
+package lib
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// freeAddr returns a local address with a free port
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+// writeCertificate writes self-signed certificate and key files of 127.0.0.1 to dir
+func writeCertificate(t *testing.T, dir string) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "gisproxy test"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crtfile, keyfile := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+	if err := ioutil.WriteFile(crtfile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyfile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return crtfile, keyfile
+}
+
+func TestStartHTTPSInvalidCertificate(t *testing.T) {
+	addr := freeAddr(t)
+	gp := NewGisProxy(addr, "/gisproxy/", false)
+	gp.UseHttps(filepath.Join(t.TempDir(), "missing.crt"), filepath.Join(t.TempDir(), "missing.key"))
+	started := make(chan error, 1)
+	go func() {
+		started <- gp.Start()
+	}()
+	select {
+	case err := <-started:
+		if !os.IsNotExist(err) {
+			t.Errorf("start error %v, want certificate file not found", err)
+		}
+	case <-time.After(5 * time.Second):
+		gp.Stop(time.Second)
+		t.Fatal("start did not return TLS error")
+	}
+	if conn, err := net.Dial("tcp", addr); err == nil {
+		conn.Close()
+		t.Error("plaintext listener started after TLS error")
+	}
+}
+
+func TestStartHTTPSServesTLSOnly(t *testing.T) {
+	addr := freeAddr(t)
+	crtfile, keyfile := writeCertificate(t, t.TempDir())
+	gp := NewGisProxy(addr, "/gisproxy/", false)
+	gp.UseHttps(crtfile, keyfile)
+	gp.SetHealthCheckPath("/health")
+	started := make(chan error, 1)
+	go func() {
+		started <- gp.Start()
+	}()
+	defer func() {
+		gp.Stop(time.Second)
+		if err := <-started; err != http.ErrServerClosed {
+			t.Errorf("start error %v, want %v", err, http.ErrServerClosed)
+		}
+	}()
+	client := &http.Client{Timeout: 5 * time.Second, Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	var response *http.Response
+	var err error
+	for i := 0; i < 50; i++ {
+		if response, err = client.Get("https://" + addr + "/health"); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("https status %v, want %v", response.StatusCode, http.StatusOK)
+	}
+	response, err = client.Get("http://" + addr + "/health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusBadRequest {
+		t.Errorf("plaintext status %v, want %v (plaintext request to https server)", response.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestStopDrainsActiveRequests(t *testing.T) {
+	release := make(chan struct{})
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		<-release
+		writer.Write([]byte("tile"))
+	})
+	addr := freeAddr(t)
+	gp := NewGisProxy(addr, "/gisproxy/", false)
+	drained := make(chan int, 10)
+	gp.SetDrainCallback(func(remaining int) {
+		drained <- remaining
+	})
+	started := make(chan error, 1)
+	go func() {
+		started <- gp.Start()
+	}()
+	status := make(chan int, 1)
+	go func() {
+		for i := 0; i < 50; i++ {
+			response, err := http.Get("http://" + addr + "/gisproxy/" + encodeSegment(upstream.URL+"/tile"))
+			if err == nil {
+				response.Body.Close()
+				status <- response.StatusCode
+				return
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		status <- 0
+	}()
+	for i := 0; gp.ActiveRequests() != 1; i++ {
+		if i == 100 {
+			close(release)
+			t.Fatalf("%v active requests, want 1", gp.ActiveRequests())
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- gp.Stop(5 * time.Second)
+	}()
+	select {
+	case remaining := <-drained:
+		if remaining != 1 {
+			t.Errorf("drain callback remaining %v, want 1", remaining)
+		}
+	case <-time.After(3 * time.Second):
+		t.Error("drain callback not called")
+	}
+	close(release)
+	if err := <-stopped; err != nil {
+		t.Errorf("stop error %v", err)
+	}
+	if code := <-status; code != http.StatusOK {
+		t.Errorf("drained request status %v, want %v", code, http.StatusOK)
+	}
+	if active := gp.ActiveRequests(); active != 0 {
+		t.Errorf("%v active requests after stop, want 0", active)
+	}
+	if err := <-started; err != http.ErrServerClosed {
+		t.Errorf("start error %v, want %v", err, http.ErrServerClosed)
+	}
+}
+
+func TestHandlerWithoutServer(t *testing.T) {
+	gp := NewGisProxyHandler("/gisproxy/", false)
+	if err := gp.Start(); err == nil {
+		t.Error("start without server succeeded")
+	}
+	if err := gp.Stop(time.Second); err == nil {
+		t.Error("stop without server succeeded")
+	}
+	if err := gp.SetServerTimeouts(time.Second, time.Second, time.Second, time.Second); err == nil {
+		t.Error("server timeouts set without server")
+	}
+}
+
+func TestSetServerTimeouts(t *testing.T) {
+	addr := freeAddr(t)
+	gp := NewGisProxy(addr, "/gisproxy/", false)
+	if err := gp.SetServerTimeouts(time.Minute, 100*time.Millisecond, 0, 2*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if gp.server.ReadTimeout != time.Minute || gp.server.ReadHeaderTimeout != 100*time.Millisecond || gp.server.WriteTimeout != 0 || gp.server.IdleTimeout != 2*time.Minute {
+		t.Errorf("server timeouts %v %v %v %v", gp.server.ReadTimeout, gp.server.ReadHeaderTimeout, gp.server.WriteTimeout, gp.server.IdleTimeout)
+	}
+	go gp.Start()
+	defer gp.Stop(time.Second)
+	var conn net.Conn
+	var err error
+	for i := 0; i < 50; i++ {
+		if conn, err = net.Dial("tcp", addr); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// Incomplete request header is closed by server after read header timeout
+	if _, err := conn.Write([]byte("GET /gisproxy/ HTTP/1.1\r\nHost: localhost\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := ioutil.ReadAll(conn); err != nil {
+		t.Errorf("slow header connection not closed by server: %v", err)
+	}
+}
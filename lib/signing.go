@@ -0,0 +1,94 @@
+package lib
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// SetURLSigningKey requires proxy urls signed with key (see SignForwardURL): sig and exp query
+// parameters must hold a valid HMAC-SHA256 signature of escaped request path and canonical query
+// without sig (exp included), and a future unix expiry time, otherwise request is rejected with 403.
+// Path is signed as received by proxy, so that a front server must not rewrite it. Nil key disables
+// signature check.
+// Proxy urls of capabilities and redirect location rewrites are signed with expiry of incoming
+// request. TileJSON tiles url templates cannot be signed, tiles must then be requested with urls
+// signed by application.
+func (gp *GisProxy) SetURLSigningKey(key []byte) {
+	gp.urlSigningKey = key
+}
+
+// SignForwardURL returns proxy url of target url (base is proxy url including prefix) signed with
+// key and expiring at expires
+func SignForwardURL(key []byte, base string, target *url.URL, expires time.Time) (string, error) {
+	signed, err := url.Parse(encodeForwardUrl(base, target))
+	if err != nil {
+		return "", err
+	}
+	query, err := url.ParseQuery(signed.RawQuery)
+	if err != nil {
+		return "", err
+	}
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	query.Del("sig")
+	query.Set("exp", exp)
+	if signed.RawQuery != "" {
+		signed.RawQuery += "&"
+	}
+	signed.RawQuery += "exp=" + exp + "&sig=" + urlSignature(key, signed.EscapedPath(), query)
+	return signed.String(), nil
+}
+
+// urlSignature returns hex HMAC-SHA256 signature of escaped path and canonical query (sorted by
+// key, see url.Values.Encode)
+func urlSignature(key []byte, path string, query url.Values) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(path + "?" + query.Encode()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// checkURLSignature checks signature of incoming request and removes sig and exp parameters
+// from forward url
+func (gp *GisProxy) checkURLSignature(incomingRequest *http.Request, forwardUrl *url.URL) error {
+	if gp.urlSigningKey == nil {
+		return nil
+	}
+	removeQueryParam(forwardUrl, "sig")
+	removeQueryParam(forwardUrl, "exp")
+	// Malformed parameters would be forwarded without being signed
+	query, err := url.ParseQuery(incomingRequest.URL.RawQuery)
+	if err != nil {
+		return NewStatusError("Invalid url signature", http.StatusForbidden)
+	}
+	sig, exp := query.Get("sig"), query.Get("exp")
+	if sig == "" || exp == "" {
+		return NewStatusError("Missing url signature", http.StatusForbidden)
+	}
+	expires, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return NewStatusError("Expired url signature", http.StatusForbidden)
+	}
+	query.Del("sig")
+	if !hmac.Equal([]byte(sig), []byte(urlSignature(gp.urlSigningKey, incomingRequest.URL.EscapedPath(), query))) {
+		return NewStatusError("Invalid url signature", http.StatusForbidden)
+	}
+	return nil
+}
+
+// proxyURL returns proxy url of target url rewritten in response to incoming request, signed with
+// expiry of incoming request when url signing is enabled, so that it does not outlive it
+func (gp *GisProxy) proxyURL(incomingRequest *http.Request, base string, target *url.URL) string {
+	if gp.urlSigningKey == nil {
+		return encodeForwardUrl(base, target)
+	}
+	expires, _ := strconv.ParseInt(incomingRequest.URL.Query().Get("exp"), 10, 64)
+	signed, err := SignForwardURL(gp.urlSigningKey, base, target, time.Unix(expires, 0))
+	if err != nil {
+		return encodeForwardUrl(base, target)
+	}
+	return signed
+}
@@ -0,0 +1,121 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestURLSignature(t *testing.T) {
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		writer.Write([]byte(request.URL.String()))
+	})
+	key := []byte("secret")
+	target, err := url.Parse(upstream.URL + "/wms?SERVICE=WMS&LAYERS=public")
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := SignForwardURL(key, "/gisproxy/", target, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := SignForwardURL(key, "/gisproxy/", target, time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := SignForwardURL([]byte("other"), "/gisproxy/", target, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		target string
+		status int
+	}{
+		{"signed", signed, http.StatusOK},
+		{"reordered query", strings.Replace(signed, "SERVICE=WMS&LAYERS=public", "LAYERS=public&SERVICE=WMS", 1), http.StatusOK},
+		{"unsigned", encodeForwardUrl("/gisproxy/", target), http.StatusForbidden},
+		{"expired", expired, http.StatusForbidden},
+		{"other key", otherKey, http.StatusForbidden},
+		{"changed query", strings.Replace(signed, "LAYERS=public", "LAYERS=secret", 1), http.StatusForbidden},
+		{"added query", signed + "&LAYERS=secret", http.StatusForbidden},
+		{"malformed query", signed + "&LAYERS=%zz", http.StatusForbidden},
+		{"appended path", strings.Replace(signed, "?", "/admin?", 1), http.StatusForbidden},
+	}
+	gp := NewGisProxyHandler("/gisproxy/", false)
+	gp.SetURLSigningKey(key)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := serve(gp, httptest.NewRequest("GET", test.target, nil))
+			if recorder.Code != test.status {
+				t.Errorf("status %v, want %v", recorder.Code, test.status)
+			}
+			if recorder.Code == http.StatusOK && (strings.Contains(recorder.Body.String(), "sig=") || strings.Contains(recorder.Body.String(), "exp=")) {
+				t.Errorf("signature forwarded upstream %v", recorder.Body.String())
+			}
+		})
+	}
+}
+
+func TestURLSignatureRewrites(t *testing.T) {
+	var upstreamURL string
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		switch request.URL.Path {
+		case "/redirect":
+			http.Redirect(writer, request, "/wms?SERVICE=WMS&REQUEST=GetMap", http.StatusMovedPermanently)
+		case "/wms":
+			if request.URL.Query().Get("REQUEST") == "GetCapabilities" {
+				writer.Header().Set("Content-Type", "application/vnd.ogc.wms_xml")
+				writer.Write([]byte(`<OnlineResource xlink:href="` + upstreamURL + `/wms?SERVICE=WMS&amp;REQUEST=GetMap"/>`))
+				return
+			}
+			writer.Write([]byte("map"))
+		}
+	})
+	upstreamURL = upstream.URL
+	key := []byte("secret")
+	gp := NewGisProxyHandler("/gisproxy/", false)
+	gp.SetURLSigningKey(key)
+	gp.SetCapabilitiesRewrite(true, "")
+	gp.SetRewriteRedirectLocation("")
+	sign := func(rawURL string) string {
+		target, err := url.Parse(rawURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signed, err := SignForwardURL(key, "http://proxy.local/gisproxy/", target, time.Now().Add(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	tests := []struct {
+		name    string
+		target  string
+		rewrite func(recorder *httptest.ResponseRecorder) string
+	}{
+		{"capabilities", sign(upstream.URL + "/wms?SERVICE=WMS&REQUEST=GetCapabilities"), func(recorder *httptest.ResponseRecorder) string {
+			body := recorder.Body.String()
+			start := strings.Index(body, `href="`) + len(`href="`)
+			return strings.Replace(body[start:start+strings.Index(body[start:], `"`)], "&amp;", "&", -1)
+		}},
+		{"redirect location", sign(upstream.URL + "/redirect"), func(recorder *httptest.ResponseRecorder) string {
+			return recorder.Header().Get("Location")
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := serve(gp, httptest.NewRequest("GET", test.target, nil))
+			rewritten := test.rewrite(recorder)
+			if !strings.Contains(rewritten, "sig=") || !strings.Contains(rewritten, "exp=") {
+				t.Fatalf("rewritten url %q not signed", rewritten)
+			}
+			if recorder := serve(gp, httptest.NewRequest("GET", rewritten, nil)); recorder.Code != http.StatusOK || recorder.Body.String() != "map" {
+				t.Errorf("rewritten url status %v, want %v", recorder.Code, http.StatusOK)
+			}
+		})
+	}
+}
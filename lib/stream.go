@@ -0,0 +1,69 @@
+package lib
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// SetStreamingOnly forces streaming of upstream responses: body is copied as received and flushed
+// to client after each read, without body rewrite or compression. This lowers latency and memory
+// of large responses (ImageServer exportImage, WFS GetFeature...) at the cost of the bandwidth
+// saved by compression. An error is returned if a body transform is configured.
+func (gp *GisProxy) SetStreamingOnly(streamingOnly bool) error {
+	if streamingOnly && (gp.bodyRewriteFunc != nil || gp.capabilitiesRewrite || gp.geoJSONBBoxFilter || gp.compression) {
+		return errors.New("streaming only is incompatible with body rewrite, capabilities rewrite, GeoJSON bbox filter and compression")
+	}
+	gp.streamingOnly = streamingOnly
+	return nil
+}
+
+// flushWriter flushes after each write
+type flushWriter struct {
+	writer  io.Writer
+	flusher http.Flusher
+}
+
+// Write writes and flushes
+func (fw *flushWriter) Write(p []byte) (int, error) {
+	n, err := fw.writer.Write(p)
+	fw.flusher.Flush()
+	return n, err
+}
+
+// streamingWriter returns writer flushing after each write when supported
+func streamingWriter(writer http.ResponseWriter) io.Writer {
+	if flusher, ok := writer.(http.Flusher); ok {
+		return &flushWriter{writer: writer, flusher: flusher}
+	}
+	return writer
+}
+
+// applyContentLength sets Content-Length header from body length computed by http client framing
+// (or by body rewrite), upstream header may not match decoded body. Header is removed when length
+// is unknown, body is then chunked.
+func applyContentLength(response *http.Response) {
+	if response.ContentLength >= 0 {
+		response.Header.Set("Content-Length", strconv.FormatInt(response.ContentLength, 10))
+	} else {
+		response.Header.Del("Content-Length")
+	}
+}
+
+// isEventStream checks if response is a Server-Sent Events stream, streamed without body rewrite
+// or compression so that each event is sent to client as soon as received
+func isEventStream(header http.Header) bool {
+	return strings.HasPrefix(strings.ToLower(header.Get("Content-Type")), "text/event-stream")
+}
+
+// acceptsEventStream checks if request asks for a Server-Sent Events stream
+func acceptsEventStream(request *http.Request) bool {
+	for _, value := range request.Header["Accept"] {
+		if strings.Contains(strings.ToLower(value), "text/event-stream") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,262 @@
+package lib
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// cancellingWriter cancels request context once first bytes are written, as a disconnecting client
+type cancellingWriter struct {
+	*httptest.ResponseRecorder
+	cancel context.CancelFunc
+}
+
+func (cw *cancellingWriter) Write(b []byte) (int, error) {
+	defer cw.cancel()
+	return cw.ResponseRecorder.Write(b)
+}
+
+func TestClientDisconnectStopsUpstreamRead(t *testing.T) {
+	upstreamDone := make(chan struct{})
+	chunk := strings.Repeat("x", 1024)
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		defer close(upstreamDone)
+		for i := 0; i < 10000; i++ {
+			if _, err := writer.Write([]byte(chunk)); err != nil {
+				return
+			}
+			writer.(http.Flusher).Flush()
+			select {
+			case <-request.Context().Done():
+				return
+			case <-time.After(time.Millisecond):
+			}
+		}
+		t.Error("upstream response copied to the end")
+	})
+	gp := NewGisProxyHandler("/gisproxy/", false)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	writer := &cancellingWriter{ResponseRecorder: httptest.NewRecorder(), cancel: cancel}
+	request := httptest.NewRequest("GET", "/gisproxy/"+encodeSegment(upstream.URL+"/large.tif"), nil).WithContext(ctx)
+	served := make(chan struct{})
+	go func() {
+		gp.ServeHTTP(writer, request)
+		close(served)
+	}()
+	select {
+	case <-served:
+	case <-time.After(5 * time.Second):
+		t.Fatal("copy not aborted after client disconnect")
+	}
+	select {
+	case <-upstreamDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("upstream request not cancelled after client disconnect")
+	}
+	if writer.Body.Len() >= 10000*len(chunk) {
+		t.Errorf("%v bytes copied after client disconnect", writer.Body.Len())
+	}
+}
+
+func TestEventStreamIncremental(t *testing.T) {
+	next := make(chan struct{})
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Type", "text/event-stream")
+		writer.Write([]byte("data: first\n\n"))
+		writer.(http.Flusher).Flush()
+		<-next
+		writer.Write([]byte("data: second\n\n"))
+	})
+	gp := NewGisProxyHandler("/gisproxy/", false)
+	gp.SetCompression(true, 0)
+	gp.SetBodyRewriteFunc(func(ctx context.Context, info *GisInfo, contentType string, body []byte) ([]byte, error) {
+		return body, nil
+	}, []string{"text/"})
+	proxy := httptest.NewServer(gp)
+	defer proxy.Close()
+	request, err := http.NewRequest("GET", proxy.URL+"/gisproxy/"+encodeSegment(upstream.URL+"/events"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set("Accept-Encoding", "gzip")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		close(next)
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if encoding := response.Header.Get("Content-Encoding"); encoding != "" {
+		t.Errorf("Content-Encoding %q, want none", encoding)
+	}
+	reader := bufio.NewReader(response.Body)
+	received := make(chan string)
+	go func() {
+		line, _ := reader.ReadString('\n')
+		received <- line
+	}()
+	select {
+	case line := <-received:
+		if line != "data: first\n" {
+			t.Errorf("first event %q, want %q", line, "data: first\n")
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("first event not received before upstream end")
+	}
+	close(next)
+	rest, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(rest) != "\ndata: second\n\n" {
+		t.Errorf("remaining events %q, want %q", rest, "\ndata: second\n\n")
+	}
+}
+
+func TestEncodedContentLength(t *testing.T) {
+	body := strings.Repeat(`{"type":"Feature"}`, 500)
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Type", "application/json")
+		if !strings.Contains(request.Header.Get("Accept-Encoding"), "gzip") {
+			writer.Write([]byte(body))
+			return
+		}
+		// Content-Length of encoded body does not match decoded body length
+		encoded := encodeTestBody(t, "gzip", body)
+		writer.Header().Set("Content-Encoding", "gzip")
+		writer.Header().Set("Content-Length", strconv.Itoa(len(encoded)))
+		writer.Write(encoded)
+	})
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		rewrite        bool
+		encoding       string
+	}{
+		{"decoded by transport", "", false, ""},
+		{"decoded by transport and rewritten", "", true, ""},
+		{"decoded and rewritten", "gzip", true, ""},
+		{"encoded passthrough", "gzip", false, "gzip"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gp := NewGisProxyHandler("/gisproxy/", false)
+			if test.rewrite {
+				gp.SetBodyRewriteFunc(func(ctx context.Context, info *GisInfo, contentType string, body []byte) ([]byte, error) {
+					return body, nil
+				}, nil)
+			}
+			proxy := httptest.NewServer(gp)
+			defer proxy.Close()
+			request, err := http.NewRequest("GET", proxy.URL+"/gisproxy/"+encodeSegment(upstream.URL+"/features"), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.acceptEncoding != "" {
+				request.Header.Set("Accept-Encoding", test.acceptEncoding)
+			}
+			// Keep received encoding and framing as sent by proxy
+			client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+			defer client.CloseIdleConnections()
+			response, err := client.Do(request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			received := readBody(t, response)
+			if encoding := response.Header.Get("Content-Encoding"); encoding != test.encoding {
+				t.Fatalf("Content-Encoding %q, want %q", encoding, test.encoding)
+			}
+			if length := response.Header.Get("Content-Length"); length != "" && length != strconv.Itoa(len(received)) {
+				t.Errorf("Content-Length %v, %v bytes received", length, len(received))
+			}
+			if test.encoding == "gzip" {
+				decoded, err := decodeBody("gzip", []byte(received), maxBufferedBodyBytes)
+				if err != nil {
+					t.Fatal(err)
+				}
+				received = string(decoded)
+			}
+			if received != body {
+				t.Errorf("%v bytes received, want %v", len(received), len(body))
+			}
+		})
+	}
+}
+
+func TestSetStreamingOnlyIncompatible(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(gp *GisProxy)
+	}{
+		{"body rewrite", func(gp *GisProxy) {
+			gp.SetBodyRewriteFunc(func(ctx context.Context, info *GisInfo, contentType string, body []byte) ([]byte, error) {
+				return body, nil
+			}, nil)
+		}},
+		{"capabilities rewrite", func(gp *GisProxy) { gp.SetCapabilitiesRewrite(true, "") }},
+		{"compression", func(gp *GisProxy) { gp.SetCompression(true, 1024) }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gp := NewGisProxyHandler("/gisproxy/", false)
+			test.configure(gp)
+			if err := gp.SetStreamingOnly(true); err == nil {
+				t.Error("streaming only accepted with body transform")
+			}
+			if err := gp.SetStreamingOnly(false); err != nil {
+				t.Errorf("streaming disabled error %v", err)
+			}
+		})
+	}
+}
+
+func TestStreamingOnlyFlushes(t *testing.T) {
+	release := make(chan struct{})
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Type", "image/png")
+		writer.Write([]byte("first"))
+		writer.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-time.After(5 * time.Second):
+		}
+		writer.Write([]byte("second"))
+	})
+	gp := NewGisProxyHandler("/gisproxy/", false)
+	if err := gp.SetStreamingOnly(true); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(gp)
+	t.Cleanup(server.Close)
+	response, err := http.Get(server.URL + "/gisproxy/" + encodeSegment(upstream.URL+"/arcgis/rest/services/dem/ImageServer/exportImage"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	first := make([]byte, len("first"))
+	read := make(chan error, 1)
+	go func() {
+		_, err := io.ReadFull(response.Body, first)
+		read <- err
+	}()
+	select {
+	case err := <-read:
+		if err != nil || string(first) != "first" {
+			t.Errorf("first chunk %q (%v)", first, err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("first chunk not streamed before upstream completed")
+	}
+	close(release)
+	if rest, _ := ioutil.ReadAll(response.Body); string(rest) != "second" {
+		t.Errorf("rest %q, want %q", rest, "second")
+	}
+}
@@ -0,0 +1,100 @@
+package lib
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// TileJSON structure (TileJSON 2.2.0 specification), Tiles are origin url templates
+type TileJSON struct {
+	TileJSON    string    `json:"tilejson"`
+	Name        string    `json:"name,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Version     string    `json:"version,omitempty"`
+	Attribution string    `json:"attribution,omitempty"`
+	Scheme      string    `json:"scheme,omitempty"`
+	Tiles       []string  `json:"tiles"`
+	MinZoom     int       `json:"minzoom"`
+	MaxZoom     int       `json:"maxzoom"`
+	Bounds      []float64 `json:"bounds,omitempty"`
+	Center      []float64 `json:"center,omitempty"`
+}
+
+// RegisterTileJSON serves TileJSON document on path, with tiles url templates pointing through proxy.
+// Templates are not signed (see SetURLSigningKey), TileJSON is not usable with url signing.
+func (gp *GisProxy) RegisterTileJSON(path string, tj TileJSON) error {
+	if tj.TileJSON == "" {
+		tj.TileJSON = "2.2.0"
+	}
+	if err := validateTileJSON(&tj); err != nil {
+		return err
+	}
+	gp.handle(path, http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		document := tj
+		document.Tiles = make([]string, 0, len(tj.Tiles))
+		base := gp.proxyBaseURL(request)
+		if !strings.HasSuffix(base, "/") {
+			base += "/"
+		}
+		for _, tile := range tj.Tiles {
+			origin, remaining := splitTileTemplate(tile)
+			document.Tiles = append(document.Tiles, base+base64.URLEncoding.EncodeToString([]byte(origin))+remaining)
+		}
+		gp.writeCORSHeader(writer, request)
+		writer.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(writer)
+		encoder.SetEscapeHTML(false)
+		encoder.Encode(&document)
+	}))
+	return nil
+}
+
+// validateTileJSON checks TileJSON fields
+func validateTileJSON(tj *TileJSON) error {
+	if len(tj.Tiles) == 0 {
+		return errors.New("TileJSON tiles must not be empty")
+	}
+	for _, tile := range tj.Tiles {
+		u, err := url.Parse(tile)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.New("TileJSON tile " + tile + " must be an absolute http(s) url")
+		}
+		if strings.Contains(u.Host, "{") {
+			return errors.New("TileJSON tile " + tile + " must not have a host template")
+		}
+		for _, placeholder := range []string{"{z}", "{x}", "{y}"} {
+			if !strings.Contains(tile, placeholder) {
+				return errors.New("TileJSON tile " + tile + " must contain " + placeholder)
+			}
+		}
+	}
+	if tj.Scheme != "" && tj.Scheme != "xyz" && tj.Scheme != "tms" {
+		return errors.New("TileJSON scheme must be xyz or tms")
+	}
+	if tj.MinZoom < 0 || tj.MaxZoom > 30 || tj.MinZoom > tj.MaxZoom {
+		return errors.New("TileJSON zoom levels must satisfy 0 <= minzoom <= maxzoom <= 30")
+	}
+	if tj.Bounds != nil {
+		if len(tj.Bounds) != 4 || tj.Bounds[0] < -180 || tj.Bounds[1] < -90 || tj.Bounds[2] > 180 || tj.Bounds[3] > 90 || tj.Bounds[0] > tj.Bounds[2] || tj.Bounds[1] > tj.Bounds[3] {
+			return errors.New("TileJSON bounds must be west, south, east, north in degrees")
+		}
+	}
+	if tj.Center != nil && len(tj.Center) != 3 {
+		return errors.New("TileJSON center must be longitude, latitude, zoom")
+	}
+	return nil
+}
+
+// splitTileTemplate splits tile url template into origin url and path from first placeholder segment
+func splitTileTemplate(tile string) (string, string) {
+	placeholder := strings.Index(tile, "{")
+	if query := strings.Index(tile, "?"); query != -1 && query < placeholder {
+		placeholder = query
+	}
+	cut := strings.LastIndex(tile[:placeholder], "/")
+	return tile[:cut], tile[cut:]
+}
@@ -0,0 +1,72 @@
+package lib
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegisterTileJSON(t *testing.T) {
+	var received string
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		received = request.URL.RequestURI()
+	})
+	gp := NewGisProxyHandler("/gisproxy/", false)
+	err := gp.RegisterTileJSON("/tiles.json", TileJSON{
+		Name:    "roads",
+		Tiles:   []string{upstream.URL + "/tiles/roads/{z}/{x}/{y}.png?key=abc"},
+		MinZoom: 0,
+		MaxZoom: 18,
+		Bounds:  []float64{-180, -85, 180, 85},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := serve(gp, httptest.NewRequest("GET", "/tiles.json", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status %v, want %v", recorder.Code, http.StatusOK)
+	}
+	var document TileJSON
+	if err := json.NewDecoder(recorder.Body).Decode(&document); err != nil {
+		t.Fatal(err)
+	}
+	want := "http://example.com/gisproxy/" + encodeSegment(upstream.URL+"/tiles/roads") + "/{z}/{x}/{y}.png?key=abc"
+	if document.TileJSON != "2.2.0" || document.Name != "roads" || len(document.Tiles) != 1 || document.Tiles[0] != want {
+		t.Fatalf("document %+v, want tiles [%v]", document, want)
+	}
+	tile := strings.NewReplacer("{z}", "3", "{x}", "4", "{y}", "2").Replace(document.Tiles[0])
+	if recorder := serve(gp, httptest.NewRequest("GET", tile, nil)); recorder.Code != http.StatusOK {
+		t.Fatalf("tile status %v, want %v", recorder.Code, http.StatusOK)
+	}
+	if received != "/tiles/roads/3/4/2.png?key=abc" {
+		t.Errorf("upstream received %q, want %q", received, "/tiles/roads/3/4/2.png?key=abc")
+	}
+}
+
+func TestRegisterTileJSONInvalid(t *testing.T) {
+	const tile = "https://tile.example.com/{z}/{x}/{y}.png"
+	tests := []struct {
+		name string
+		tj   TileJSON
+	}{
+		{"no tiles", TileJSON{MaxZoom: 18}},
+		{"relative tile", TileJSON{Tiles: []string{"/{z}/{x}/{y}.png"}, MaxZoom: 18}},
+		{"host template", TileJSON{Tiles: []string{"https://{s}.tile.example.com/{z}/{x}/{y}.png"}, MaxZoom: 18}},
+		{"missing placeholder", TileJSON{Tiles: []string{"https://tile.example.com/{z}/{x}.png"}, MaxZoom: 18}},
+		{"unknown scheme", TileJSON{Tiles: []string{tile}, Scheme: "wmts", MaxZoom: 18}},
+		{"zoom order", TileJSON{Tiles: []string{tile}, MinZoom: 10, MaxZoom: 5}},
+		{"max zoom", TileJSON{Tiles: []string{tile}, MaxZoom: 31}},
+		{"bounds", TileJSON{Tiles: []string{tile}, MaxZoom: 18, Bounds: []float64{10, 0, -10, 5}}},
+		{"center", TileJSON{Tiles: []string{tile}, MaxZoom: 18, Center: []float64{0, 0}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gp := NewGisProxyHandler("/gisproxy/", false)
+			if err := gp.RegisterTileJSON("/tiles.json", test.tj); err == nil {
+				t.Error("invalid TileJSON registered")
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package lib
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRequestTimeout(t *testing.T) {
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		delay, _ := time.ParseDuration(request.URL.Query().Get("delay"))
+		select {
+		case <-time.After(delay):
+			writer.Write([]byte("ok"))
+		case <-request.Context().Done():
+		}
+	})
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		delay   string
+		status  int
+	}{
+		{"no timeout", 0, "100ms", http.StatusOK},
+		{"fast upstream", time.Second, "0s", http.StatusOK},
+		{"slow upstream", 50 * time.Millisecond, "2s", http.StatusGatewayTimeout},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gp := NewGisProxyHandler("/gisproxy/", false)
+			gp.SetRequestTimeout(test.timeout)
+			request := httptest.NewRequest("GET", "/gisproxy/"+encodeSegment(upstream.URL+"/tile?delay="+test.delay), nil)
+			start := time.Now()
+			if recorder := serve(gp, request); recorder.Code != test.status {
+				t.Errorf("status %v, want %v", recorder.Code, test.status)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("request took %v", elapsed)
+			}
+		})
+	}
+}
+
+func TestRequestTimeoutClientCancel(t *testing.T) {
+	cancelled := make(chan struct{})
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		select {
+		case <-request.Context().Done():
+			close(cancelled)
+		case <-time.After(5 * time.Second):
+		}
+	})
+	gp := NewGisProxyHandler("/gisproxy/", false)
+	gp.SetRequestTimeout(time.Minute)
+	ctx, cancel := context.WithCancel(context.Background())
+	request := httptest.NewRequest("GET", "/gisproxy/"+encodeSegment(upstream.URL+"/tile"), nil).WithContext(ctx)
+	time.AfterFunc(50*time.Millisecond, cancel)
+	serve(gp, request)
+	select {
+	case <-cancelled:
+	case <-time.After(2 * time.Second):
+		t.Error("client cancellation not propagated upstream")
+	}
+}
+
+func TestTimeoutHeader(t *testing.T) {
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		delay, _ := time.ParseDuration(request.URL.Query().Get("delay"))
+		select {
+		case <-time.After(delay):
+			writer.Write([]byte("ok"))
+		case <-request.Context().Done():
+		}
+	})
+	tests := []struct {
+		name       string
+		header     string
+		value      string
+		maxTimeout time.Duration
+		delay      string
+		status     int
+	}{
+		{"header extends timeout", "X-Proxy-Timeout", "1s", 0, "100ms", http.StatusOK},
+		{"header bounded by max timeout", "X-Proxy-Timeout", "10s", 50 * time.Millisecond, "2s", http.StatusGatewayTimeout},
+		{"invalid value", "X-Proxy-Timeout", "soon", 0, "0s", http.StatusBadRequest},
+		{"negative value", "X-Proxy-Timeout", "-1s", 0, "0s", http.StatusBadRequest},
+		{"header not trusted", "", "1s", 0, "100ms", http.StatusGatewayTimeout},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gp := NewGisProxyHandler("/gisproxy/", false)
+			gp.SetRequestTimeout(50 * time.Millisecond)
+			gp.SetTimeoutHeader(test.header)
+			gp.SetMaxTimeout(test.maxTimeout)
+			request := httptest.NewRequest("GET", "/gisproxy/"+encodeSegment(upstream.URL+"/tile?delay="+test.delay), nil)
+			request.Header.Set("X-Proxy-Timeout", test.value)
+			if recorder := serve(gp, request); recorder.Code != test.status {
+				t.Errorf("status %v, want %v", recorder.Code, test.status)
+			}
+		})
+	}
+}
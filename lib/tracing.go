@@ -0,0 +1,95 @@
+package lib
+
+import (
+	"context"
+	"net/http"
+)
+
+// Span defines tracing span of a proxied request
+type Span interface {
+	SetAttribute(key string, value interface{})
+	AddEvent(name string)
+	End()
+}
+
+// Tracer defines distributed tracing, usually an adapter to an OpenTelemetry TracerProvider
+// (tracer Start, span SetAttributes with attribute.Any, propagation.TraceContext Extract and Inject)
+type Tracer interface {
+	// Extract returns ctx with trace context read from incoming request header, so that span is a
+	// child of client span
+	Extract(ctx context.Context, header http.Header) context.Context
+	// Start starts span, returned context holds span
+	Start(ctx context.Context, name string) (context.Context, Span)
+	// Inject writes trace context of ctx to header (W3C traceparent and tracestate)
+	Inject(ctx context.Context, header http.Header)
+}
+
+// TracerProvider defines provider of named tracers, usually an adapter to an OpenTelemetry
+// trace.TracerProvider
+type TracerProvider interface {
+	// Tracer returns tracer of instrumentation name
+	Tracer(name string) Tracer
+}
+
+// tracerName is instrumentation name of tracer requested to TracerProvider
+const tracerName = "github.com/aptogeo/gisproxy"
+
+// SetTracerProvider sets provider of tracer starting a span per upstream request (see SetTracer),
+// tracer is requested with "github.com/aptogeo/gisproxy" instrumentation name. Nil provider
+// disables tracing.
+//
+// TracerProvider and Tracer interfaces rather than OpenTelemetry ones are taken so that module
+// does not depend on OpenTelemetry (which requires a newer Go version), an adapter is a few lines.
+func (gp *GisProxy) SetTracerProvider(tracerProvider TracerProvider) {
+	if tracerProvider == nil {
+		gp.tracer = nil
+		return
+	}
+	gp.tracer = tracerProvider.Tracer(tracerName)
+}
+
+// SetTracer sets tracer starting a span per upstream request, with GisInfo and HTTP status
+// attributes, cache hit and retry events. Trace context of incoming request is continued and
+// propagated to upstream. Nil tracer disables tracing.
+func (gp *GisProxy) SetTracer(tracer Tracer) {
+	gp.tracer = tracer
+}
+
+// startSpan starts upstream request span continuing trace context of incoming header, nil if
+// tracing is disabled
+func (gp *GisProxy) startSpan(ctx context.Context, header http.Header) (context.Context, Span) {
+	if gp.tracer == nil {
+		return ctx, nil
+	}
+	ctx, span := gp.tracer.Start(gp.tracer.Extract(ctx, header), "gisproxy.forward")
+	ctx = context.WithValue(ctx, contextKey("Span"), span)
+	if info := GisInfoFromContext(ctx); info != nil {
+		span.SetAttribute("gis.server_type", info.ServerType)
+		span.SetAttribute("gis.service_type", info.ServiceType)
+		span.SetAttribute("gis.service_name", info.ServiceName)
+	}
+	return ctx, span
+}
+
+// addSpanEvent adds event to context span
+func addSpanEvent(ctx context.Context, name string) {
+	if span, ok := ctx.Value(contextKey("Span")).(Span); ok {
+		span.AddEvent(name)
+	}
+}
+
+// endSpan sets request attributes and ends span
+func endSpan(span Span, request *http.Request, response *http.Response, err error) {
+	if span == nil {
+		return
+	}
+	span.SetAttribute("http.method", request.Method)
+	span.SetAttribute("http.url", redactURL(request.URL))
+	if response != nil {
+		span.SetAttribute("http.status_code", response.StatusCode)
+	}
+	if err != nil {
+		span.SetAttribute("error", err.Error())
+	}
+	span.End()
+}
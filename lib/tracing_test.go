@@ -0,0 +1,157 @@
+package lib
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testSpan structure, span recording attributes and events
+type testSpan struct {
+	parent     string
+	attributes map[string]interface{}
+	events     []string
+	ended      bool
+}
+
+func (ts *testSpan) SetAttribute(key string, value interface{}) { ts.attributes[key] = value }
+func (ts *testSpan) AddEvent(name string)                       { ts.events = append(ts.events, name) }
+func (ts *testSpan) End()                                       { ts.ended = true }
+
+// testTracer structure, tracer propagating trace id in traceparent header
+type testTracer struct {
+	mutex sync.Mutex
+	spans []*testSpan
+}
+
+func (tt *testTracer) Extract(ctx context.Context, header http.Header) context.Context {
+	return context.WithValue(ctx, contextKey("TestTrace"), header.Get("Traceparent"))
+}
+
+func (tt *testTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	parent, _ := ctx.Value(contextKey("TestTrace")).(string)
+	span := &testSpan{parent: parent, attributes: make(map[string]interface{})}
+	tt.mutex.Lock()
+	tt.spans = append(tt.spans, span)
+	tt.mutex.Unlock()
+	return context.WithValue(ctx, contextKey("TestTrace"), "child-of-"+parent), span
+}
+
+func (tt *testTracer) Inject(ctx context.Context, header http.Header) {
+	trace, _ := ctx.Value(contextKey("TestTrace")).(string)
+	header.Set("Traceparent", trace)
+}
+
+func TestTracing(t *testing.T) {
+	var traceparent string
+	var attempts int
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		traceparent = request.Header.Get("Traceparent")
+		attempts++
+		if request.URL.Path == "/flaky" && attempts == 1 {
+			http.Error(writer, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		writer.Write([]byte("capabilities"))
+	})
+	tests := []struct {
+		name     string
+		path     string
+		incoming string
+		parent   string
+		events   []string
+	}{
+		{"no incoming trace", "/wms?SERVICE=WMS&REQUEST=GetCapabilities", "", "", nil},
+		{"continued trace", "/wms?SERVICE=WMS&REQUEST=GetCapabilities", "00-trace-parent-01", "00-trace-parent-01", nil},
+		{"retry event", "/flaky", "", "", []string{"retry"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			attempts = 0
+			tracer := &testTracer{}
+			gp := NewGisProxyHandler("/gisproxy/", false)
+			gp.SetTracer(tracer)
+			gp.SetRetryPolicy(1, time.Millisecond)
+			request := httptest.NewRequest("GET", "/gisproxy/"+encodeSegment(upstream.URL+test.path), nil)
+			if test.incoming != "" {
+				request.Header.Set("Traceparent", test.incoming)
+			}
+			if recorder := serve(gp, request); recorder.Code != http.StatusOK {
+				t.Fatalf("status %v, want %v", recorder.Code, http.StatusOK)
+			}
+			if len(tracer.spans) != 1 {
+				t.Fatalf("%v spans, want 1", len(tracer.spans))
+			}
+			span := tracer.spans[0]
+			if span.parent != test.parent || !span.ended {
+				t.Errorf("span parent %q ended %v, want parent %q ended", span.parent, span.ended, test.parent)
+			}
+			if traceparent != "child-of-"+test.parent {
+				t.Errorf("upstream traceparent %q, want %q", traceparent, "child-of-"+test.parent)
+			}
+			if status := span.attributes["http.status_code"]; status != http.StatusOK {
+				t.Errorf("span status %v, want %v", status, http.StatusOK)
+			}
+			if len(span.events) != len(test.events) || (len(test.events) > 0 && span.events[0] != test.events[0]) {
+				t.Errorf("span events %v, want %v", span.events, test.events)
+			}
+		})
+	}
+}
+
+func TestTracingCacheHitEvent(t *testing.T) {
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		writer.Write([]byte("tile"))
+	})
+	tracer := &testTracer{}
+	gp := NewGisProxyHandler("/gisproxy/", false)
+	gp.SetTracer(tracer)
+	gp.UseCache(10, time.Minute)
+	for i := 0; i < 2; i++ {
+		serve(gp, httptest.NewRequest("GET", "/gisproxy/"+encodeSegment(upstream.URL+"/tile"), nil))
+	}
+	if len(tracer.spans) != 2 {
+		t.Fatalf("%v spans, want 2", len(tracer.spans))
+	}
+	if events := tracer.spans[1].events; len(events) != 1 || events[0] != "cache hit" {
+		t.Errorf("span events %v, want [cache hit]", events)
+	}
+	if serverType := tracer.spans[0].attributes["gis.server_type"]; serverType == nil {
+		t.Error("missing GisInfo span attribute")
+	}
+}
+
+// testTracerProvider structure, provider recording requested tracer names
+type testTracerProvider struct {
+	tracer *testTracer
+	names  []string
+}
+
+func (ttp *testTracerProvider) Tracer(name string) Tracer {
+	ttp.names = append(ttp.names, name)
+	return ttp.tracer
+}
+
+func TestTracerProvider(t *testing.T) {
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		writer.Write([]byte("tile"))
+	})
+	provider := &testTracerProvider{tracer: &testTracer{}}
+	gp := NewGisProxyHandler("/gisproxy/", false)
+	gp.SetTracerProvider(provider)
+	if len(provider.names) != 1 || provider.names[0] != "github.com/aptogeo/gisproxy" {
+		t.Errorf("tracer names %v, want [github.com/aptogeo/gisproxy]", provider.names)
+	}
+	serve(gp, httptest.NewRequest("GET", "/gisproxy/"+encodeSegment(upstream.URL+"/tile"), nil))
+	if len(provider.tracer.spans) != 1 || !provider.tracer.spans[0].ended {
+		t.Fatalf("%v spans, want 1 ended", len(provider.tracer.spans))
+	}
+	gp.SetTracerProvider(nil)
+	serve(gp, httptest.NewRequest("GET", "/gisproxy/"+encodeSegment(upstream.URL+"/tile"), nil))
+	if len(provider.tracer.spans) != 1 {
+		t.Errorf("%v spans after provider removed, want 1", len(provider.tracer.spans))
+	}
+}
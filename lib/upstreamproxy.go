@@ -0,0 +1,43 @@
+package lib
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+)
+
+// ProxyFunc defines function returning upstream http proxy url of request, nil url means no proxy
+type ProxyFunc func(*http.Request) (*url.URL, error)
+
+// SetUpstreamProxy sets http proxy (http, https or socks5 url) used for all upstream requests,
+// empty proxyURL restores proxy from environment variables
+func (gp *GisProxy) SetUpstreamProxy(proxyURL string) error {
+	if proxyURL == "" {
+		gp.SetProxyFunc(nil)
+		return nil
+	}
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5" {
+		return errors.New("Unsupported proxy scheme " + u.Scheme)
+	}
+	if u.Host == "" {
+		return errors.New("Missing proxy host in " + proxyURL)
+	}
+	gp.SetProxyFunc(http.ProxyURL(u))
+	return nil
+}
+
+// SetProxyFunc sets function selecting upstream http proxy per request (per host for instance),
+// nil restores proxy from environment variables
+func (gp *GisProxy) SetProxyFunc(proxyFunc ProxyFunc) {
+	gp.updateTransport(func(transport *http.Transport) {
+		if proxyFunc == nil {
+			transport.Proxy = http.ProxyFromEnvironment
+		} else {
+			transport.Proxy = proxyFunc
+		}
+	})
+}
@@ -0,0 +1,62 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestUpstreamProxy(t *testing.T) {
+	proxy := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		writer.Write([]byte("proxied " + request.URL.String()))
+	})
+	direct := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		writer.Write([]byte("direct"))
+	})
+	proxyURL, err := url.Parse(proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Run("all requests", func(t *testing.T) {
+		gp := NewGisProxyHandler("/gisproxy/", false)
+		if err := gp.SetUpstreamProxy(proxy.URL); err != nil {
+			t.Fatal(err)
+		}
+		recorder := serve(gp, httptest.NewRequest("GET", "/gisproxy/"+encodeSegment("http://maps.gisproxy.test/wms?SERVICE=WMS"), nil))
+		if want := "proxied http://maps.gisproxy.test/wms?SERVICE=WMS"; recorder.Body.String() != want {
+			t.Errorf("body %q, want %q", recorder.Body.String(), want)
+		}
+	})
+	t.Run("per host", func(t *testing.T) {
+		gp := NewGisProxyHandler("/gisproxy/", false)
+		gp.SetProxyFunc(func(request *http.Request) (*url.URL, error) {
+			if request.URL.Hostname() == "maps.gisproxy.test" {
+				return proxyURL, nil
+			}
+			return nil, nil
+		})
+		for target, want := range map[string]string{
+			"http://maps.gisproxy.test/wms": "proxied http://maps.gisproxy.test/wms",
+			direct.URL + "/wms":             "direct",
+		} {
+			if recorder := serve(gp, httptest.NewRequest("GET", "/gisproxy/"+encodeSegment(target), nil)); recorder.Body.String() != want {
+				t.Errorf("body %q, want %q", recorder.Body.String(), want)
+			}
+		}
+	})
+}
+
+func TestSetUpstreamProxyInvalid(t *testing.T) {
+	gp := NewGisProxyHandler("/gisproxy/", false)
+	for _, proxyURL := range []string{"ftp://proxy.example.com", "http://", "://proxy"} {
+		if err := gp.SetUpstreamProxy(proxyURL); err == nil {
+			t.Errorf("proxy %q accepted", proxyURL)
+		}
+	}
+	for _, proxyURL := range []string{"http://proxy.example.com:3128", "socks5://127.0.0.1:1080", ""} {
+		if err := gp.SetUpstreamProxy(proxyURL); err != nil {
+			t.Errorf("proxy %q rejected: %v", proxyURL, err)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package lib
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+)
+
+// ResponseValidator defines upstream response validation function
+type ResponseValidator func(ctx context.Context, info *GisInfo, response *http.Response) error
+
+// SetResponseValidator sets ResponseValidator function, called with upstream response before body
+// is copied to client (a tile request answered with an HTML error page for instance). Returned
+// error is written instead of response, a *StatusError with 502 replaces an invalid 200 response.
+// Body is not decoded, PeekBody reads first bytes without consuming them.
+func (gp *GisProxy) SetResponseValidator(responseValidator ResponseValidator) {
+	gp.responseValidator = responseValidator
+}
+
+// peekBody structure, buffered body replayed when read
+type peekBody struct {
+	*bufio.Reader
+	io.Closer
+}
+
+// PeekBody returns up to n first bytes of response body without consuming them, body is then
+// read from its beginning
+func PeekBody(response *http.Response, n int) ([]byte, error) {
+	if response.Body == nil || n <= 0 {
+		return nil, nil
+	}
+	pb, ok := response.Body.(*peekBody)
+	if !ok || pb.Size() < n {
+		var reader io.Reader = response.Body
+		if ok {
+			reader = pb.Reader
+		}
+		pb = &peekBody{Reader: bufio.NewReaderSize(reader, n), Closer: response.Body}
+		response.Body = pb
+	}
+	head, err := pb.Peek(n)
+	if err == io.EOF {
+		err = nil
+	}
+	return head, err
+}
+
+// validateResponse calls response validator
+func (gp *GisProxy) validateResponse(request *http.Request, response *http.Response) error {
+	if gp.responseValidator == nil {
+		return nil
+	}
+	return gp.responseValidator(request.Context(), GisInfoFromContext(request.Context()), response)
+}
@@ -0,0 +1,86 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestResponseValidator(t *testing.T) {
+	png := "\x89PNG\r\n\x1a\ntile"
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		status      int
+		want        string
+	}{
+		{"image", "image/png", png, http.StatusOK, png},
+		{"html error page", "text/html", "<html><body>Server error</body></html>", http.StatusBadGateway, "Invalid GetMap response (502)\n"},
+		{"mislabelled html", "image/png", "<html><body>Server error</body></html>", http.StatusBadGateway, "Invalid GetMap response (502)\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+				writer.Header().Set("Content-Type", test.contentType)
+				writer.Write([]byte(test.body))
+			})
+			gp := NewGisProxyHandler("/gisproxy/", false)
+			gp.SetResponseValidator(func(ctx context.Context, info *GisInfo, response *http.Response) error {
+				if info.Operation != "GetMap" {
+					return nil
+				}
+				head, err := PeekBody(response, 8)
+				if err != nil {
+					return err
+				}
+				if !strings.HasPrefix(response.Header.Get("Content-Type"), "image/") || bytes.HasPrefix(head, []byte("<")) {
+					return NewStatusError("Invalid GetMap response", http.StatusBadGateway)
+				}
+				return nil
+			})
+			recorder := serve(gp, httptest.NewRequest("GET", "/gisproxy/"+encodeSegment(upstream.URL+"/wms?SERVICE=WMS&REQUEST=GetMap"), nil))
+			if recorder.Code != test.status {
+				t.Errorf("status %v, want %v", recorder.Code, test.status)
+			}
+			if body := recorder.Body.String(); body != test.want {
+				t.Errorf("body %q, want %q", body, test.want)
+			}
+		})
+	}
+}
+
+func TestPeekBody(t *testing.T) {
+	response := &http.Response{Body: ioutil.NopCloser(strings.NewReader("0123456789"))}
+	for _, test := range []struct {
+		n    int
+		want string
+	}{
+		{4, "0123"},
+		{2, "01"},
+		{8, "01234567"},
+		{20, "0123456789"},
+	} {
+		head, err := PeekBody(response, test.n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(head) != test.want {
+			t.Errorf("peek %v %q, want %q", test.n, head, test.want)
+		}
+	}
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "0123456789" {
+		t.Errorf("body %q, want %q", body, "0123456789")
+	}
+	if head, err := PeekBody(&http.Response{}, 4); head != nil || err != nil {
+		t.Errorf("peek without body %q %v", head, err)
+	}
+}
@@ -0,0 +1,34 @@
+package lib
+
+import (
+	"net/http"
+)
+
+// vectorTileContentTypes lists Mapbox Vector Tile content types
+var vectorTileContentTypes = []string{
+	"application/vnd.mapbox-vector-tile",
+	"application/x-protobuf",
+	"application/protobuf",
+}
+
+// isVectorTile checks if response is a vector tile
+func isVectorTile(response *http.Response) bool {
+	return hasContentTypePrefix(response, vectorTileContentTypes)
+}
+
+// isVectorTileRequest checks if request GisInfo is a vector tile, detected from url or response
+func isVectorTileRequest(request *http.Request) bool {
+	info := GisInfoFromContext(request.Context())
+	return info != nil && info.ServerType == "MVT"
+}
+
+// tagVectorTile sets MVT server and service types of GisInfo not detected from url when response
+// is a vector tile
+func tagVectorTile(request *http.Request, response *http.Response) {
+	info := GisInfoFromContext(request.Context())
+	if info == nil || info.ServerType != "unknown" || !isVectorTile(response) {
+		return
+	}
+	info.ServerType = "MVT"
+	info.ServiceType = "MVT"
+}
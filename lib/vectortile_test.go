@@ -0,0 +1,51 @@
+package lib
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestVectorTileResponse(t *testing.T) {
+	tile := "\x1a\x05roads http://example.com/"
+	tests := []struct {
+		name        string
+		path        string
+		contentType string
+		serverType  string
+		body        string
+	}{
+		{"mapbox content type", "/data/layer", "application/vnd.mapbox-vector-tile", "MVT", tile},
+		{"protobuf content type", "/data/layer", "application/x-protobuf", "MVT", tile},
+		{"url detected", "/tiles/1/0/0.pbf", "application/octet-stream", "MVT", tile},
+		{"other content type", "/data/layer", "application/octet-stream", "unknown", "rewritten"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+				writer.Header().Set("Content-Type", test.contentType)
+				writer.Write([]byte(tile))
+			})
+			var serverType string
+			gp := NewGisProxyHandler("/gisproxy/", false)
+			gp.SetBodyRewriteFunc(func(ctx context.Context, info *GisInfo, contentType string, body []byte) ([]byte, error) {
+				return []byte("rewritten"), nil
+			}, []string{"application/"})
+			gp.SetResponseValidator(func(ctx context.Context, info *GisInfo, response *http.Response) error {
+				serverType = info.ServerType
+				return nil
+			})
+			recorder := serve(gp, httptest.NewRequest("GET", "/gisproxy/"+encodeSegment(upstream.URL+test.path), nil))
+			if recorder.Code != http.StatusOK {
+				t.Errorf("status %v, want %v", recorder.Code, http.StatusOK)
+			}
+			if serverType != test.serverType {
+				t.Errorf("server type %q, want %q", serverType, test.serverType)
+			}
+			if body := recorder.Body.String(); body != test.body {
+				t.Errorf("body %q, want %q", body, test.body)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package lib
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// EnableWebSocketProxy enables WebSocket upgrade passthrough: upgrade requests are forwarded as
+// other requests (hooks, limits and checks apply) and, once upstream switches protocols, bytes
+// are copied both ways until one side closes. Forward urls may then use ws and wss schemes,
+// respectively checked as http and https. Upgrade is not supported over h2c upstreams.
+func (gp *GisProxy) EnableWebSocketProxy(enabled bool) {
+	gp.webSocketProxy = enabled
+}
+
+// isWebSocketUpgrade checks if header asks for a WebSocket upgrade
+func isWebSocketUpgrade(header http.Header) bool {
+	if !strings.EqualFold(header.Get("Upgrade"), "websocket") {
+		return false
+	}
+	for _, value := range header["Connection"] {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// webSocketScheme returns http scheme of ws or wss scheme
+func webSocketScheme(scheme string) string {
+	switch strings.ToLower(scheme) {
+	case "ws":
+		return "http"
+	case "wss":
+		return "https"
+	}
+	return scheme
+}
+
+// isUpgrade checks if forwarded request is a WebSocket upgrade
+func (gp *GisProxy) isUpgrade(header http.Header) bool {
+	return gp.webSocketProxy && isWebSocketUpgrade(header)
+}
+
+// applyUpgrade sets upgrade headers removed as hop-by-hop headers
+func applyUpgrade(header http.Header, upgrade string) {
+	header.Set("Connection", "Upgrade")
+	header.Set("Upgrade", upgrade)
+}
+
+// cancelConn cancels context when upgraded connection is closed
+type cancelConn struct {
+	io.ReadWriteCloser
+	cancel context.CancelFunc
+}
+
+// Close closes connection and cancels context
+func (cc *cancelConn) Close() error {
+	err := cc.ReadWriteCloser.Close()
+	cc.cancel()
+	return err
+}
+
+// switchProtocols writes upstream switching protocols response and copies bytes between client
+// and upgraded upstream connection
+func (gp *GisProxy) switchProtocols(writer http.ResponseWriter, request *http.Request, response *http.Response) {
+	upstreamConn, ok := response.Body.(io.ReadWriteCloser)
+	if !ok {
+		gp.writeError(writer, request, NewStatusError("Invalid WebSocket upstream response", http.StatusBadGateway))
+		return
+	}
+	hijacker, ok := writer.(http.Hijacker)
+	if !ok {
+		gp.writeError(writer, request, NewStatusError("WebSocket upgrade not supported", http.StatusInternalServerError))
+		return
+	}
+	upgrade := response.Header.Get("Upgrade")
+	gp.writeResponseHeader(writer, request, gp.responseHeaderPolicy.filter(response.Header))
+	applyUpgrade(writer.Header(), upgrade)
+	clientConn, clientBuffer, err := hijacker.Hijack()
+	if err != nil {
+		gp.logger.Errorf("WebSocket hijack error %v", err)
+		return
+	}
+	defer clientConn.Close()
+	switched := &http.Response{StatusCode: http.StatusSwitchingProtocols, ProtoMajor: 1, ProtoMinor: 1, Header: writer.Header()}
+	if err := switched.Write(clientBuffer); err != nil {
+		return
+	}
+	if err := clientBuffer.Flush(); err != nil {
+		return
+	}
+	gp.logger.Debugf("WebSocket %v", redactURL(request.URL))
+	var once sync.Once
+	done := make(chan struct{})
+	closeDone := func() { once.Do(func() { close(done) }) }
+	go func() {
+		// Client bytes already buffered by server are copied first
+		io.Copy(upstreamConn, clientBuffer.Reader)
+		closeDone()
+	}()
+	go func() {
+		io.Copy(clientConn, upstreamConn)
+		closeDone()
+	}()
+	<-done
+}
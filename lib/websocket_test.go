@@ -0,0 +1,130 @@
+package lib
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newEchoUpstream starts upstream switching to an echo protocol on WebSocket upgrade
+func newEchoUpstream(t *testing.T) *httptest.Server {
+	return newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		if !isWebSocketUpgrade(request.Header) {
+			http.Error(writer, "upgrade required", http.StatusUpgradeRequired)
+			return
+		}
+		conn, buffer, err := writer.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buffer.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+		buffer.Flush()
+		line, err := buffer.ReadString('\n')
+		if err != nil {
+			return
+		}
+		buffer.WriteString("echo " + line)
+		buffer.Flush()
+	})
+}
+
+// dialUpgrade sends upgrade request to proxy server and returns connection and response
+func dialUpgrade(t *testing.T, proxy *httptest.Server, target string) (net.Conn, *bufio.Reader, *http.Response) {
+	t.Helper()
+	conn, err := net.Dial("tcp", proxy.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	request, err := http.NewRequest("GET", proxy.URL+target, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set("Connection", "Upgrade")
+	request.Header.Set("Upgrade", "websocket")
+	if err := request.Write(conn); err != nil {
+		t.Fatal(err)
+	}
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, reader, response
+}
+
+func TestWebSocketUpgradeGating(t *testing.T) {
+	upstream := newEchoUpstream(t)
+	segment := encodeSegment(strings.Replace(upstream.URL, "http:", "ws:", 1) + "/socket")
+	tests := []struct {
+		name      string
+		configure func(gp *GisProxy)
+		status    int
+	}{
+		{"before send", func(gp *GisProxy) {
+			gp.SetBeforeSendFunc(func(writer http.ResponseWriter, request *http.Request) error {
+				return NewStatusError("Unauthorized", http.StatusUnauthorized)
+			})
+		}, http.StatusUnauthorized},
+		{"allowed methods", func(gp *GisProxy) {
+			gp.SetAllowedMethods([]string{"POST"})
+		}, http.StatusMethodNotAllowed},
+		{"allowed hosts", func(gp *GisProxy) {
+			gp.SetAllowedHosts([]string{"example.com"})
+		}, http.StatusForbidden},
+		{"upstream limit", func(gp *GisProxy) {
+			gp.SetMaxConcurrentUpstream(1)
+			gp.limiter().acquire(context.Background(), "")
+		}, http.StatusServiceUnavailable},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gp := NewGisProxyHandler("/gisproxy/", false)
+			gp.EnableWebSocketProxy(true)
+			test.configure(gp)
+			proxy := httptest.NewServer(gp)
+			defer proxy.Close()
+			_, _, response := dialUpgrade(t, proxy, "/gisproxy/"+segment)
+			response.Body.Close()
+			if response.StatusCode != test.status {
+				t.Errorf("status %v, want %v", response.StatusCode, test.status)
+			}
+		})
+	}
+}
+
+func TestWebSocketUpgradeEcho(t *testing.T) {
+	upstream := newEchoUpstream(t)
+	var sent bool
+	gp := NewGisProxyHandler("/gisproxy/", false)
+	gp.EnableWebSocketProxy(true)
+	gp.SetBeforeSendFunc(func(writer http.ResponseWriter, request *http.Request) error {
+		sent = true
+		return nil
+	})
+	proxy := httptest.NewServer(gp)
+	defer proxy.Close()
+	segment := encodeSegment(strings.Replace(upstream.URL, "http:", "ws:", 1) + "/socket")
+	conn, reader, response := dialUpgrade(t, proxy, "/gisproxy/"+segment)
+	if response.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status %v, want %v", response.StatusCode, http.StatusSwitchingProtocols)
+	}
+	if !sent {
+		t.Error("before send callback not called")
+	}
+	if _, err := conn.Write([]byte("hello\n")); err != nil {
+		t.Fatal(err)
+	}
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != "echo hello\n" {
+		t.Errorf("echo %q, want %q", line, "echo hello\n")
+	}
+}
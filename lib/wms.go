@@ -0,0 +1,242 @@
+package lib
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// latLonCRS lists geographic CRS with lat/lon axis order in WMS 1.3.0
+var latLonCRS = map[string]bool{
+	"EPSG:4326": true,
+	"EPSG:4258": true,
+	"EPSG:4269": true,
+}
+
+// wmsLimits structure
+type wmsLimits struct {
+	maxWidth    int
+	maxHeight   int
+	maxBBoxArea float64
+}
+
+// SetWMSLimits rejects with 400 WMS requests whose width, height or bbox area (in CRS units,
+// lon/lat for geographic CRS) exceed limits. Zero means no limit.
+func (gp *GisProxy) SetWMSLimits(maxWidth int, maxHeight int, maxBBoxArea float64) {
+	gp.wmsLimits = &wmsLimits{maxWidth: maxWidth, maxHeight: maxHeight, maxBBoxArea: maxBBoxArea}
+}
+
+// checkWMSLimits checks WMS request parameters against limits
+func (gp *GisProxy) checkWMSLimits(request *http.Request, forwardUrl *url.URL, info *GisInfo) error {
+	if gp.wmsLimits == nil || info.ServerType != "WMS" {
+		return nil
+	}
+	form := requestForm(request, forwardUrl)
+	if err := checkDimension(form, "width", gp.wmsLimits.maxWidth); err != nil {
+		return err
+	}
+	if err := checkDimension(form, "height", gp.wmsLimits.maxHeight); err != nil {
+		return err
+	}
+	bbox := formValue(form, "bbox")
+	if bbox == "" || gp.wmsLimits.maxBBoxArea <= 0 {
+		return nil
+	}
+	minX, minY, maxX, maxY, err := parseBBox(bbox, form)
+	if err != nil {
+		return err
+	}
+	if area := (maxX - minX) * (maxY - minY); area > gp.wmsLimits.maxBBoxArea {
+		return NewStatusError("WMS bbox area "+strconv.FormatFloat(area, 'g', -1, 64)+" exceeds "+strconv.FormatFloat(gp.wmsLimits.maxBBoxArea, 'g', -1, 64), 400)
+	}
+	return nil
+}
+
+// checkDimension checks WMS width or height parameter
+func checkDimension(form url.Values, name string, max int) error {
+	value := formValue(form, name)
+	if value == "" || max <= 0 {
+		return nil
+	}
+	size, err := strconv.Atoi(value)
+	if err != nil || size <= 0 {
+		return NewStatusError("Invalid WMS "+name+" "+value, 400)
+	}
+	if size > max {
+		return NewStatusError("WMS "+name+" "+value+" exceeds "+strconv.Itoa(max), 400)
+	}
+	return nil
+}
+
+// parseBBox parses WMS bbox as x/y (lon/lat) coordinates, swapping axes for lat/lon CRS in WMS 1.3.0
+func parseBBox(bbox string, form url.Values) (float64, float64, float64, float64, error) {
+	parts := strings.Split(bbox, ",")
+	if len(parts) < 4 {
+		return 0, 0, 0, 0, NewStatusError("Invalid WMS bbox "+bbox, 400)
+	}
+	coords := make([]float64, 4)
+	for i := range coords {
+		coord, err := strconv.ParseFloat(strings.TrimSpace(parts[i]), 64)
+		if err != nil {
+			return 0, 0, 0, 0, NewStatusError("Invalid WMS bbox "+bbox, 400)
+		}
+		coords[i] = coord
+	}
+	minX, minY, maxX, maxY := coords[0], coords[1], coords[2], coords[3]
+	version := formValue(form, "version")
+	crs := strings.ToUpper(formValue(form, "crs"))
+	if (version == "1.3.0" || (version == "" && crs != "")) && latLonCRS[crs] {
+		minX, minY, maxX, maxY = minY, minX, maxY, maxX
+	}
+	if crs == "" {
+		// WMS 1.1.1 uses srs with lon/lat axis order
+		crs = strings.ToUpper(formValue(form, "srs"))
+	}
+	geographic := latLonCRS[crs] || crs == "CRS:84"
+	if minX > maxX || minY > maxY || (geographic && (minY < -90 || maxY > 90)) {
+		return 0, 0, 0, 0, NewStatusError("Invalid WMS bbox "+bbox, 400)
+	}
+	return minX, minY, maxX, maxY, nil
+}
+
+// SetWMSFeatureInfoLimit clamps feature_count of WMS GetFeatureInfo requests to max, zero means no limit
+func (gp *GisProxy) SetWMSFeatureInfoLimit(max int) {
+	gp.wmsFeatureInfoLimit = max
+}
+
+// limitFeatureInfo checks GetFeatureInfo pixel coordinates (x/y in WMS 1.1.1, i/j in 1.3.0)
+// and clamps feature_count of forward url
+func (gp *GisProxy) limitFeatureInfo(request *http.Request, forwardUrl *url.URL, info *GisInfo) error {
+	if info.ServerType != "WMS" || !strings.EqualFold(info.Operation, "GetFeatureInfo") {
+		return nil
+	}
+	form := requestForm(request, forwardUrl)
+	for _, names := range [][]string{{"i", "x"}, {"j", "y"}} {
+		value := formValue(form, names[0])
+		if value == "" {
+			value = formValue(form, names[1])
+		}
+		if value == "" {
+			continue
+		}
+		if pixel, err := strconv.Atoi(value); err != nil || pixel < 0 {
+			return NewStatusError("Invalid WMS GetFeatureInfo pixel "+value, 400)
+		}
+	}
+	if gp.wmsFeatureInfoLimit <= 0 {
+		return nil
+	}
+	parts := strings.Split(forwardUrl.RawQuery, "&")
+	for i, part := range parts {
+		if !strings.EqualFold(queryKey(part), "feature_count") {
+			continue
+		}
+		value := ""
+		if keyValue := strings.SplitN(part, "=", 2); len(keyValue) == 2 {
+			value = keyValue[1]
+		}
+		if count, err := strconv.Atoi(value); err != nil || count > gp.wmsFeatureInfoLimit {
+			parts[i] = strings.SplitN(part, "=", 2)[0] + "=" + strconv.Itoa(gp.wmsFeatureInfoLimit)
+		}
+	}
+	forwardUrl.RawQuery = strings.Join(parts, "&")
+	return nil
+}
+
+// wmsRenamedParams lists WMS 1.1.1 parameters renamed in WMS 1.3.0
+var wmsRenamedParams = map[string]string{
+	"srs": "crs",
+	"x":   "i",
+	"y":   "j",
+}
+
+// SetWMSVersion forces version of forwarded WMS requests (1.1.1 or 1.3.0 for instance), renaming
+// srs/crs and x/y/i/j parameters and swapping bbox axes of lat/lon CRS between 1.1.1 and 1.3.0.
+// Empty version forwards client version.
+func (gp *GisProxy) SetWMSVersion(version string) {
+	gp.wmsVersion = version
+}
+
+// normalizeWMSVersion sets WMS version of forward url query
+func (gp *GisProxy) normalizeWMSVersion(forwardUrl *url.URL, info *GisInfo) {
+	if gp.wmsVersion == "" || info.ServerType != "WMS" {
+		return
+	}
+	version := formValue(forwardUrl.Query(), "version")
+	if version == gp.wmsVersion {
+		return
+	}
+	upgrade := version != "1.3.0" && gp.wmsVersion == "1.3.0"
+	downgrade := version == "1.3.0" && gp.wmsVersion != "1.3.0"
+	parts := make([]string, 0)
+	bbox := -1
+	crs := ""
+	versionSet := false
+	for _, part := range strings.Split(forwardUrl.RawQuery, "&") {
+		if part == "" {
+			continue
+		}
+		key := strings.ToLower(queryKey(part))
+		keyValue := strings.SplitN(part, "=", 2)
+		if key == "version" {
+			if !versionSet {
+				parts = append(parts, keyValue[0]+"="+url.QueryEscape(gp.wmsVersion))
+				versionSet = true
+			}
+			continue
+		}
+		for oldName, newName := range wmsRenamedParams {
+			name := ""
+			if upgrade && key == oldName {
+				name = newName
+			} else if downgrade && key == newName {
+				name = oldName
+			}
+			if name != "" {
+				if keyValue[0] == strings.ToUpper(keyValue[0]) {
+					keyValue[0] = strings.ToUpper(name)
+				} else {
+					keyValue[0] = name
+				}
+				key = name
+				break
+			}
+		}
+		if len(keyValue) == 2 {
+			if key == "srs" || key == "crs" {
+				if value, err := url.QueryUnescape(keyValue[1]); err == nil {
+					crs = strings.ToUpper(value)
+				}
+			} else if key == "bbox" {
+				bbox = len(parts)
+			}
+		}
+		parts = append(parts, strings.Join(keyValue, "="))
+	}
+	if bbox >= 0 && (upgrade || downgrade) && latLonCRS[crs] {
+		parts[bbox] = swapBBoxAxes(parts[bbox])
+	}
+	if !versionSet {
+		parts = append(parts, "version="+url.QueryEscape(gp.wmsVersion))
+	}
+	forwardUrl.RawQuery = strings.Join(parts, "&")
+}
+
+// swapBBoxAxes swaps axes of raw bbox query part, unchanged if invalid
+func swapBBoxAxes(part string) string {
+	keyValue := strings.SplitN(part, "=", 2)
+	value, err := url.QueryUnescape(keyValue[1])
+	if err != nil {
+		return part
+	}
+	coords := strings.Split(value, ",")
+	if len(coords) < 4 {
+		return part
+	}
+	coords[0], coords[1], coords[2], coords[3] = coords[1], coords[0], coords[3], coords[2]
+	for i, coord := range coords {
+		coords[i] = url.QueryEscape(coord)
+	}
+	return keyValue[0] + "=" + strings.Join(coords, ",")
+}
@@ -0,0 +1,114 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWMSLimits(t *testing.T) {
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {})
+	tests := []struct {
+		name   string
+		method string
+		query  string
+		status int
+	}{
+		{"within limits", "GET", "SERVICE=WMS&REQUEST=GetMap&WIDTH=512&HEIGHT=512&SRS=EPSG:4326&BBOX=0,0,5,5", http.StatusOK},
+		{"width too large", "GET", "SERVICE=WMS&REQUEST=GetMap&WIDTH=4096&HEIGHT=512", http.StatusBadRequest},
+		{"height too large", "GET", "service=wms&request=GetMap&width=512&height=4096", http.StatusBadRequest},
+		{"invalid height", "GET", "SERVICE=WMS&REQUEST=GetMap&WIDTH=512&HEIGHT=abc", http.StatusBadRequest},
+		{"bbox area too large", "GET", "SERVICE=WMS&REQUEST=GetMap&SRS=EPSG:4326&BBOX=0,0,20,20", http.StatusBadRequest},
+		{"invalid bbox", "GET", "SERVICE=WMS&REQUEST=GetMap&SRS=EPSG:4326&BBOX=0,0,1", http.StatusBadRequest},
+		{"latitude out of range in 1.1.1", "GET", "SERVICE=WMS&VERSION=1.1.1&REQUEST=GetMap&SRS=EPSG:4326&BBOX=0,0,1,100", http.StatusBadRequest},
+		{"lat/lon axes in 1.3.0", "GET", "SERVICE=WMS&VERSION=1.3.0&REQUEST=GetMap&CRS=EPSG:4326&BBOX=0,0,1,100", http.StatusOK},
+		{"form body", "POST", "SERVICE=WMS&REQUEST=GetMap&WIDTH=4096&HEIGHT=512", http.StatusBadRequest},
+		{"not wms", "GET", "WIDTH=4096&HEIGHT=4096", http.StatusOK},
+	}
+	gp := NewGisProxyHandler("/gisproxy/", false)
+	gp.SetWMSLimits(1024, 1024, 100)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			target := "/gisproxy/" + encodeSegment(upstream.URL+"/wms")
+			var request *http.Request
+			if test.method == "POST" {
+				request = httptest.NewRequest("POST", target, strings.NewReader(test.query))
+				request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			} else {
+				request = httptest.NewRequest("GET", target+"?"+test.query, nil)
+			}
+			if recorder := serve(gp, request); recorder.Code != test.status {
+				t.Errorf("status %v, want %v", recorder.Code, test.status)
+			}
+		})
+	}
+}
+
+func TestWMSFeatureInfoLimit(t *testing.T) {
+	var query string
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		query = request.URL.RawQuery
+	})
+	tests := []struct {
+		name   string
+		query  string
+		status int
+		want   string
+	}{
+		{"clamped", "SERVICE=WMS&REQUEST=GetFeatureInfo&I=10&J=20&FEATURE_COUNT=5000", http.StatusOK, "SERVICE=WMS&REQUEST=GetFeatureInfo&I=10&J=20&FEATURE_COUNT=50"},
+		{"within limit", "SERVICE=WMS&REQUEST=GetFeatureInfo&X=10&Y=20&feature_count=3", http.StatusOK, "SERVICE=WMS&REQUEST=GetFeatureInfo&X=10&Y=20&feature_count=3"},
+		{"invalid count clamped", "SERVICE=WMS&REQUEST=GetFeatureInfo&FEATURE_COUNT=all", http.StatusOK, "SERVICE=WMS&REQUEST=GetFeatureInfo&FEATURE_COUNT=50"},
+		{"negative pixel", "SERVICE=WMS&REQUEST=GetFeatureInfo&I=-1&J=20", http.StatusBadRequest, ""},
+		{"invalid pixel", "SERVICE=WMS&REQUEST=GetFeatureInfo&X=10&Y=abc", http.StatusBadRequest, ""},
+		{"other operation", "SERVICE=WMS&REQUEST=GetMap&FEATURE_COUNT=5000", http.StatusOK, "SERVICE=WMS&REQUEST=GetMap&FEATURE_COUNT=5000"},
+	}
+	gp := NewGisProxyHandler("/gisproxy/", false)
+	gp.SetWMSFeatureInfoLimit(50)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			query = ""
+			recorder := serve(gp, httptest.NewRequest("GET", "/gisproxy/"+encodeSegment(upstream.URL+"/wms")+"?"+test.query, nil))
+			if recorder.Code != test.status {
+				t.Errorf("status %v, want %v", recorder.Code, test.status)
+			}
+			if query != test.want {
+				t.Errorf("forwarded query %q, want %q", query, test.want)
+			}
+		})
+	}
+}
+
+func TestWMSVersion(t *testing.T) {
+	var query string
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		query = request.URL.RawQuery
+	})
+	tests := []struct {
+		name    string
+		version string
+		query   string
+		want    string
+	}{
+		{"upgrade swaps lat/lon bbox", "1.3.0", "SERVICE=WMS&VERSION=1.1.1&REQUEST=GetMap&SRS=EPSG:4326&BBOX=1,2,3,4", "SERVICE=WMS&VERSION=1.3.0&REQUEST=GetMap&CRS=EPSG:4326&BBOX=2,1,4,3"},
+		{"downgrade swaps lat/lon bbox", "1.1.1", "service=WMS&version=1.3.0&request=GetFeatureInfo&crs=EPSG:4326&bbox=2,1,4,3&i=5&j=6", "service=WMS&version=1.1.1&request=GetFeatureInfo&srs=EPSG:4326&bbox=1,2,3,4&x=5&y=6"},
+		{"projected crs bbox kept", "1.3.0", "SERVICE=WMS&VERSION=1.1.1&REQUEST=GetMap&SRS=EPSG:3857&BBOX=1,2,3,4", "SERVICE=WMS&VERSION=1.3.0&REQUEST=GetMap&CRS=EPSG:3857&BBOX=1,2,3,4"},
+		{"missing version added", "1.3.0", "SERVICE=WMS&REQUEST=GetCapabilities", "SERVICE=WMS&REQUEST=GetCapabilities&version=1.3.0"},
+		{"same version unchanged", "1.3.0", "SERVICE=WMS&VERSION=1.3.0&CRS=EPSG:4326&BBOX=2,1,4,3", "SERVICE=WMS&VERSION=1.3.0&CRS=EPSG:4326&BBOX=2,1,4,3"},
+		{"disabled", "", "SERVICE=WMS&VERSION=1.1.1&SRS=EPSG:4326&BBOX=1,2,3,4", "SERVICE=WMS&VERSION=1.1.1&SRS=EPSG:4326&BBOX=1,2,3,4"},
+		{"not wms", "1.3.0", "VERSION=1.1.1&BBOX=1,2,3,4", "VERSION=1.1.1&BBOX=1,2,3,4"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gp := NewGisProxyHandler("/gisproxy/", false)
+			gp.SetWMSVersion(test.version)
+			query = ""
+			if recorder := serve(gp, httptest.NewRequest("GET", "/gisproxy/"+encodeSegment(upstream.URL+"/wms")+"?"+test.query, nil)); recorder.Code != http.StatusOK {
+				t.Fatalf("status %v, want %v", recorder.Code, http.StatusOK)
+			}
+			if query != test.want {
+				t.Errorf("forwarded query %q, want %q", query, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package lib
+
+import (
+	"errors"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// reWMTSRest matches default WMTS RESTful tile path {layer}/{style}/{tilematrixset}/{tilematrix}/{tilerow}/{tilecol}
+var reWMTSRest = regexp.MustCompile("(?i)/wmts/(?:1\\.0\\.0/)?(?P<layer>[^/]+)/(?P<style>[^/]+)/(?P<tilematrixset>[^/]+)/(?P<tilematrix>[^/]+)/(?P<tilerow>[0-9]+)/(?P<tilecol>[0-9]+)\\.(?:png|jpe?g|gif|webp)$")
+
+// SetWMTSRestPattern sets regular expression matching WMTS RESTful tile paths (without query string).
+// Pattern must contain a "layer" named group; optional "tilematrix", "tilerow" and "tilecol" named
+// groups populate tile coordinates. Default is reWMTSRest.
+func (gp *GisProxy) SetWMTSRestPattern(pattern string) error {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return err
+	}
+	if subexpIndex(re, "layer") == -1 {
+		return errors.New("WMTS RESTful pattern must contain a layer named group")
+	}
+	gp.wmtsRestPattern = re
+	return nil
+}
+
+// matchWMTSRest matches forward url path against WMTS RESTful pattern and returns GisInfo
+func (gp *GisProxy) matchWMTSRest(forwardUrl *url.URL) *GisInfo {
+	re := gp.wmtsRestPattern
+	if re == nil {
+		re = reWMTSRest
+	}
+	path := forwardUrl.Path
+	res := re.FindStringSubmatchIndex(path)
+	if res == nil {
+		return nil
+	}
+	group := func(name string) string {
+		i := subexpIndex(re, name)
+		if i == -1 || res[2*i] == -1 {
+			return ""
+		}
+		return path[res[2*i]:res[2*i+1]]
+	}
+	info := &GisInfo{ServerType: "WMTS", ServiceType: "WMTS", ServiceName: group("layer")}
+	info.ServerURL = serverPrefix(forwardUrl, path[:res[0]])
+	if i := subexpIndex(re, "layer"); res[2*i] != -1 {
+		// Server url ends before layer segment
+		info.ServerURL = serverPrefix(forwardUrl, path[:res[2*i]-1])
+	}
+	// Tile matrix identifier may be prefixed by tile matrix set (EPSG:3857:12 for instance)
+	tileMatrix := group("tilematrix")
+	tileMatrix = tileMatrix[strings.LastIndex(tileMatrix, ":")+1:]
+	info.TileZ, info.TileX, info.TileY = parseTile(tileMatrix, group("tilecol"), group("tilerow"))
+	return info
+}
+
+// subexpIndex returns index of named group or -1
+func subexpIndex(re *regexp.Regexp, name string) int {
+	for i, subexpName := range re.SubexpNames() {
+		if i > 0 && subexpName == name {
+			return i
+		}
+	}
+	return -1
+}
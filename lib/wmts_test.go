@@ -0,0 +1,59 @@
+package lib
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestWMTSRest(t *testing.T) {
+	tests := []struct {
+		name       string
+		pattern    string
+		forwardURL string
+		want       GisInfo
+	}{
+		{
+			"default pattern", "", "https://maps.example.com/wmts/1.0.0/roads/default/GoogleMapsCompatible/5/10/17.png",
+			GisInfo{ServerURL: "https://maps.example.com/wmts/1.0.0/", ServerType: "WMTS", ServiceType: "WMTS", ServiceName: "roads", TileZ: 5, TileX: 17, TileY: 10},
+		},
+		{
+			"prefixed tile matrix", "", "https://maps.example.com/geoserver/gwc/service/wmts/roads/default/EPSG:3857/EPSG:3857:12/1361/2048.jpeg",
+			GisInfo{ServerURL: "https://maps.example.com/geoserver/gwc/service/wmts/", ServerType: "WMTS", ServiceType: "WMTS", ServiceName: "roads", TileZ: 12, TileX: 2048, TileY: 1361},
+		},
+		{
+			"custom pattern", `/tiles/(?P<layer>[^/]+)/(?P<tilematrix>[0-9]+)/(?P<tilecol>[0-9]+)/(?P<tilerow>[0-9]+)$`, "https://maps.example.com/tiles/rivers/3/4/2",
+			GisInfo{ServerURL: "https://maps.example.com/tiles/", ServerType: "WMTS", ServiceType: "WMTS", ServiceName: "rivers", TileZ: 3, TileX: 4, TileY: 2},
+		},
+		{
+			"custom pattern replaces default", `/tiles/(?P<layer>[^/]+)/(?P<tilematrix>[0-9]+)/(?P<tilecol>[0-9]+)/(?P<tilerow>[0-9]+)$`, "https://maps.example.com/wmts/roads/default/set/epsg:5/10/17.png",
+			GisInfo{ServerURL: "https://maps.example.com/wmts/roads/default/set/epsg:5/10/17.png", ServerType: "unknown", ServiceType: "unknown"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gp := NewGisProxyHandler("/gisproxy/", false)
+			if test.pattern != "" {
+				if err := gp.SetWMTSRestPattern(test.pattern); err != nil {
+					t.Fatal(err)
+				}
+			}
+			forwardURL, err := url.Parse(test.forwardURL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info := gp.extractInfo(httptest.NewRequest("GET", "/gisproxy/", nil), forwardURL); *info != test.want {
+				t.Errorf("%v, want %v", info, &test.want)
+			}
+		})
+	}
+}
+
+func TestSetWMTSRestPatternInvalid(t *testing.T) {
+	gp := NewGisProxyHandler("/gisproxy/", false)
+	for _, pattern := range []string{"/wmts/(?P<layer>[^/]+", "/wmts/([^/]+)/"} {
+		if err := gp.SetWMTSRestPattern(pattern); err == nil {
+			t.Errorf("pattern %q accepted", pattern)
+		}
+	}
+}
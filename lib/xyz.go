@@ -0,0 +1,84 @@
+package lib
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// webMercatorExtent is half the EPSG:3857 world width in meters
+const webMercatorExtent = 20037508.342789244
+
+// reTilePath matches {z}/{x}/{y}.{format} tile path
+var reTilePath = regexp.MustCompile(`^([0-9]+)/([0-9]+)/([0-9]+)\.(png|jpe?g)$`)
+
+// RegisterWMSToXYZ serves XYZ tiles on path/{z}/{x}/{y}.png (or .jpg) with WMS GetMap requests
+// of layers on wmsBaseURL in EPSG:3857, out of range tiles are answered with 404
+func (gp *GisProxy) RegisterWMSToXYZ(path string, wmsBaseURL string, layers string) error {
+	base, err := url.Parse(wmsBaseURL)
+	if err != nil {
+		return err
+	}
+	if (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return errors.New("WMS base url " + wmsBaseURL + " must be an absolute http(s) url")
+	}
+	prefix := normalizePrefix(path)
+	// Tile requests go through proxy entry (preflight, limits, access log) as proxy path requests
+	gp.resolvePrefix(prefix, func(request *http.Request) (*url.URL, *ForwardInfo, error) {
+		submatch := reTilePath.FindStringSubmatch(strings.TrimPrefix(request.URL.Path, prefix))
+		if submatch == nil {
+			return nil, nil, NewStatusError("Tile not found", http.StatusNotFound)
+		}
+		z, x, y := parseTile(submatch[1], submatch[2], submatch[3])
+		if z > 30 || x >= 1<<uint(z) || y >= 1<<uint(z) {
+			return nil, nil, NewStatusError("Tile not found", http.StatusNotFound)
+		}
+		minX, minY, maxX, maxY := tileBounds(z, x, y)
+		format := "image/png"
+		if submatch[4] != "png" {
+			format = "image/jpeg"
+		}
+		forwardUrl := *base
+		params := []string{
+			"SERVICE=WMS",
+			"VERSION=1.3.0",
+			"REQUEST=GetMap",
+			"LAYERS=" + url.QueryEscape(layers),
+			"STYLES=",
+			"CRS=EPSG:3857",
+			"BBOX=" + formatCoords(minX, minY, maxX, maxY),
+			"WIDTH=256",
+			"HEIGHT=256",
+			"FORMAT=" + url.QueryEscape(format),
+			"TRANSPARENT=" + strconv.FormatBool(format == "image/png"),
+		}
+		if forwardUrl.RawQuery != "" {
+			forwardUrl.RawQuery += "&"
+		}
+		forwardUrl.RawQuery += strings.Join(params, "&")
+		forwardInfo := &ForwardInfo{Prefix: prefix, DecodedURL: wmsBaseURL, RemainingPath: request.URL.Path[len(prefix):]}
+		return &forwardUrl, forwardInfo, nil
+	})
+	return nil
+}
+
+// tileBounds returns EPSG:3857 bounds of XYZ tile
+func tileBounds(z int, x int, y int) (float64, float64, float64, float64) {
+	size := 2 * webMercatorExtent / math.Exp2(float64(z))
+	minX := -webMercatorExtent + float64(x)*size
+	maxY := webMercatorExtent - float64(y)*size
+	return minX, maxY - size, minX + size, maxY
+}
+
+// formatCoords formats comma separated coordinates
+func formatCoords(coords ...float64) string {
+	formatted := make([]string, 0, len(coords))
+	for _, coord := range coords {
+		formatted = append(formatted, strconv.FormatFloat(coord, 'f', -1, 64))
+	}
+	return strings.Join(formatted, ",")
+}
@@ -0,0 +1,95 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWMSToXYZ(t *testing.T) {
+	var query map[string][]string
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		query = request.URL.Query()
+		writer.Header().Set("Content-Type", "image/png")
+		writer.Write([]byte("png"))
+	})
+	tests := []struct {
+		name   string
+		path   string
+		status int
+		bbox   string
+		format string
+	}{
+		{"world tile", "/xyz/0/0/0.png", http.StatusOK, "-20037508.342789244,-20037508.342789244,20037508.342789244,20037508.342789244", "image/png"},
+		{"top left tile", "/xyz/1/0/0.jpg", http.StatusOK, "-20037508.342789244,0,0,20037508.342789244", "image/jpeg"},
+		{"bottom right tile", "/xyz/1/1/1.png", http.StatusOK, "0,-20037508.342789244,20037508.342789244,0", "image/png"},
+		{"out of range tile", "/xyz/1/2/0.png", http.StatusNotFound, "", ""},
+		{"invalid tile path", "/xyz/1/0.png", http.StatusNotFound, "", ""},
+	}
+	gp := NewGisProxyHandler("/gisproxy/", false)
+	if err := gp.RegisterWMSToXYZ("/xyz/", upstream.URL+"/wms?MAP=base", "roads,rivers"); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			query = nil
+			recorder := serve(gp, httptest.NewRequest("GET", test.path, nil))
+			if recorder.Code != test.status {
+				t.Fatalf("status %v, want %v", recorder.Code, test.status)
+			}
+			if test.status != http.StatusOK {
+				return
+			}
+			for name, want := range map[string]string{"MAP": "base", "REQUEST": "GetMap", "LAYERS": "roads,rivers", "CRS": "EPSG:3857", "BBOX": test.bbox, "WIDTH": "256", "HEIGHT": "256", "FORMAT": test.format} {
+				if got := query[name]; len(got) != 1 || got[0] != want {
+					t.Errorf("%v %v, want %v", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestWMSToXYZProxyEntry(t *testing.T) {
+	upstream := newUpstream(t, func(writer http.ResponseWriter, request *http.Request) {
+		writer.Write([]byte("png"))
+	})
+	tests := []struct {
+		name      string
+		configure func(gp *GisProxy)
+		method    string
+		status    int
+	}{
+		{"preflight", func(gp *GisProxy) {
+			gp.AllowCrossOrigin = true
+		}, "OPTIONS", http.StatusNoContent},
+		{"connection limit", func(gp *GisProxy) {
+			gp.SetMaxConnsPerClient(1)
+			gp.connLimiter.acquire("192.0.2.1")
+		}, "GET", http.StatusTooManyRequests},
+		{"allowed methods", func(gp *GisProxy) {
+			gp.SetAllowedMethods([]string{"POST"})
+		}, "GET", http.StatusMethodNotAllowed},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var entries int
+			gp := NewGisProxyHandler("/gisproxy/", false)
+			gp.SetAccessLogger(func(entry AccessLogEntry) {
+				entries++
+			})
+			if err := gp.RegisterWMSToXYZ("/xyz/", upstream.URL+"/wms", "roads"); err != nil {
+				t.Fatal(err)
+			}
+			test.configure(gp)
+			request := httptest.NewRequest(test.method, "/xyz/0/0/0.png", nil)
+			request.Header.Set("Origin", "http://example.com")
+			request.Header.Set("Access-Control-Request-Method", "GET")
+			if recorder := serve(gp, request); recorder.Code != test.status {
+				t.Errorf("status %v, want %v", recorder.Code, test.status)
+			}
+			if test.method != "OPTIONS" && entries != 1 {
+				t.Errorf("%v access log entries, want 1", entries)
+			}
+		})
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"log"
 	"net/http"
 	"runtime"
+	"time"
 
 	"github.com/aptogeo/gisproxy/lib"
 )
@@ -21,11 +22,15 @@ func main() {
 	var crtfile string
 	var keyfile string
 	var gomaxprocs int
+	var timeout time.Duration
+	var tlsverify bool
 	flag.BoolVar(&allowcrossorigin, "allowcrossorigin", true, "allow cross origin")
 	flag.BoolVar(&https, "https", false, "use https")
 	flag.StringVar(&crtfile, "crtfile", "", "crt file")
 	flag.StringVar(&keyfile, "keyfile", "", "key file")
 	flag.IntVar(&gomaxprocs, "gomaxprocs", 4, "maximum number of CPUs")
+	flag.DurationVar(&timeout, "timeout", 0, "upstream request timeout (0 for none)")
+	flag.BoolVar(&tlsverify, "tlsverify", false, "verify upstream tls certificates")
 
 	flag.Parse()
 
@@ -39,6 +44,8 @@ func main() {
 	if https {
 		gisProxy.UseHttps(crtfile, keyfile)
 	}
+	gisProxy.SetRequestTimeout(timeout)
+	gisProxy.SetTLSVerify(tlsverify)
 
 	gisProxy.SetBeforeSendFunc(func(writer http.ResponseWriter, request *http.Request) error {
 		log.Println(lib.GisInfoFromContext(request.Context()))